@@ -16,6 +16,9 @@ func (as *accountSigningKeys) List() []string {
 }
 
 func (as *accountSigningKeys) Add() (string, error) {
+	if err := as.data.checkReadOnly("account"); err != nil {
+		return "", err
+	}
 	k, err := as.data.Operator.SigningService.NewKey(nkeys.PrefixByteAccount)
 	if err != nil {
 		return "", err
@@ -54,6 +57,9 @@ func (as *accountSigningKeys) Contains(sk string) (bool, bool) {
 }
 
 func (as *accountSigningKeys) AddScope(role string) (ScopeLimits, error) {
+	if err := as.data.checkReadOnly("account"); err != nil {
+		return nil, err
+	}
 	k, err := as.data.Operator.SigningService.NewKey(nkeys.PrefixByteAccount)
 	if err != nil {
 		return nil, err
@@ -84,7 +90,10 @@ func (as *accountSigningKeys) GetScopeByRole(role string) ([]ScopeLimits, error)
 	for _, v := range as.data.Claim.SigningKeys {
 		if v != nil {
 			scope, ok := v.(*jwt.UserScope)
-			if ok && scope.Role == role {
+			if !ok || scope.Role != role {
+				continue
+			}
+			if _, retiring := as.data.retiring(scope.Key); !retiring {
 				buf = append(buf, toScopeLimits(as.data, scope))
 			}
 		}
@@ -92,10 +101,29 @@ func (as *accountSigningKeys) GetScopeByRole(role string) ([]ScopeLimits, error)
 	return buf, nil
 }
 
+func (as *accountSigningKeys) SelectScope(role string, strategy ScopeStrategy) (ScopeLimits, error) {
+	scope, err := (&UsersImpl{accountData: as.data}).scopeForRole(role, strategy)
+	if err != nil {
+		return nil, err
+	}
+	return toScopeLimits(as.data, scope), nil
+}
+
 func (as *accountSigningKeys) Delete(key string) (bool, error) {
+	if err := as.data.checkReadOnly("account"); err != nil {
+		return false, err
+	}
 	_, ok := as.data.Claim.SigningKeys[key]
 	if ok {
 		delete(as.data.Claim.SigningKeys, key)
+		as.data.dropRetiring(key)
+		var keys []*Key
+		for _, k := range as.data.AccountSigningKeys {
+			if k.Public != key {
+				keys = append(keys, k)
+			}
+		}
+		as.data.AccountSigningKeys = keys
 		as.data.Operator.DeletedKeys = append(as.data.Operator.DeletedKeys, key)
 		err := as.data.update()
 		if err != nil {
@@ -107,6 +135,9 @@ func (as *accountSigningKeys) Delete(key string) (bool, error) {
 }
 
 func (as *accountSigningKeys) Rotate(key string) (string, error) {
+	if err := as.data.checkReadOnly("account"); err != nil {
+		return "", err
+	}
 	v, ok := as.data.Claim.SigningKeys[key]
 	if ok {
 		k, err := as.data.Operator.SigningService.NewKey(nkeys.PrefixByteAccount)
@@ -128,6 +159,8 @@ func (as *accountSigningKeys) Rotate(key string) (string, error) {
 		if err != nil {
 			return "", err
 		}
+		as.data.Operator.AddedKeys = append(as.data.Operator.AddedKeys, k)
+		as.data.AccountSigningKeys = append(as.data.AccountSigningKeys, k)
 		for _, u := range as.data.UserDatas {
 			if u.Claim.Issuer == key {
 				if err := u.issue(k); err != nil {
@@ -0,0 +1,830 @@
+package authb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+// AccountTemplate describes the configuration of an account so that tenants can
+// be created consistently. Strings in the template can reference parameters as
+// ${param}; the ${name} parameter is always the name of the account. Numeric
+// limits are strings so that quotas can be parameters, and empty values leave
+// the account default.
+type AccountTemplate struct {
+	// Name identifies the template, accounts record the name and version they were created from
+	Name string `json:"name"`
+	// Version of the template, it should increase every time the template changes
+	Version int `json:"version"`
+	// Params are the default values of the parameters. Parameters without a
+	// default must be provided when the template is instantiated.
+	Params map[string]string `json:"params,omitempty"`
+	// Tags added to the account
+	Tags []string `json:"tags,omitempty"`
+	// Limits of the account
+	Limits TemplateLimits `json:"limits,omitempty"`
+	// JetStream limits by tier, tier 0 is the default tier
+	JetStream []TemplateJetStreamLimits `json:"jetstream,omitempty"`
+	// Exports added to the account
+	Exports []TemplateExport `json:"exports,omitempty"`
+	// Imports added to the account
+	Imports []TemplateImport `json:"imports,omitempty"`
+	// Roles are scoped signing keys added to the account
+	Roles []TemplateRole `json:"roles,omitempty"`
+	// Mappings are the subject mappings of the account
+	Mappings map[string][]Mapping `json:"mappings,omitempty"`
+}
+
+// TemplateLimits are the account limits set by a template
+type TemplateLimits struct {
+	MaxConnections         string `json:"max_connections,omitempty"`
+	MaxLeafNodeConnections string `json:"max_leafnode_connections,omitempty"`
+	MaxSubscriptions       string `json:"max_subscriptions,omitempty"`
+	MaxPayload             string `json:"max_payload,omitempty"`
+	MaxData                string `json:"max_data,omitempty"`
+	MaxImports             string `json:"max_imports,omitempty"`
+	MaxExports             string `json:"max_exports,omitempty"`
+}
+
+// TemplateJetStreamLimits are the JetStream limits of a tier set by a template
+type TemplateJetStreamLimits struct {
+	Tier                  int8   `json:"tier"`
+	MaxMemoryStorage      string `json:"max_mem,omitempty"`
+	MaxDiskStorage        string `json:"max_disk,omitempty"`
+	MaxMemoryStreamSize   string `json:"max_mem_stream,omitempty"`
+	MaxDiskStreamSize     string `json:"max_disk_stream,omitempty"`
+	MaxStreams            string `json:"max_streams,omitempty"`
+	MaxConsumers          string `json:"max_consumers,omitempty"`
+	MaxAckPending         string `json:"max_ack_pending,omitempty"`
+	MaxStreamSizeRequired bool   `json:"max_stream_size_required,omitempty"`
+}
+
+// TemplateExport is an export added by a template
+type TemplateExport struct {
+	Name          string `json:"name"`
+	Subject       string `json:"subject"`
+	Service       bool   `json:"service,omitempty"`
+	TokenRequired bool   `json:"token_required,omitempty"`
+}
+
+// TemplateImport is an import added by a template. The account is the name or
+// public key of the exporting account. Activations for exports that require a
+// token are generated when the exporting account is in the same operator.
+type TemplateImport struct {
+	Name         string `json:"name"`
+	Account      string `json:"account"`
+	Subject      string `json:"subject"`
+	LocalSubject string `json:"local_subject,omitempty"`
+	Service      bool   `json:"service,omitempty"`
+	Share        bool   `json:"share,omitempty"`
+}
+
+// TemplateRole is a scoped signing key added by a template. The permissions can
+// use permission templates such as {{name()}}.
+type TemplateRole struct {
+	Role     string   `json:"role"`
+	PubAllow []string `json:"pub_allow,omitempty"`
+	PubDeny  []string `json:"pub_deny,omitempty"`
+	SubAllow []string `json:"sub_allow,omitempty"`
+	SubDeny  []string `json:"sub_deny,omitempty"`
+}
+
+// TemplateRef records the template an account was created from
+type TemplateRef struct {
+	Name    string            `json:"name"`
+	Version int               `json:"version"`
+	Params  map[string]string `json:"params,omitempty"`
+	// Managed are the tags, exports, imports and mappings added by the template.
+	// Elements dropped by a later version of the template are removed from the
+	// account when it is applied. Limits and roles are never removed.
+	Managed []string `json:"managed,omitempty"`
+}
+
+// Template returns a copy of the reference to the template the account was
+// created from, or nil if it wasn't created from a template
+func (a *AccountData) Template() *TemplateRef {
+	if a.Metadata == nil || a.Metadata.Template == nil {
+		return nil
+	}
+	ref := *a.Metadata.Template
+	ref.Params = make(map[string]string, len(a.Metadata.Template.Params))
+	for k, v := range a.Metadata.Template.Params {
+		ref.Params[k] = v
+	}
+	ref.Managed = append([]string(nil), a.Metadata.Template.Managed...)
+	return &ref
+}
+
+// AddFromTemplate adds an account configured by the template, the account is
+// not added if the template can't be applied
+func (o *OperatorData) AddFromTemplate(name string, tmpl *AccountTemplate, params map[string]string) (Account, error) {
+	if err := tmpl.validate(); err != nil {
+		return nil, err
+	}
+	if _, err := o.Get(name); err == nil {
+		return nil, fmt.Errorf("account %q already exists", name)
+	}
+	// resolve the parameters first, so that no account is created if any is missing
+	if _, err := tmpl.params(name, params); err != nil {
+		return nil, err
+	}
+	snapshot := o.templateSnapshot()
+	a, err := o.Add(name)
+	if err != nil {
+		return nil, err
+	}
+	ad := a.(*AccountData)
+	if err := tmpl.apply(ad, params); err != nil {
+		// forget the account and its keys, as they were not stored
+		snapshot.restore()
+		return nil, fmt.Errorf("error applying template %q: %w", tmpl.Name, err)
+	}
+	return ad, nil
+}
+
+// ApplyTemplate re-applies the template to the accounts created from an older
+// version, if it fails on any account all the accounts are restored
+func (o *OperatorData) ApplyTemplate(tmpl *AccountTemplate) ([]Account, error) {
+	if err := tmpl.validate(); err != nil {
+		return nil, err
+	}
+	// the template is expanded for every account first, so that no account is
+	// modified if it can't be applied to any of them
+	type pending struct {
+		tt     *templater
+		x      *AccountTemplate
+		params map[string]string
+	}
+	var accounts []pending
+	for _, a := range o.AccountDatas {
+		ref := a.Template()
+		if ref == nil || ref.Name != tmpl.Name || ref.Version >= tmpl.Version {
+			continue
+		}
+		tt, x, err := tmpl.prepare(a, ref.Params)
+		if err != nil {
+			return nil, fmt.Errorf("error applying template %q to account %q: %w", tmpl.Name, a.EntityName, err)
+		}
+		accounts = append(accounts, pending{tt: tt, x: x, params: ref.Params})
+	}
+	var updated []Account
+	var datas []*AccountData
+	for _, p := range accounts {
+		datas = append(datas, p.tt.data)
+	}
+	snapshot := o.templateSnapshot(datas...)
+	for _, p := range accounts {
+		if err := p.tt.apply(p.x, p.params); err != nil {
+			snapshot.restore()
+			return nil, fmt.Errorf("error applying template %q to account %q: %w", tmpl.Name, p.tt.data.EntityName, err)
+		}
+		updated = append(updated, p.tt.data)
+	}
+	return updated, nil
+}
+
+// templateSnapshot is the state of an operator and the accounts a template is
+// applied to, restored when the template can't be applied
+type templateSnapshot struct {
+	o            *OperatorData
+	operator     BaseData
+	accountDatas []*AccountData
+	addedKeys    []*Key
+	deletedKeys  []string
+	accounts     map[*AccountData]templateAccountState
+}
+
+type templateAccountState struct {
+	base        BaseData
+	metadata    *EntityMetadata
+	signingKeys []*Key
+}
+
+func (o *OperatorData) templateSnapshot(accounts ...*AccountData) *templateSnapshot {
+	s := &templateSnapshot{
+		o:            o,
+		operator:     o.BaseData,
+		accountDatas: append([]*AccountData(nil), o.AccountDatas...),
+		addedKeys:    append([]*Key(nil), o.AddedKeys...),
+		deletedKeys:  append([]string(nil), o.DeletedKeys...),
+		accounts:     make(map[*AccountData]templateAccountState),
+	}
+	for _, a := range accounts {
+		state := templateAccountState{base: a.BaseData, signingKeys: append([]*Key(nil), a.AccountSigningKeys...)}
+		if a.Metadata != nil {
+			m := *a.Metadata
+			state.metadata = &m
+		}
+		s.accounts[a] = state
+	}
+	return s
+}
+
+// restore reverts the operator and the accounts, the claims are decoded from
+// the tokens as they are always up-to-date
+func (s *templateSnapshot) restore() {
+	o := s.o
+	o.BaseData = s.operator
+	o.Claim, _ = jwt.DecodeOperatorClaims(o.Token)
+	o.AccountDatas = s.accountDatas
+	o.AddedKeys = s.addedKeys
+	o.DeletedKeys = s.deletedKeys
+	for a, state := range s.accounts {
+		a.BaseData = state.base
+		a.Metadata = state.metadata
+		a.AccountSigningKeys = state.signingKeys
+		a.Claim, _ = jwt.DecodeAccountClaims(a.Token)
+	}
+}
+
+func (t *AccountTemplate) validate() error {
+	if t == nil {
+		return errors.New("template is required")
+	}
+	if t.Name == "" {
+		return errors.New("template name is required")
+	}
+	if t.Version < 1 {
+		return fmt.Errorf("template %q has an invalid version %d", t.Name, t.Version)
+	}
+	return nil
+}
+
+// params returns the parameter values merged with the template defaults,
+// and checks that all the parameters referenced by the template have a value
+func (t *AccountTemplate) params(name string, params map[string]string) (map[string]string, error) {
+	values := make(map[string]string)
+	for k, v := range t.Params {
+		values[k] = v
+	}
+	for k, v := range params {
+		values[k] = v
+	}
+	values["name"] = name
+	var missing []string
+	for _, p := range t.references() {
+		if _, ok := values[p]; !ok {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("template %q requires the parameters: %s", t.Name, strings.Join(missing, ", "))
+	}
+	return values, nil
+}
+
+// references returns the sorted names of the parameters used by the template
+func (t *AccountTemplate) references() []string {
+	var fields []string
+	fields = append(fields, t.Tags...)
+	fields = append(fields, t.Limits.MaxConnections, t.Limits.MaxLeafNodeConnections, t.Limits.MaxSubscriptions,
+		t.Limits.MaxPayload, t.Limits.MaxData, t.Limits.MaxImports, t.Limits.MaxExports)
+	for _, js := range t.JetStream {
+		fields = append(fields, js.MaxMemoryStorage, js.MaxDiskStorage, js.MaxMemoryStreamSize,
+			js.MaxDiskStreamSize, js.MaxStreams, js.MaxConsumers, js.MaxAckPending)
+	}
+	for _, e := range t.Exports {
+		fields = append(fields, e.Name, e.Subject)
+	}
+	for _, i := range t.Imports {
+		fields = append(fields, i.Name, i.Account, i.Subject, i.LocalSubject)
+	}
+	for _, r := range t.Roles {
+		fields = append(fields, r.Role)
+		fields = append(fields, r.PubAllow...)
+		fields = append(fields, r.PubDeny...)
+		fields = append(fields, r.SubAllow...)
+		fields = append(fields, r.SubDeny...)
+	}
+	for subject, mappings := range t.Mappings {
+		fields = append(fields, subject)
+		for _, m := range mappings {
+			fields = append(fields, m.Subject, m.Cluster)
+		}
+	}
+	seen := make(map[string]bool)
+	var refs []string
+	for _, f := range fields {
+		for {
+			start := strings.Index(f, "${")
+			if start == -1 {
+				break
+			}
+			end := strings.Index(f[start:], "}")
+			if end == -1 {
+				break
+			}
+			p := f[start+2 : start+end]
+			if !seen[p] {
+				seen[p] = true
+				refs = append(refs, p)
+			}
+			f = f[start+end+1:]
+		}
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// templater applies a template to an account
+type templater struct {
+	data    *AccountData
+	values  map[string]string
+	managed []string
+}
+
+// expand replaces the parameters in one left-to-right pass, the values are
+// not expanded again. It fails if a parameter is not defined.
+func (t *templater) expand(s string) (string, error) {
+	var buf strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start == -1 {
+			break
+		}
+		end := strings.Index(s[start:], "}")
+		if end == -1 {
+			break
+		}
+		p := s[start+2 : start+end]
+		v, ok := t.values[p]
+		if !ok {
+			return "", fmt.Errorf("parameter %q is not defined", p)
+		}
+		buf.WriteString(s[:start])
+		buf.WriteString(v)
+		s = s[start+end+1:]
+	}
+	buf.WriteString(s)
+	return buf.String(), nil
+}
+
+// expandTemplate returns a copy of the template with the parameters expanded,
+// and checks the limits and the exporting accounts, so that a template that
+// can't be applied fails before the account is modified
+func (t *templater) expandTemplate(tmpl *AccountTemplate) (*AccountTemplate, error) {
+	var err error
+	str := func(s string) string {
+		if err != nil {
+			return ""
+		}
+		var v string
+		v, err = t.expand(s)
+		return v
+	}
+	strs := func(v []string) []string {
+		if v == nil {
+			return nil
+		}
+		buf := make([]string, len(v))
+		for i, s := range v {
+			buf[i] = str(s)
+		}
+		return buf
+	}
+
+	x := *tmpl
+	x.Tags = strs(tmpl.Tags)
+	l := tmpl.Limits
+	x.Limits = TemplateLimits{
+		MaxConnections:         str(l.MaxConnections),
+		MaxLeafNodeConnections: str(l.MaxLeafNodeConnections),
+		MaxSubscriptions:       str(l.MaxSubscriptions),
+		MaxPayload:             str(l.MaxPayload),
+		MaxData:                str(l.MaxData),
+		MaxImports:             str(l.MaxImports),
+		MaxExports:             str(l.MaxExports),
+	}
+	limits := []string{x.Limits.MaxConnections, x.Limits.MaxLeafNodeConnections, x.Limits.MaxSubscriptions,
+		x.Limits.MaxPayload, x.Limits.MaxData, x.Limits.MaxImports, x.Limits.MaxExports}
+	x.JetStream = nil
+	for _, js := range tmpl.JetStream {
+		v := js
+		v.MaxMemoryStorage = str(js.MaxMemoryStorage)
+		v.MaxDiskStorage = str(js.MaxDiskStorage)
+		v.MaxMemoryStreamSize = str(js.MaxMemoryStreamSize)
+		v.MaxDiskStreamSize = str(js.MaxDiskStreamSize)
+		v.MaxStreams = str(js.MaxStreams)
+		v.MaxConsumers = str(js.MaxConsumers)
+		v.MaxAckPending = str(js.MaxAckPending)
+		limits = append(limits, v.MaxMemoryStorage, v.MaxDiskStorage, v.MaxMemoryStreamSize,
+			v.MaxDiskStreamSize, v.MaxStreams, v.MaxConsumers, v.MaxAckPending)
+		x.JetStream = append(x.JetStream, v)
+	}
+	x.Exports = nil
+	for _, e := range tmpl.Exports {
+		e.Name, e.Subject = str(e.Name), str(e.Subject)
+		x.Exports = append(x.Exports, e)
+	}
+	x.Imports = nil
+	for _, i := range tmpl.Imports {
+		i.Name, i.Account, i.Subject, i.LocalSubject = str(i.Name), str(i.Account), str(i.Subject), str(i.LocalSubject)
+		x.Imports = append(x.Imports, i)
+	}
+	x.Roles = nil
+	for _, r := range tmpl.Roles {
+		x.Roles = append(x.Roles, TemplateRole{
+			Role:     str(r.Role),
+			PubAllow: strs(r.PubAllow),
+			PubDeny:  strs(r.PubDeny),
+			SubAllow: strs(r.SubAllow),
+			SubDeny:  strs(r.SubDeny),
+		})
+	}
+	x.Mappings = nil
+	if tmpl.Mappings != nil {
+		x.Mappings = make(map[string][]Mapping, len(tmpl.Mappings))
+		for subject, mappings := range tmpl.Mappings {
+			buf := make([]Mapping, len(mappings))
+			for i, m := range mappings {
+				buf[i] = Mapping{Subject: str(m.Subject), Weight: m.Weight, Cluster: str(m.Cluster)}
+			}
+			x.Mappings[str(subject)] = buf
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range limits {
+		if v == "" {
+			continue
+		}
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid limit %q: %w", v, err)
+		}
+	}
+	for _, i := range x.Imports {
+		if _, err := t.exporter(i.Account); err != nil {
+			return nil, fmt.Errorf("import %q: %w", i.Subject, err)
+		}
+	}
+	return &x, nil
+}
+
+// set parses the value and sets it, empty values are skipped
+func (t *templater) set(v string, set func(int64) error) error {
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid limit %q: %w", v, err)
+	}
+	return set(n)
+}
+
+// prepare returns the templater for the account and the expanded template,
+// without modifying the account
+func (t *AccountTemplate) prepare(a *AccountData, params map[string]string) (*templater, *AccountTemplate, error) {
+	values, err := t.params(a.EntityName, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	tt := &templater{data: a, values: values}
+	x, err := tt.expandTemplate(t)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tt, x, nil
+}
+
+func (t *AccountTemplate) apply(a *AccountData, params map[string]string) error {
+	tt, x, err := t.prepare(a, params)
+	if err != nil {
+		return err
+	}
+	return tt.apply(x, params)
+}
+
+// apply applies the expanded template to the account
+func (t *templater) apply(tmpl *AccountTemplate, params map[string]string) error {
+	var previous []string
+	if ref := t.data.Template(); ref != nil {
+		previous = ref.Managed
+	}
+	steps := []func(*AccountTemplate) error{
+		t.applyTags,
+		t.applyLimits,
+		t.applyJetStream,
+		t.applyExports,
+		t.applyImports,
+		t.applyRoles,
+		t.applyMappings,
+	}
+	for _, step := range steps {
+		if err := step(tmpl); err != nil {
+			return err
+		}
+	}
+	if err := t.removeDropped(previous); err != nil {
+		return err
+	}
+	// the provided parameters are kept, so later versions can change the defaults
+	ref := &TemplateRef{Name: tmpl.Name, Version: tmpl.Version, Params: make(map[string]string, len(params))}
+	for k, v := range params {
+		ref.Params[k] = v
+	}
+	sort.Strings(t.managed)
+	ref.Managed = t.managed
+	t.data.metadata().Template = ref
+	t.data.Modified = true
+	return nil
+}
+
+func (t *templater) applyTags(tmpl *AccountTemplate) error {
+	for _, tag := range tmpl.Tags {
+		if err := t.data.Tags().Add(tag); err != nil {
+			return err
+		}
+		t.managed = append(t.managed, "tag:"+tag)
+	}
+	return nil
+}
+
+func (t *templater) applyLimits(tmpl *AccountTemplate) error {
+	lim := t.data.Limits()
+	setters := []struct {
+		v   string
+		set func(int64) error
+	}{
+		{tmpl.Limits.MaxConnections, lim.SetMaxConnections},
+		{tmpl.Limits.MaxLeafNodeConnections, lim.SetMaxLeafNodeConnections},
+		{tmpl.Limits.MaxSubscriptions, lim.SetMaxSubscriptions},
+		{tmpl.Limits.MaxPayload, lim.SetMaxPayload},
+		{tmpl.Limits.MaxData, lim.SetMaxData},
+		{tmpl.Limits.MaxImports, lim.SetMaxImports},
+		{tmpl.Limits.MaxExports, lim.SetMaxExports},
+	}
+	for _, s := range setters {
+		if err := t.set(s.v, s.set); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *templater) applyJetStream(tmpl *AccountTemplate) error {
+	tiers := t.data.Limits().JetStream()
+	for _, js := range tmpl.JetStream {
+		lim, err := tiers.Get(js.Tier)
+		if err != nil {
+			return err
+		}
+		if lim == nil {
+			if lim, err = tiers.Add(js.Tier); err != nil {
+				return err
+			}
+		}
+		setters := []struct {
+			v   string
+			set func(int64) error
+		}{
+			{js.MaxMemoryStorage, lim.SetMaxMemoryStorage},
+			{js.MaxDiskStorage, lim.SetMaxDiskStorage},
+			{js.MaxMemoryStreamSize, lim.SetMaxMemoryStreamSize},
+			{js.MaxDiskStreamSize, lim.SetMaxDiskStreamSize},
+			{js.MaxStreams, lim.SetMaxStreams},
+			{js.MaxConsumers, lim.SetMaxConsumers},
+			{js.MaxAckPending, lim.SetMaxAckPending},
+		}
+		for _, s := range setters {
+			if err := t.set(s.v, s.set); err != nil {
+				return fmt.Errorf("jetstream tier %d: %w", js.Tier, err)
+			}
+		}
+		if err := lim.SetMaxStreamSizeRequired(js.MaxStreamSizeRequired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *templater) applyExports(tmpl *AccountTemplate) error {
+	for _, e := range tmpl.Exports {
+		name, subject := e.Name, e.Subject
+		var export Export
+		var err error
+		if e.Service {
+			var se ServiceExport
+			if se, err = t.data.Exports().Services().Get(subject); errors.Is(err, ErrNotFound) {
+				se, err = t.data.Exports().Services().Add(name, subject)
+			}
+			export = se
+			t.managed = append(t.managed, "service-export:"+subject)
+		} else {
+			var se StreamExport
+			if se, err = t.data.Exports().Streams().Get(subject); errors.Is(err, ErrNotFound) {
+				se, err = t.data.Exports().Streams().Add(name, subject)
+			}
+			export = se
+			t.managed = append(t.managed, "stream-export:"+subject)
+		}
+		if err != nil {
+			return fmt.Errorf("export %q: %w", subject, err)
+		}
+		if err := export.SetName(name); err != nil {
+			return err
+		}
+		if err := export.SetTokenRequired(e.TokenRequired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *templater) applyImports(tmpl *AccountTemplate) error {
+	for _, i := range tmpl.Imports {
+		name, subject := i.Name, i.Subject
+		account, err := t.exporter(i.Account)
+		if err != nil {
+			return fmt.Errorf("import %q: %w", subject, err)
+		}
+		var imp Import
+		if i.Service {
+			if imp = t.findImport(i.Service, account, subject); imp == nil {
+				imp, err = t.data.Imports().Services().Add(name, account, subject)
+			}
+			t.managed = append(t.managed, "service-import:"+account+":"+subject)
+		} else {
+			if imp = t.findImport(i.Service, account, subject); imp == nil {
+				imp, err = t.data.Imports().Streams().Add(name, account, subject)
+			}
+			t.managed = append(t.managed, "stream-import:"+account+":"+subject)
+		}
+		if err != nil {
+			return fmt.Errorf("import %q: %w", subject, err)
+		}
+		if err := imp.SetName(name); err != nil {
+			return err
+		}
+		if err := imp.SetLocalSubject(i.LocalSubject); err != nil {
+			return err
+		}
+		if err := imp.SetShareConnectionInfo(i.Share); err != nil {
+			return err
+		}
+		if err := t.activate(i.Service, account, subject, imp); err != nil {
+			return fmt.Errorf("import %q: %w", subject, err)
+		}
+	}
+	return nil
+}
+
+// exporter resolves the name or public key of the exporting account
+func (t *templater) exporter(account string) (string, error) {
+	if a, err := t.data.Operator.Get(account); err == nil {
+		return a.Subject(), nil
+	}
+	if !nkeys.IsValidPublicAccountKey(account) {
+		return "", fmt.Errorf("account %q was not found", account)
+	}
+	return account, nil
+}
+
+func (t *templater) findImport(service bool, account string, subject string) Import {
+	if service {
+		for _, i := range t.data.Imports().Services().List() {
+			if i.Account() == account && i.Subject() == subject {
+				return i
+			}
+		}
+		return nil
+	}
+	for _, i := range t.data.Imports().Streams().List() {
+		if i.Account() == account && i.Subject() == subject {
+			return i
+		}
+	}
+	return nil
+}
+
+// activate sets an activation token on the import if the export requires one
+// and the exporting account is in the operator
+func (t *templater) activate(service bool, account string, subject string, imp Import) error {
+	exporter := t.data.Operator.accountData(account)
+	if exporter == nil {
+		return nil
+	}
+	var export Export
+	if service {
+		for _, e := range exporter.Exports().Services().List() {
+			if importMatches(subject, e.Subject()) {
+				export = e
+				break
+			}
+		}
+	} else {
+		for _, e := range exporter.Exports().Streams().List() {
+			if importMatches(subject, e.Subject()) {
+				export = e
+				break
+			}
+		}
+	}
+	if export == nil || !export.TokenRequired() {
+		return imp.SetToken("")
+	}
+	token, err := export.GenerateActivation(t.data.Subject(), exporter.Subject())
+	if err != nil {
+		return err
+	}
+	return imp.SetToken(token)
+}
+
+func (t *templater) applyRoles(tmpl *AccountTemplate) error {
+	sks := t.data.ScopedSigningKeys()
+	for _, r := range tmpl.Roles {
+		role := r.Role
+		scopes, err := sks.GetScopeByRole(role)
+		if err != nil {
+			return err
+		}
+		if len(scopes) == 0 {
+			scope, err := sks.AddScope(role)
+			if err != nil {
+				return err
+			}
+			scopes = []ScopeLimits{scope}
+		}
+		for _, s := range scopes {
+			key := s.Key()
+			// the scope is looked up for every edit, as edits reissue the account
+			scope := func() ScopeLimits {
+				v, _ := sks.GetScope(key)
+				return v
+			}
+			edits := []func() error{
+				func() error { return scope().PubPermissions().SetAllow(r.PubAllow...) },
+				func() error { return scope().PubPermissions().SetDeny(r.PubDeny...) },
+				func() error { return scope().SubPermissions().SetAllow(r.SubAllow...) },
+				func() error { return scope().SubPermissions().SetDeny(r.SubDeny...) },
+			}
+			for _, edit := range edits {
+				if err := edit(); err != nil {
+					return fmt.Errorf("role %q: %w", role, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (t *templater) applyMappings(tmpl *AccountTemplate) error {
+	for subject, mappings := range tmpl.Mappings {
+		if err := t.data.SubjectMappings().Set(subject, mappings...); err != nil {
+			return fmt.Errorf("mapping %q: %w", subject, err)
+		}
+		t.managed = append(t.managed, "mapping:"+subject)
+	}
+	return nil
+}
+
+// removeDropped removes the elements added by a previous version of the
+// template that the current version no longer has
+func (t *templater) removeDropped(previous []string) error {
+	current := make(map[string]bool, len(t.managed))
+	for _, m := range t.managed {
+		current[m] = true
+	}
+	for _, m := range previous {
+		if current[m] {
+			continue
+		}
+		kind, v, _ := strings.Cut(m, ":")
+		var err error
+		switch kind {
+		case "tag":
+			_, err = t.data.Tags().Remove(v)
+		case "stream-export":
+			_, err = t.data.Exports().Streams().Delete(v)
+		case "service-export":
+			_, err = t.data.Exports().Services().Delete(v)
+		case "stream-import", "service-import":
+			account, subject, _ := strings.Cut(v, ":")
+			err = t.deleteImport(kind == "service-import", account, subject)
+		case "mapping":
+			err = t.data.SubjectMappings().Delete(v)
+		}
+		if err != nil {
+			return fmt.Errorf("error removing %s: %w", m, err)
+		}
+	}
+	return nil
+}
+
+func (t *templater) deleteImport(service bool, account string, subject string) error {
+	imports := t.data.Claim.Imports
+	for idx, i := range imports {
+		if i.IsService() == service && i.Account == account && string(i.Subject) == subject {
+			t.data.Claim.Imports = append(imports[:idx:idx], imports[idx+1:]...)
+			return t.data.update()
+		}
+	}
+	return nil
+}
@@ -3,11 +3,13 @@ package authb
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/nats-io/jwt/v2"
 	"github.com/nats-io/nkeys"
 )
 
+// NewAccountFromJWT returns a read-only Account for inspecting the JWT
 func NewAccountFromJWT(token string) (Account, error) {
 	ac, err := jwt.DecodeAccountClaims(token)
 	if err != nil {
@@ -39,6 +41,9 @@ func (a *AccountData) issue(key *Key) error {
 	if err != nil {
 		return err
 	}
+	if token, err = a.Operator.budget.check(a, token, key); err != nil {
+		return err
+	}
 	claim, err := jwt.DecodeAccountClaims(token)
 	if err != nil {
 		return err
@@ -69,6 +74,10 @@ func (a *AccountData) SetIssuer(issuer string) error {
 		}
 	}
 
+	if err := a.checkReadOnly("account"); err != nil {
+		return err
+	}
+	issuer = a.Operator.replacement(issuer)
 	found := issuer == "" || a.Operator.Key.Public == issuer
 	if !found {
 		for i := 0; i < len(a.Operator.OperatorSigningKeys); i++ {
@@ -87,8 +96,10 @@ func (a *AccountData) SetIssuer(issuer string) error {
 }
 
 func (a *AccountData) update() error {
-	if a.BaseData.readOnly {
-		return fmt.Errorf("account is read-only")
+	if err := a.checkReadOnly("account"); err != nil {
+		// discard the edit
+		a.Claim, _ = jwt.DecodeAccountClaims(a.Token)
+		return err
 	}
 
 	var vr jwt.ValidationResults
@@ -122,6 +133,44 @@ func (a *AccountData) getRevocationPrefix() nkeys.PrefixByte {
 	return nkeys.PrefixByteUser
 }
 
+func (a *AccountData) getToken() string {
+	return a.Token
+}
+
+// expiredRevocations returns the revoked users whose known JWT expired. It
+// assumes the stored JWT is the longest-lived one issued to the user.
+func (a *AccountData) expiredRevocations(now time.Time) []string {
+	var buf []string
+	users := a.userIndex().bySubject
+	for pk := range a.Claim.Revocations {
+		u, ok := users[pk]
+		if !ok {
+			continue
+		}
+		if u.Claim.Expires > 0 && u.Claim.Expires <= now.Unix() {
+			buf = append(buf, pk)
+		}
+	}
+	return buf
+}
+
+// prunedRevocations returns a copy of the revocations without the ones for users
+// whose known JWT expired, or nil if there are none to prune
+func (a *AccountData) prunedRevocations(now time.Time) jwt.RevocationList {
+	pks := a.expiredRevocations(now)
+	if len(pks) == 0 {
+		return nil
+	}
+	list := make(jwt.RevocationList, len(a.Claim.Revocations))
+	for pk, at := range a.Claim.Revocations {
+		list[pk] = at
+	}
+	for _, pk := range pks {
+		delete(list, pk)
+	}
+	return list
+}
+
 func (a *AccountData) Revocations() Revocations {
 	return &revocations{data: a}
 }
@@ -159,6 +208,9 @@ func (a *AccountData) Users() Users {
 }
 
 func (a *AccountData) getKey(key string) (*Key, bool, error) {
+	if err := a.checkReadOnly("account"); err != nil {
+		return nil, false, err
+	}
 	if key == a.Key.Public {
 		return a.Key, false, nil
 	}
@@ -242,6 +294,9 @@ func (a *AccountData) IssueAuthorizationResponse(claim *jwt.AuthorizationRespons
 }
 
 func (a *AccountData) IssueClaim(claim jwt.Claims, key string) (string, error) {
+	if err := a.checkReadOnly("account"); err != nil {
+		return "", err
+	}
 	if key == "" {
 		key = a.Key.Public
 	}
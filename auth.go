@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"time"
 
 	"github.com/nats-io/jwt/v2"
 	"github.com/nats-io/nkeys"
@@ -14,6 +15,14 @@ type KeysFn func(p nkeys.PrefixByte) (*Key, error)
 type Options struct {
 	SignFn jwt.SignFn
 	KeysFn KeysFn
+	// AccountSizeBudget is the maximum size in bytes of account JWTs, zero is
+	// unlimited. When an account exceeds it, revocations for users whose stored
+	// JWT expired are pruned, see Revocations.Prune. If it still exceeds the
+	// budget, the change fails with ErrSizeBudgetExceeded unless BudgetFn allows it.
+	AccountSizeBudget int
+	// BudgetFn is called when an account JWT exceeds the size budget. Returning
+	// nil allows the change, for example after logging a warning.
+	BudgetFn func(account Account, size int) error
 }
 
 type IssuingService interface {
@@ -54,6 +63,7 @@ func NewAuthWithOptions(provider AuthProvider, opts *Options) (*AuthImpl, error)
 func (a *AuthImpl) initSigningService() {
 	for _, op := range a.operators {
 		op.SigningService = a
+		op.budget = a.budget()
 	}
 }
 
@@ -73,6 +83,45 @@ func (a *AuthImpl) NewKey(prefixByte nkeys.PrefixByte) (*Key, error) {
 	return a.opts.KeysFn(prefixByte)
 }
 
+// sizeBudget limits the size of account JWTs
+type sizeBudget struct {
+	size int
+	fn   func(account Account, size int) error
+}
+
+// budget returns the size budget configured by the options, nil if unlimited
+func (a *AuthImpl) budget() *sizeBudget {
+	if a.opts.AccountSizeBudget <= 0 {
+		return nil
+	}
+	return &sizeBudget{size: a.opts.AccountSizeBudget, fn: a.opts.BudgetFn}
+}
+
+// check returns the account token if it fits the budget. Otherwise the claim is
+// re-signed with the specified key without the revocations of users known to
+// have expired. The account is not modified, the caller applies the token.
+func (b *sizeBudget) check(ad *AccountData, token string, key *Key) (string, error) {
+	if b == nil || len(token) <= b.size {
+		return token, nil
+	}
+	if revocations := ad.prunedRevocations(time.Now()); revocations != nil {
+		claim := *ad.Claim
+		claim.Revocations = revocations
+		pruned, err := ad.Operator.SigningService.Sign(&claim, key)
+		if err != nil {
+			return "", err
+		}
+		token = pruned
+		if len(token) <= b.size {
+			return token, nil
+		}
+	}
+	if b.fn != nil {
+		return token, b.fn(ad, len(token))
+	}
+	return "", fmt.Errorf("%w: account %q is %d bytes, the budget is %d", ErrSizeBudgetExceeded, ad.EntityName, len(token), b.size)
+}
+
 type OperatorsImpl struct {
 	auth *AuthImpl
 }
@@ -119,7 +168,7 @@ func (a *OperatorsImpl) Get(name string) (Operator, error) {
 
 func (a *OperatorsImpl) Add(name string) (Operator, error) {
 	var err error
-	data := &OperatorData{SigningService: a.auth}
+	data := &OperatorData{SigningService: a.auth, budget: a.auth.budget()}
 	data.EntityName = name
 	data.Key, err = data.SigningService.NewKey(nkeys.PrefixByteOperator)
 	if err != nil {
@@ -174,7 +223,7 @@ func (a *OperatorsImpl) Import(token []byte, keys []string) (Operator, error) {
 	}
 
 	var ok bool
-	data := &OperatorData{SigningService: a.auth}
+	data := &OperatorData{SigningService: a.auth, budget: a.auth.budget()}
 	data.Claim = claim
 	data.EntityName = claim.Name
 	data.Key, ok = m[claim.Subject]
@@ -0,0 +1,290 @@
+package authb
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nkeys"
+)
+
+const backupVersion = 1
+
+const backupManifest = "manifest.json"
+
+// BackupOptions customize the archive created by Backup
+type BackupOptions struct {
+	// ExcludeSeeds omits the seeds, the archive only has the JWTs and metadata
+	ExcludeSeeds bool
+	// Recipient is the public curve key the seeds are sealed to. If empty, the
+	// seeds are stored in the clear.
+	Recipient string
+}
+
+// RestoreOptions customize how an archive is read by Restore and VerifyBackup
+type RestoreOptions struct {
+	// Key is the curve seed of the recipient the seeds were sealed to
+	Key string
+}
+
+// BackupManifest describes the files in a backup archive
+type BackupManifest struct {
+	// Version is the version of the archive format
+	Version int `json:"version"`
+	// Created is the time (UTC in seconds) the archive was created
+	Created int64 `json:"created"`
+	// Operators are the public keys of the operators in the archive
+	Operators []string `json:"operators"`
+	// Recipient is the public curve key the seeds are sealed to
+	Recipient string `json:"recipient,omitempty"`
+	// Sender is the public curve key that sealed the seeds
+	Sender string `json:"sender,omitempty"`
+	// Checksums are the hex encoded SHA-256 of the files by path
+	Checksums map[string]string `json:"checksums"`
+}
+
+// Backup writes a tar archive with the JWTs, metadata and seeds of all the
+// operators in the provider. Each operator is stored under operators/<operator>/
+// using the layout of a bundle, seeds are stored as keys/<public key>.nk, or as
+// keys/<public key>.sealed when sealed to a recipient. Ephemeral users are not
+// included, as they are not stored.
+func Backup(provider AuthProvider, w io.Writer, opts *BackupOptions) error {
+	if opts == nil {
+		opts = &BackupOptions{}
+	}
+	if opts.Recipient != "" && !nkeys.IsValidPublicCurveKey(opts.Recipient) {
+		return fmt.Errorf("invalid recipient curve key %q", opts.Recipient)
+	}
+	operators, err := provider.Load()
+	if err != nil {
+		return err
+	}
+	m := BackupManifest{
+		Version:   backupVersion,
+		Created:   time.Now().Unix(),
+		Recipient: opts.Recipient,
+		Checksums: make(map[string]string),
+	}
+	var sender nkeys.KeyPair
+	if opts.Recipient != "" {
+		if sender, err = nkeys.CreateCurveKeys(); err != nil {
+			return err
+		}
+		if m.Sender, err = sender.PublicKey(); err != nil {
+			return err
+		}
+	}
+
+	files := make(map[string][]byte)
+	for _, od := range operators {
+		m.Operators = append(m.Operators, od.Subject())
+		of, err := bundleFiles(od, func(_ BundleSeeds, k *Key) (string, string, error) {
+			if opts.ExcludeSeeds {
+				return "", "", nil
+			}
+			if sender == nil {
+				return "keys/" + k.Public + ".nk", string(k.Seed), nil
+			}
+			sealed, err := sender.Seal(k.Seed, opts.Recipient)
+			if err != nil {
+				return "", "", err
+			}
+			return "keys/" + k.Public + ".sealed", string(sealed), nil
+		})
+		if err != nil {
+			return err
+		}
+		for path, content := range of {
+			files["operators/"+od.Subject()+"/"+path] = []byte(content)
+		}
+	}
+
+	paths := make([]string, 0, len(files))
+	for path, content := range files {
+		m.Checksums[path] = checksum(string(content))
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	write := func(path string, content []byte) error {
+		h := &tar.Header{
+			Name:    path,
+			Mode:    0o600,
+			Size:    int64(len(content)),
+			ModTime: time.Unix(m.Created, 0),
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+	if err := write(backupManifest, manifest); err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := write(path, files[path]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// backupArchive is a backup read and checked against its manifest
+type backupArchive struct {
+	manifest BackupManifest
+	// bundles are the files of each operator, with the seeds opened
+	bundles map[string]*bundleFile
+}
+
+func readBackup(r io.Reader, opts *RestoreOptions) (*backupArchive, error) {
+	if opts == nil {
+		opts = &RestoreOptions{}
+	}
+	files := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup: %w", err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if _, ok := files[h.Name]; ok {
+			return nil, fmt.Errorf("backup file %q is duplicated", h.Name)
+		}
+		if files[h.Name], err = io.ReadAll(tr); err != nil {
+			return nil, fmt.Errorf("invalid backup: %w", err)
+		}
+	}
+
+	var ba backupArchive
+	d, ok := files[backupManifest]
+	if !ok {
+		return nil, errors.New("backup has no manifest")
+	}
+	delete(files, backupManifest)
+	if err := json.Unmarshal(d, &ba.manifest); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %w", err)
+	}
+	m := ba.manifest
+	if m.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", m.Version)
+	}
+	for path, content := range files {
+		sum, ok := m.Checksums[path]
+		if !ok {
+			return nil, fmt.Errorf("backup file %q is not in the manifest", path)
+		}
+		if sum != checksum(string(content)) {
+			return nil, fmt.Errorf("backup file %q doesn't match its checksum", path)
+		}
+	}
+	for path := range m.Checksums {
+		if _, ok := files[path]; !ok {
+			return nil, fmt.Errorf("backup file %q is missing", path)
+		}
+	}
+
+	var recipient nkeys.KeyPair
+	ba.bundles = make(map[string]*bundleFile, len(m.Operators))
+	for _, opk := range m.Operators {
+		ba.bundles[opk] = &bundleFile{Version: bundleVersion, Files: make(map[string]string)}
+	}
+	for path, content := range files {
+		parts := strings.SplitN(path, "/", 3)
+		if len(parts) != 3 || parts[0] != "operators" || ba.bundles[parts[1]] == nil {
+			return nil, fmt.Errorf("backup file %q doesn't belong to an operator", path)
+		}
+		b, name := ba.bundles[parts[1]], parts[2]
+		if strings.HasPrefix(name, "keys/") && strings.HasSuffix(name, ".sealed") {
+			if recipient == nil {
+				if opts.Key == "" {
+					return nil, fmt.Errorf("backup seeds are sealed to %s, its curve seed is required", m.Recipient)
+				}
+				kp, err := nkeys.FromCurveSeed([]byte(opts.Key))
+				if err != nil {
+					return nil, err
+				}
+				if pk, _ := kp.PublicKey(); pk != m.Recipient {
+					return nil, fmt.Errorf("backup seeds are sealed to %s", m.Recipient)
+				}
+				recipient = kp
+			}
+			seed, err := recipient.Open(content, m.Sender)
+			if err != nil {
+				return nil, fmt.Errorf("unable to open %q: %w", path, err)
+			}
+			name = strings.TrimSuffix(name, ".sealed") + ".nk"
+			content = seed
+		}
+		b.Files[name] = string(content)
+	}
+	return &ba, nil
+}
+
+// load adds the operators in the archive to auth, decoding every claim and
+// checking every seed
+func (ba *backupArchive) load(auth *AuthImpl) error {
+	operators := &OperatorsImpl{auth: auth}
+	for _, opk := range ba.manifest.Operators {
+		od, err := operators.importBundle(ba.bundles[opk])
+		if err != nil {
+			return fmt.Errorf("operator %s: %w", opk, err)
+		}
+		if od.Subject() != opk {
+			return fmt.Errorf("operator %s doesn't match its JWT", opk)
+		}
+	}
+	return nil
+}
+
+// VerifyBackup checks an archive created by Backup without restoring it. Every
+// file is checked against the manifest, every JWT is decoded and checked against
+// its issuer, and every seed is checked against its public key.
+func VerifyBackup(r io.Reader, opts *RestoreOptions) (*BackupManifest, error) {
+	ba, err := readBackup(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := ba.load(&AuthImpl{opts: &Options{KeysFn: KeyFor}}); err != nil {
+		return nil, err
+	}
+	return &ba.manifest, nil
+}
+
+// Restore verifies an archive created by Backup and stores its operators,
+// accounts, users and seeds in the provider, which can be of a different type
+// than the one that was backed up. Operators already in the provider are not
+// replaced, and nothing is stored if any of them is in the archive.
+func Restore(r io.Reader, provider AuthProvider, opts *RestoreOptions) (*BackupManifest, error) {
+	ba, err := readBackup(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := NewAuth(provider)
+	if err != nil {
+		return nil, err
+	}
+	if err := ba.load(auth); err != nil {
+		return nil, err
+	}
+	if err := auth.Commit(); err != nil {
+		return nil, err
+	}
+	return &ba.manifest, nil
+}
@@ -0,0 +1,462 @@
+package authb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+// BundleSeeds selects the seeds included in a bundle
+type BundleSeeds int
+
+const (
+	// BundleOperatorKey includes the seed of the operator identity
+	BundleOperatorKey BundleSeeds = 1 << iota
+	// BundleOperatorSigningKeys includes the seeds of the operator signing keys
+	BundleOperatorSigningKeys
+	// BundleAccountKeys includes the seeds of the account identities and their
+	// managed curve keys
+	BundleAccountKeys
+	// BundleAccountSigningKeys includes the seeds of the account signing keys
+	BundleAccountSigningKeys
+	// BundleUserKeys includes the seeds of the users
+	BundleUserKeys
+	// BundleAllKeys includes all the seeds
+	BundleAllKeys = BundleOperatorKey | BundleOperatorSigningKeys | BundleAccountKeys |
+		BundleAccountSigningKeys | BundleUserKeys
+)
+
+const bundleVersion = 1
+
+// BundleOptions customize the bundle created by ExportBundle
+type BundleOptions struct {
+	// Seeds selects the seeds included in the bundle, by default only JWTs are included
+	Seeds BundleSeeds
+	// Recipient is the public curve key the bundle is sealed to. If empty, the
+	// bundle is not encrypted.
+	Recipient string
+}
+
+// BundleManifest describes the files in a bundle
+type BundleManifest struct {
+	// Operator is the name of the operator
+	Operator string `json:"operator"`
+	// Subject is the public key of the operator
+	Subject string `json:"subject"`
+	// Created is the time (UTC in seconds) the bundle was created
+	Created int64 `json:"created"`
+	// Checksums are the hex encoded SHA-256 of the files by path
+	Checksums map[string]string `json:"checksums"`
+}
+
+// bundleFile is a bundle, a JSON document with the JWTs, seeds and metadata of
+// an operator. Paths are operator.jwt, accounts/<account>.jwt,
+// users/<account>/<user>.jwt, keys/<public key>.nk and metadata/<public key>.json.
+type bundleFile struct {
+	Version  int               `json:"version"`
+	Manifest BundleManifest    `json:"manifest"`
+	Files    map[string]string `json:"files"`
+}
+
+// sealedBundle is a bundle encrypted for the recipient curve key
+type sealedBundle struct {
+	Version   int    `json:"version"`
+	Sender    string `json:"sender"`
+	Recipient string `json:"recipient"`
+	Sealed    []byte `json:"sealed"`
+}
+
+// ExportBundle returns a bundle with the JWTs of the operator, its accounts and
+// users, and the selected seeds, so that the environment can be handed off and
+// imported with Operators().ImportBundle. Seeds that are not available are skipped,
+// and ephemeral users are not included.
+func ExportBundle(o Operator, opts *BundleOptions) ([]byte, error) {
+	od, ok := o.(*OperatorData)
+	if !ok || od == nil {
+		return nil, errors.New("invalid operator")
+	}
+	if opts == nil {
+		opts = &BundleOptions{}
+	}
+	if opts.Recipient != "" && !nkeys.IsValidPublicCurveKey(opts.Recipient) {
+		return nil, fmt.Errorf("invalid recipient curve key %q", opts.Recipient)
+	}
+	files, err := bundleFiles(od, func(kind BundleSeeds, k *Key) (string, string, error) {
+		if opts.Seeds&kind == 0 {
+			return "", "", nil
+		}
+		return "keys/" + k.Public + ".nk", string(k.Seed), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b := bundleFile{
+		Version: bundleVersion,
+		Manifest: BundleManifest{
+			Operator:  od.EntityName,
+			Subject:   od.Subject(),
+			Created:   time.Now().Unix(),
+			Checksums: make(map[string]string, len(files)),
+		},
+		Files: files,
+	}
+	for path, content := range files {
+		b.Manifest.Checksums[path] = checksum(content)
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if opts.Recipient == "" {
+		return data, nil
+	}
+	sender, err := nkeys.CreateCurveKeys()
+	if err != nil {
+		return nil, err
+	}
+	sb := sealedBundle{Version: bundleVersion, Recipient: opts.Recipient}
+	if sb.Sender, err = sender.PublicKey(); err != nil {
+		return nil, err
+	}
+	if sb.Sealed, err = sender.Seal(data, opts.Recipient); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(sb, "", "  ")
+}
+
+// bundleFiles returns the files of the operator using the bundle layout. The
+// seed function returns the path and content of the file for a seed, or an
+// empty path to omit it. Ephemeral users are omitted, as they are not stored.
+func bundleFiles(od *OperatorData, seed func(kind BundleSeeds, k *Key) (string, string, error)) (map[string]string, error) {
+	files := make(map[string]string)
+	addSeeds := func(kind BundleSeeds, keys ...*Key) error {
+		for _, k := range keys {
+			if k == nil || k.Seed == nil {
+				continue
+			}
+			path, content, err := seed(kind, k)
+			if err != nil {
+				return err
+			}
+			if path != "" {
+				files[path] = content
+			}
+		}
+		return nil
+	}
+	addMetadata := func(pk string, md *EntityMetadata) error {
+		if md == nil {
+			return nil
+		}
+		d, err := json.Marshal(md)
+		if err != nil {
+			return err
+		}
+		files["metadata/"+pk+".json"] = string(d)
+		return nil
+	}
+
+	files["operator.jwt"] = od.Token
+	if err := addSeeds(BundleOperatorKey, od.Key); err != nil {
+		return nil, err
+	}
+	if err := addSeeds(BundleOperatorSigningKeys, od.OperatorSigningKeys...); err != nil {
+		return nil, err
+	}
+	if err := addMetadata(od.Subject(), od.Metadata); err != nil {
+		return nil, err
+	}
+	for _, a := range od.AccountDatas {
+		files["accounts/"+a.Subject()+".jwt"] = a.Token
+		if err := addSeeds(BundleAccountKeys, a.Key); err != nil {
+			return nil, err
+		}
+		if err := addSeeds(BundleAccountKeys, a.XKeys...); err != nil {
+			return nil, err
+		}
+		if err := addSeeds(BundleAccountSigningKeys, a.AccountSigningKeys...); err != nil {
+			return nil, err
+		}
+		if err := addMetadata(a.Subject(), a.Metadata); err != nil {
+			return nil, err
+		}
+		for _, u := range a.UserDatas {
+			if u.Ephemeral {
+				continue
+			}
+			files["users/"+a.Subject()+"/"+u.Subject()+".jwt"] = u.Token
+			if err := addSeeds(BundleUserKeys, u.Key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+func checksum(content string) string {
+	h := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(h[:])
+}
+
+// openBundle decrypts the bundle if sealed, and checks the manifest
+func openBundle(data []byte, key string) (*bundleFile, error) {
+	var sb sealedBundle
+	if err := json.Unmarshal(data, &sb); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	if sb.Sealed != nil {
+		if key == "" {
+			return nil, fmt.Errorf("bundle is sealed to %s, its curve seed is required", sb.Recipient)
+		}
+		kp, err := nkeys.FromCurveSeed([]byte(key))
+		if err != nil {
+			return nil, err
+		}
+		if pk, _ := kp.PublicKey(); pk != sb.Recipient {
+			return nil, fmt.Errorf("bundle is sealed to %s", sb.Recipient)
+		}
+		if data, err = kp.Open(sb.Sealed, sb.Sender); err != nil {
+			return nil, fmt.Errorf("unable to open bundle: %w", err)
+		}
+	}
+	var b bundleFile
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	if b.Version != bundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	for path, content := range b.Files {
+		sum, ok := b.Manifest.Checksums[path]
+		if !ok {
+			return nil, fmt.Errorf("bundle file %q is not in the manifest", path)
+		}
+		if sum != checksum(content) {
+			return nil, fmt.Errorf("bundle file %q doesn't match its checksum", path)
+		}
+	}
+	for path := range b.Manifest.Checksums {
+		if _, ok := b.Files[path]; !ok {
+			return nil, fmt.Errorf("bundle file %q is missing", path)
+		}
+	}
+	return &b, nil
+}
+
+// paths returns the sorted paths of the files with the prefix and suffix
+func (b *bundleFile) paths(prefix string, suffix string) []string {
+	var buf []string
+	for path := range b.Files {
+		if strings.HasPrefix(path, prefix) && strings.HasSuffix(path, suffix) {
+			buf = append(buf, path)
+		}
+	}
+	sort.Strings(buf)
+	return buf
+}
+
+func (b *bundleFile) metadata(pk string) (*EntityMetadata, error) {
+	v, ok := b.Files["metadata/"+pk+".json"]
+	if !ok {
+		return nil, nil
+	}
+	var md EntityMetadata
+	if err := json.Unmarshal([]byte(v), &md); err != nil {
+		return nil, fmt.Errorf("invalid metadata for %s: %w", pk, err)
+	}
+	return &md, nil
+}
+
+// ImportBundle adds the operator, accounts and users in a bundle created by
+// ExportBundle. The key is the curve seed of the recipient of sealed bundles.
+// Bundles can carry a partial set of seeds, keys without seeds are kept as
+// public keys and the entities they issue can't be edited.
+func (a *OperatorsImpl) ImportBundle(bundle []byte, key string) (Operator, error) {
+	b, err := openBundle(bundle, key)
+	if err != nil {
+		return nil, err
+	}
+	return a.importBundle(b)
+}
+
+func (a *OperatorsImpl) importBundle(b *bundleFile) (*OperatorData, error) {
+	oc, err := jwt.DecodeOperatorClaims(b.Files["operator.jwt"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid operator JWT: %w", err)
+	}
+	for _, o := range a.auth.operators {
+		if o.EntityName == oc.Name || o.Subject() == oc.Subject {
+			return nil, fmt.Errorf("operator %q already exists", oc.Name)
+		}
+	}
+
+	keys, err := b.keys()
+	if err != nil {
+		return nil, err
+	}
+	od := &OperatorData{
+		BaseData:       BaseData{EntityName: oc.Name, Token: b.Files["operator.jwt"], Modified: true},
+		Claim:          oc,
+		SigningService: a.auth,
+		budget:         a.auth.budget(),
+	}
+	if od.Key, err = keys.key(oc.Subject, nkeys.PrefixByteOperator); err != nil {
+		return nil, err
+	}
+	for _, pk := range oc.SigningKeys {
+		k, err := keys.key(pk, nkeys.PrefixByteOperator)
+		if err != nil {
+			return nil, err
+		}
+		od.OperatorSigningKeys = append(od.OperatorSigningKeys, k)
+	}
+	if od.Metadata, err = b.metadata(oc.Subject); err != nil {
+		return nil, err
+	}
+
+	for _, path := range b.paths("accounts/", ".jwt") {
+		ad, err := b.importAccount(od, path, keys)
+		if err != nil {
+			return nil, err
+		}
+		od.AccountDatas = append(od.AccountDatas, ad)
+	}
+	for _, path := range b.paths("users/", ".jwt") {
+		if err := b.importUser(od, path, keys); err != nil {
+			return nil, err
+		}
+	}
+	for pk := range keys.seeds {
+		if !keys.used[pk] {
+			return nil, fmt.Errorf("seed for %s is not referenced by the bundle", pk)
+		}
+	}
+	od.AddedKeys = keys.added
+	a.auth.operators = append(a.auth.operators, od)
+	return od, nil
+}
+
+// bundleKeys tracks the seeds in a bundle that are referenced by its entities
+type bundleKeys struct {
+	seeds map[string]*Key
+	used  map[string]bool
+	added []*Key
+}
+
+func (b *bundleFile) keys() (*bundleKeys, error) {
+	keys := &bundleKeys{seeds: make(map[string]*Key), used: make(map[string]bool)}
+	for _, path := range b.paths("keys/", ".nk") {
+		k, err := KeyFrom(b.Files[path])
+		if err != nil || k.Seed == nil {
+			return nil, fmt.Errorf("invalid seed %q", path)
+		}
+		if path != "keys/"+k.Public+".nk" {
+			return nil, fmt.Errorf("seed %q doesn't match its public key", path)
+		}
+		keys.seeds[k.Public] = k
+	}
+	return keys, nil
+}
+
+// key returns the seed for the public key if in the bundle, or the public key
+func (keys *bundleKeys) key(pk string, kind nkeys.PrefixByte) (*Key, error) {
+	if k, ok := keys.seeds[pk]; ok {
+		if err := nkeys.CompatibleKeyPair(k.Pair, kind); err != nil {
+			return nil, err
+		}
+		if !keys.used[pk] {
+			keys.used[pk] = true
+			keys.added = append(keys.added, k)
+		}
+		return k, nil
+	}
+	return KeyFrom(pk, kind)
+}
+
+func (b *bundleFile) importAccount(od *OperatorData, path string, keys *bundleKeys) (*AccountData, error) {
+	ac, err := jwt.DecodeAccountClaims(b.Files[path])
+	if err != nil {
+		return nil, fmt.Errorf("invalid account JWT %q: %w", path, err)
+	}
+	if path != "accounts/"+ac.Subject+".jwt" {
+		return nil, fmt.Errorf("account JWT %q doesn't match its subject", path)
+	}
+	if ac.Issuer != od.Subject() && !od.Claim.SigningKeys.Contains(ac.Issuer) {
+		return nil, fmt.Errorf("account %q is not issued by the operator", ac.Name)
+	}
+	ad := &AccountData{
+		BaseData: BaseData{EntityName: ac.Name, Token: b.Files[path], Loaded: ac.IssuedAt, Modified: true},
+		Operator: od,
+		Claim:    ac,
+	}
+	if ad.Key, err = keys.key(ac.Subject, nkeys.PrefixByteAccount); err != nil {
+		return nil, err
+	}
+	for _, pk := range ac.SigningKeys.Keys() {
+		k, err := keys.key(pk, nkeys.PrefixByteAccount)
+		if err != nil {
+			return nil, err
+		}
+		ad.AccountSigningKeys = append(ad.AccountSigningKeys, k)
+	}
+	if ad.Metadata, err = b.metadata(ac.Subject); err != nil {
+		return nil, err
+	}
+	// curve keys are only managed when their seeds are available
+	xks := []string{ac.Authorization.XKey}
+	if ad.Metadata != nil {
+		for _, rk := range ad.Metadata.RetiredXKeys {
+			xks = append(xks, rk.Key)
+		}
+	}
+	for _, pk := range xks {
+		if _, ok := keys.seeds[pk]; !ok {
+			continue
+		}
+		k, err := keys.key(pk, nkeys.PrefixByteCurve)
+		if err != nil {
+			return nil, err
+		}
+		ad.XKeys = append(ad.XKeys, k)
+	}
+	return ad, nil
+}
+
+func (b *bundleFile) importUser(od *OperatorData, path string, keys *bundleKeys) error {
+	uc, err := jwt.DecodeUserClaims(b.Files[path])
+	if err != nil {
+		return fmt.Errorf("invalid user JWT %q: %w", path, err)
+	}
+	ad := od.accountData(uc.IssuerAccount)
+	if uc.IssuerAccount == "" {
+		ad = od.accountData(uc.Issuer)
+	}
+	if ad == nil || path != "users/"+ad.Subject()+"/"+uc.Subject+".jwt" {
+		return fmt.Errorf("user JWT %q doesn't match its account and subject", path)
+	}
+	scope, ok := ad.Claim.SigningKeys.GetScope(uc.Issuer)
+	if uc.Issuer != ad.Subject() && !ok {
+		return fmt.Errorf("user %q is not issued by account %q", uc.Name, ad.EntityName)
+	}
+	ud := &UserData{
+		BaseData:    BaseData{EntityName: uc.Name, Token: b.Files[path], Loaded: uc.IssuedAt, Modified: true},
+		AccountData: ad,
+		Claim:       uc,
+		RejectEdits: scope != nil,
+	}
+	if ud.Key, err = keys.key(uc.Subject, nkeys.PrefixByteUser); err != nil {
+		return err
+	}
+	ad.UserDatas = append(ad.UserDatas, ud)
+	ad.users = nil
+	return nil
+}
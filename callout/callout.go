@@ -0,0 +1,314 @@
+// Package callout implements the service side of the nats-server auth callout.
+//
+// The server sends an authorization request on `$SYS.REQ.USER.AUTH` to the
+// account configured with `Account.SetExternalAuthorizationUser` whenever a
+// client connects to it with a user that is not one of the authorization users.
+// The service decodes the request, asks an Authorizer how the connection should be
+// authorized, mints the user JWT, and responds with a signed authorization response.
+// If the account specifies an encryption key, requests and responses are encrypted.
+//
+// The service must connect as one of the account's authorization users.
+package callout
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+const (
+	// AuthCalloutSubject is the subject the server sends authorization requests on
+	AuthCalloutSubject = "$SYS.REQ.USER.AUTH"
+	// XKeyHeader carries the server's curve public key when the request is encrypted
+	XKeyHeader = "Nats-Server-Xkey"
+)
+
+// Authorization describes the user that the connection will be authorized as
+type Authorization struct {
+	// Account is the name or public key of the account the user is placed in.
+	// If not set, the user is placed in the callout account. Other accounts must
+	// be in the callout account's allowed accounts.
+	Account string
+	// Role selects the scoped signing key issuing the user. If not set, the
+	// user is issued by the account with the specified Permissions.
+	Role string
+	// Name of the user
+	Name string
+	// Tags of the user
+	Tags []string
+	// Expiry of the user, if zero the service's default expiry is used
+	Expiry time.Duration
+	// Permissions of the user, ignored for scoped users
+	Permissions *jwt.UserPermissionLimits
+}
+
+// Authorizer decides how a connection is authorized. Returning an error
+// rejects the connection, the error is reported by the server.
+type Authorizer interface {
+	Authorize(req *jwt.AuthorizationRequestClaims) (*Authorization, error)
+}
+
+// AuthorizerFunc adapts a function into an Authorizer
+type AuthorizerFunc func(req *jwt.AuthorizationRequestClaims) (*Authorization, error)
+
+func (fn AuthorizerFunc) Authorize(req *jwt.AuthorizationRequestClaims) (*Authorization, error) {
+	return fn(req)
+}
+
+// Options configure the callout service
+type Options struct {
+	// XKey is the curve key pair matching the account's encryption key. If not
+	// set, the account's managed xkey and its retired keys in their grace
+	// period are used.
+	XKey nkeys.KeyPair
+	// Signer is the public key of the account key or signing key used to sign
+	// responses, if empty the account key is used
+	Signer string
+	// DefaultExpiry is the expiry of users when the authorization doesn't
+	// specify one, if zero users don't expire
+	DefaultExpiry time.Duration
+	// ErrorFn is notified of requests that could not be answered
+	ErrorFn func(err error)
+}
+
+// Service answers authorization requests for an account
+type Service struct {
+	sync.Mutex
+	operator   authb.Operator
+	account    authb.Account
+	authorizer Authorizer
+	opts       Options
+	sub        *nats.Subscription
+}
+
+// New starts a callout service for the specified account of the operator
+func New(nc *nats.Conn, operator authb.Operator, account string, authorizer Authorizer, opts *Options) (*Service, error) {
+	if operator == nil {
+		return nil, errors.New("operator is required")
+	}
+	if authorizer == nil {
+		return nil, errors.New("authorizer is required")
+	}
+	if opts == nil {
+		opts = &Options{}
+	}
+	a, err := operator.Accounts().Get(account)
+	if err != nil {
+		return nil, err
+	}
+	users, _, xkey := a.ExternalAuthorization()
+	if len(users) == 0 {
+		return nil, fmt.Errorf("account %q doesn't have external authorization enabled", a.Name())
+	}
+	if xkey != "" {
+		pk := a.XKey().Public()
+		if opts.XKey != nil {
+			if pk, err = opts.XKey.PublicKey(); err != nil {
+				return nil, err
+			}
+		} else if pk == "" {
+			return nil, fmt.Errorf("account %q requires encryption but no xkey was provided", a.Name())
+		}
+		if pk != xkey {
+			return nil, fmt.Errorf("xkey %s doesn't match the encryption key for account %q", pk, a.Name())
+		}
+	}
+	s := &Service{operator: operator, account: a, authorizer: authorizer, opts: *opts}
+	s.sub, err = nc.Subscribe(AuthCalloutSubject, s.handle)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Stop stops answering requests
+func (s *Service) Stop() error {
+	return s.sub.Unsubscribe()
+}
+
+func (s *Service) notify(err error) {
+	if s.opts.ErrorFn != nil {
+		s.opts.ErrorFn(err)
+	}
+}
+
+// xkeys returns the key pairs that can decrypt requests
+func (s *Service) xkeys() []nkeys.KeyPair {
+	if s.opts.XKey != nil {
+		return []nkeys.KeyPair{s.opts.XKey}
+	}
+	s.Lock()
+	defer s.Unlock()
+	return s.account.XKey().KeyPairs()
+}
+
+// open decrypts the request returning the key pair that opened it,
+// which is used to encrypt the response
+func (s *Service) open(data []byte, serverKey string) ([]byte, nkeys.KeyPair, error) {
+	keys := s.xkeys()
+	if len(keys) == 0 {
+		return nil, nil, errors.New("received an encrypted request but no xkey is configured")
+	}
+	var err error
+	for _, kp := range keys {
+		var d []byte
+		if d, err = kp.Open(data, serverKey); err == nil {
+			return d, kp, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("error decrypting request: %w", err)
+}
+
+// handle answers a request. Requests that can't be decrypted, responses that
+// can't be signed or encrypted, and requests that don't identify the user and
+// the server can't be answered, the server times out those connections.
+func (s *Service) handle(m *nats.Msg) {
+	serverKey := m.Header.Get(XKeyHeader)
+	data := m.Data
+	var xkey nkeys.KeyPair
+	if serverKey != "" {
+		var err error
+		if data, xkey, err = s.open(data, serverKey); err != nil {
+			s.notify(err)
+			return
+		}
+	}
+	var token string
+	req, err := jwt.DecodeAuthorizationRequestClaims(string(data))
+	if err != nil {
+		s.notify(fmt.Errorf("error decoding request: %w", err))
+		if req = unverifiedRequest(string(data)); req == nil {
+			return
+		}
+		token, err = s.reject(req, errors.New("invalid authorization request"))
+	} else {
+		token, err = s.respond(req)
+	}
+	if err != nil {
+		s.notify(err)
+		return
+	}
+	out := []byte(token)
+	if serverKey != "" {
+		if out, err = xkey.Seal(out, serverKey); err != nil {
+			s.notify(fmt.Errorf("error encrypting response: %w", err))
+			return
+		}
+	}
+	if err := m.Respond(out); err != nil {
+		s.notify(err)
+	}
+}
+
+// unverifiedRequest returns the request in a token that failed to decode, if
+// it still identifies the user and the server so that it can be rejected
+func unverifiedRequest(token string) *jwt.AuthorizationRequestClaims {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	d, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil
+	}
+	var req jwt.AuthorizationRequestClaims
+	if err := json.Unmarshal(d, &req); err != nil {
+		return nil
+	}
+	if !nkeys.IsValidPublicUserKey(req.UserNkey) || req.Server.ID == "" {
+		return nil
+	}
+	return &req
+}
+
+// respond returns the signed authorization response for the request
+func (s *Service) respond(req *jwt.AuthorizationRequestClaims) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	user, err := s.authorize(req)
+	return s.issue(req, user, err)
+}
+
+// reject returns a signed authorization response denying the request
+func (s *Service) reject(req *jwt.AuthorizationRequestClaims, reason error) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.issue(req, "", reason)
+}
+
+// issue signs the response with the user JWT or the error
+func (s *Service) issue(req *jwt.AuthorizationRequestClaims, user string, err error) (string, error) {
+	rc := jwt.NewAuthorizationResponseClaims(req.UserNkey)
+	rc.Audience = req.Server.ID
+	if err != nil {
+		rc.Error = err.Error()
+	} else {
+		rc.Jwt = user
+	}
+	return s.account.IssueAuthorizationResponse(rc, s.opts.Signer)
+}
+
+// authorize returns the user JWT for the request
+func (s *Service) authorize(req *jwt.AuthorizationRequestClaims) (string, error) {
+	auth, err := s.authorizer.Authorize(req)
+	if err != nil {
+		return "", err
+	}
+	if auth == nil {
+		return "", errors.New("not authorized")
+	}
+	target, err := s.target(auth.Account)
+	if err != nil {
+		return "", err
+	}
+
+	uc := jwt.NewUserClaims(req.UserNkey)
+	uc.Name = auth.Name
+	uc.Tags.Add(auth.Tags...)
+	expiry := auth.Expiry
+	if expiry <= 0 {
+		expiry = s.opts.DefaultExpiry
+	}
+	if expiry > 0 {
+		uc.Expires = time.Now().Add(expiry).Unix()
+	}
+
+	key := ""
+	if auth.Role != "" {
+		scope, err := target.ScopedSigningKeys().SelectScope(auth.Role, authb.FirstScope)
+		if err != nil {
+			return "", err
+		}
+		key = scope.Key()
+	} else if auth.Permissions != nil {
+		uc.UserPermissionLimits = *auth.Permissions
+	}
+	return target.IssueClaim(uc, key)
+}
+
+// target returns the account the user is placed in
+func (s *Service) target(account string) (authb.Account, error) {
+	if account == "" || account == s.account.Name() || account == s.account.Subject() {
+		return s.account, nil
+	}
+	a, err := s.operator.Accounts().Get(account)
+	if err != nil {
+		return nil, fmt.Errorf("account %q not found", account)
+	}
+	_, allowed, _ := s.account.ExternalAuthorization()
+	for _, k := range allowed {
+		if k == "*" || k == a.Subject() {
+			return a, nil
+		}
+	}
+	return nil, fmt.Errorf("account %q is not allowed by the callout account", a.Name())
+}
@@ -0,0 +1,84 @@
+package callout
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MapFn maps an authenticated user name to its authorization
+type MapFn func(username string) (*Authorization, error)
+
+// FixedAuthorization returns a MapFn that authorizes all users with a
+// copy of the authorization named after the user
+func FixedAuthorization(auth Authorization) MapFn {
+	return func(username string) (*Authorization, error) {
+		v := auth
+		v.Name = username
+		return &v, nil
+	}
+}
+
+// HtpasswdAuthorizer authenticates users against an htpasswd file. Only
+// bcrypt hashes are supported. Authenticated users are mapped to an
+// account and role by the MapFn.
+type HtpasswdAuthorizer struct {
+	hashes map[string]string
+	mapFn  MapFn
+}
+
+// NewHtpasswdAuthorizer reads the htpasswd entries
+func NewHtpasswdAuthorizer(r io.Reader, mapFn MapFn) (*HtpasswdAuthorizer, error) {
+	if mapFn == nil {
+		return nil, fmt.Errorf("a map function is required")
+	}
+	ha := &HtpasswdAuthorizer{hashes: make(map[string]string), mapFn: mapFn}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		name, hash, ok := strings.Cut(s, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid htpasswd entry on line %d", line)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("htpasswd entry for %q on line %d is not a bcrypt hash", name, line)
+		}
+		ha.hashes[name] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ha, nil
+}
+
+// LoadHtpasswdAuthorizer reads the htpasswd file
+func LoadHtpasswdAuthorizer(fp string, mapFn MapFn) (*HtpasswdAuthorizer, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewHtpasswdAuthorizer(f, mapFn)
+}
+
+func (ha *HtpasswdAuthorizer) Authorize(req *jwt.AuthorizationRequestClaims) (*Authorization, error) {
+	opts := req.ConnectOptions
+	hash, ok := ha.hashes[opts.Username]
+	if !ok || opts.Password == "" {
+		return nil, ErrNotAuthorized
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(opts.Password)) != nil {
+		return nil, ErrNotAuthorized
+	}
+	return ha.mapFn(opts.Username)
+}
@@ -0,0 +1,324 @@
+package callout
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// JWKSConfig configures how bearer tokens are verified and mapped to users
+type JWKSConfig struct {
+	// Issuer if set must match the iss claim
+	Issuer string
+	// Audience if set must be one of the aud claim values
+	Audience string
+	// Account the user is placed in, used when AccountClaim is not set or
+	// the token doesn't have the claim
+	Account string
+	// AccountClaim names the claim with the account for the user
+	AccountClaim string
+	// Role of the scoped signing key issuing the user, used when RoleClaim is
+	// not set or the token doesn't have the claim
+	Role string
+	// RoleClaim names the claim with the role for the user
+	RoleClaim string
+	// NameClaim names the claim with the name of the user, defaults to sub
+	NameClaim string
+	// TagsClaim names a claim with a string or list of strings added as tags
+	TagsClaim string
+	// MaxExpiry limits the expiry of the user, which otherwise matches the token's
+	MaxExpiry time.Duration
+	// Leeway allowed when checking the token's times
+	Leeway time.Duration
+	// Now returns the current time, defaults to time.Now
+	Now func() time.Time
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type verificationKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// JWKSAuthorizer authorizes clients connecting with a bearer JWT as their token.
+// Tokens are verified against keys from a local JWKS document. RS256, ES256 and
+// EdDSA (Ed25519) signatures are supported.
+type JWKSAuthorizer struct {
+	keys []verificationKey
+	cfg  JWKSConfig
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func parseJWK(k jwk) (verificationKey, error) {
+	vk := verificationKey{kid: k.Kid}
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil {
+			return vk, err
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil {
+			return vk, err
+		}
+		ev := new(big.Int).SetBytes(e)
+		if !ev.IsInt64() || ev.Int64() < 3 || ev.Int64() > 1<<31-1 {
+			return vk, errors.New("invalid RSA exponent")
+		}
+		vk.alg = "RS256"
+		vk.key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(ev.Int64())}
+	case "EC":
+		if k.Crv != "P-256" {
+			return vk, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return vk, err
+		}
+		y, err := decodeSegment(k.Y)
+		if err != nil {
+			return vk, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return vk, errors.New("invalid P-256 coordinates")
+		}
+		// ecdh validates the point is on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return vk, err
+		}
+		vk.alg = "ES256"
+		vk.key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return vk, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return vk, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return vk, errors.New("invalid Ed25519 key")
+		}
+		vk.alg = "EdDSA"
+		vk.key = ed25519.PublicKey(x)
+	default:
+		return vk, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+	if k.Alg != "" && k.Alg != vk.alg {
+		return vk, fmt.Errorf("unsupported algorithm %q for key type %q", k.Alg, k.Kty)
+	}
+	return vk, nil
+}
+
+// NewJWKSAuthorizer reads the keys from the JWKS document
+func NewJWKSAuthorizer(r io.Reader, cfg JWKSConfig) (*JWKSAuthorizer, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(r).Decode(&set); err != nil {
+		return nil, err
+	}
+	ja := &JWKSAuthorizer{cfg: cfg}
+	for i, k := range set.Keys {
+		vk, err := parseJWK(k)
+		if err != nil {
+			return nil, fmt.Errorf("key %d (%q): %w", i, k.Kid, err)
+		}
+		ja.keys = append(ja.keys, vk)
+	}
+	if len(ja.keys) == 0 {
+		return nil, errors.New("jwks doesn't contain any keys")
+	}
+	if ja.cfg.NameClaim == "" {
+		ja.cfg.NameClaim = "sub"
+	}
+	if ja.cfg.Now == nil {
+		ja.cfg.Now = time.Now
+	}
+	return ja, nil
+}
+
+// LoadJWKSAuthorizer reads the keys from the JWKS file
+func LoadJWKSAuthorizer(fp string, cfg JWKSConfig) (*JWKSAuthorizer, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewJWKSAuthorizer(f, cfg)
+}
+
+func verifySignature(vk verificationKey, input []byte, sig []byte) bool {
+	h := sha256.Sum256(input)
+	switch k := vk.key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig) == nil
+	case *ecdsa.PublicKey:
+		if len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, h[:], r, s)
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, input, sig)
+	}
+	return false
+}
+
+// verify checks the token's signature and times, returning its claims
+func (ja *JWKSAuthorizer) verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a JWT")
+	}
+	hb, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(hb, &header); err != nil {
+		return nil, err
+	}
+	sig, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	input := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, vk := range ja.keys {
+		// the algorithm must match the key, to prevent algorithm confusion
+		if vk.alg != header.Alg || (header.Kid != "" && vk.kid != header.Kid) {
+			continue
+		}
+		if verifySignature(vk, input, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("token signature is not valid")
+	}
+
+	cb, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	claims := make(map[string]any)
+	if err := json.Unmarshal(cb, &claims); err != nil {
+		return nil, err
+	}
+	now := ja.cfg.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, errors.New("token doesn't expire")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(ja.cfg.Leeway)) {
+		return nil, errors.New("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(ja.cfg.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token is not valid yet")
+	}
+	if ja.cfg.Issuer != "" && claims["iss"] != ja.cfg.Issuer {
+		return nil, errors.New("token issuer is not trusted")
+	}
+	if ja.cfg.Audience != "" && !containsValue(claims["aud"], ja.cfg.Audience) {
+		return nil, errors.New("token is not for this audience")
+	}
+	return claims, nil
+}
+
+// stringValues returns the claim as a list of strings
+func stringValues(v any) []string {
+	switch tv := v.(type) {
+	case string:
+		return []string{tv}
+	case []any:
+		var buf []string
+		for _, e := range tv {
+			if s, ok := e.(string); ok {
+				buf = append(buf, s)
+			}
+		}
+		return buf
+	}
+	return nil
+}
+
+func containsValue(v any, s string) bool {
+	for _, e := range stringValues(v) {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (ja *JWKSAuthorizer) Authorize(req *jwt.AuthorizationRequestClaims) (*Authorization, error) {
+	token := req.ConnectOptions.Token
+	if token == "" {
+		return nil, ErrNotAuthorized
+	}
+	claims, err := ja.verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotAuthorized, err)
+	}
+	claim := func(name string, def string) string {
+		if name != "" {
+			if s, ok := claims[name].(string); ok && s != "" {
+				return s
+			}
+		}
+		return def
+	}
+	auth := &Authorization{
+		Account: claim(ja.cfg.AccountClaim, ja.cfg.Account),
+		Role:    claim(ja.cfg.RoleClaim, ja.cfg.Role),
+		Name:    claim(ja.cfg.NameClaim, ""),
+	}
+	if ja.cfg.TagsClaim != "" {
+		auth.Tags = stringValues(claims[ja.cfg.TagsClaim])
+	}
+	// the user doesn't outlive the token
+	exp := claims["exp"].(float64)
+	auth.Expiry = time.Unix(int64(exp), 0).Sub(ja.cfg.Now())
+	if ja.cfg.MaxExpiry > 0 && auth.Expiry > ja.cfg.MaxExpiry {
+		auth.Expiry = ja.cfg.MaxExpiry
+	}
+	if auth.Expiry < time.Second {
+		auth.Expiry = time.Second
+	}
+	return auth, nil
+}
@@ -0,0 +1,184 @@
+package callout
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrNotAuthorized is returned by the built-in authorizers when the
+// request doesn't match any of their users
+var ErrNotAuthorized = errors.New("not authorized")
+
+// checkSecret compares the secret against the expected value, which
+// can be a bcrypt hash or a plain value
+func checkSecret(expected, secret string) bool {
+	if expected == "" || secret == "" {
+		return false
+	}
+	if isHash(expected) {
+		return bcrypt.CompareHashAndPassword([]byte(expected), []byte(secret)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(secret)) == 1
+}
+
+// isHash returns true if the secret is a bcrypt hash
+func isHash(secret string) bool {
+	return strings.HasPrefix(secret, "$2")
+}
+
+// verifiedCommonName returns the common name of the client certificate if the
+// server verified it, or an empty string
+func verifiedCommonName(tls *jwt.ClientTLS) string {
+	if tls == nil || len(tls.VerifiedChains) == 0 || len(tls.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	b, _ := pem.Decode([]byte(tls.VerifiedChains[0][0]))
+	if b == nil {
+		return ""
+	}
+	cert, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		return ""
+	}
+	return cert.Subject.CommonName
+}
+
+// StaticUser is an entry in a static user file
+type StaticUser struct {
+	// Password is a plain password or a bcrypt hash
+	Password string `yaml:"password,omitempty"`
+	// Token is a plain token or a bcrypt hash, matched when the client
+	// connects with a token
+	Token string `yaml:"token,omitempty"`
+	// CommonName matches the common name of a verified client certificate
+	CommonName string `yaml:"common_name,omitempty"`
+	// Account the user is placed in
+	Account string `yaml:"account,omitempty"`
+	// Role of the scoped signing key issuing the user
+	Role string `yaml:"role,omitempty"`
+	// Tags of the user
+	Tags []string `yaml:"tags,omitempty"`
+	// Expiry of the user, a duration such as 1h
+	Expiry string `yaml:"expiry,omitempty"`
+}
+
+// StaticAuthorizer authorizes users listed in a YAML file of the form:
+//
+//	users:
+//	  alice:
+//	    password: secret
+//	    account: A
+//	    role: worker
+//	    tags: [team:red]
+//	    expiry: 1h
+//
+// Plain tokens and common names must be unique. Hashed tokens can't be compared,
+// so they are tried in the order of the user names and the first match wins.
+// Create it with NewStaticAuthorizer or LoadStaticAuthorizer, which index the users.
+type StaticAuthorizer struct {
+	Users map[string]StaticUser `yaml:"users"`
+	// tokens are the names of the users with a token, sorted
+	tokens []string
+	// commonNames indexes the user names by common name
+	commonNames map[string]string
+}
+
+// NewStaticAuthorizer reads the users from the YAML document
+func NewStaticAuthorizer(r io.Reader) (*StaticAuthorizer, error) {
+	var sa StaticAuthorizer
+	if err := yaml.NewDecoder(r).Decode(&sa); err != nil && err != io.EOF {
+		return nil, err
+	}
+	names := make([]string, 0, len(sa.Users))
+	for name := range sa.Users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sa.commonNames = make(map[string]string)
+	plain := make(map[string]string)
+	for _, name := range names {
+		u := sa.Users[name]
+		if u.Password == "" && u.Token == "" && u.CommonName == "" {
+			return nil, fmt.Errorf("user %q requires a password, token or common_name", name)
+		}
+		if u.Expiry != "" {
+			if _, err := time.ParseDuration(u.Expiry); err != nil {
+				return nil, fmt.Errorf("invalid expiry for user %q: %w", name, err)
+			}
+		}
+		if u.Token != "" {
+			if !isHash(u.Token) {
+				if other, ok := plain[u.Token]; ok {
+					return nil, fmt.Errorf("users %q and %q have the same token", other, name)
+				}
+				plain[u.Token] = name
+			}
+			sa.tokens = append(sa.tokens, name)
+		}
+		if u.CommonName != "" {
+			if other, ok := sa.commonNames[u.CommonName]; ok {
+				return nil, fmt.Errorf("users %q and %q have the same common_name", other, name)
+			}
+			sa.commonNames[u.CommonName] = name
+		}
+	}
+	return &sa, nil
+}
+
+// LoadStaticAuthorizer reads the users from the YAML file
+func LoadStaticAuthorizer(fp string) (*StaticAuthorizer, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewStaticAuthorizer(f)
+}
+
+func (sa *StaticAuthorizer) Authorize(req *jwt.AuthorizationRequestClaims) (*Authorization, error) {
+	opts := req.ConnectOptions
+	if opts.Username != "" {
+		if u, ok := sa.Users[opts.Username]; ok && checkSecret(u.Password, opts.Password) {
+			return u.authorization(opts.Username), nil
+		}
+		return nil, ErrNotAuthorized
+	}
+	if opts.Token != "" {
+		for _, name := range sa.tokens {
+			if u := sa.Users[name]; checkSecret(u.Token, opts.Token) {
+				return u.authorization(name), nil
+			}
+		}
+		return nil, ErrNotAuthorized
+	}
+	if cn := verifiedCommonName(req.TLS); cn != "" {
+		if name, ok := sa.commonNames[cn]; ok {
+			return sa.Users[name].authorization(name), nil
+		}
+	}
+	return nil, ErrNotAuthorized
+}
+
+func (u StaticUser) authorization(name string) *Authorization {
+	// the expiry was validated when loaded
+	d, _ := time.ParseDuration(u.Expiry)
+	return &Authorization{
+		Account: u.Account,
+		Role:    u.Role,
+		Name:    name,
+		Tags:    u.Tags,
+		Expiry:  d,
+	}
+}
@@ -0,0 +1,226 @@
+package authb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+// CloneOptions customize how an account is cloned
+type CloneOptions struct {
+	// Accounts maps the names or public keys of the exporting accounts referenced
+	// by imports to the names or public keys of the accounts that replace them,
+	// typically accounts in the target operator. Imports of accounts that are not
+	// mapped keep pointing to the same account.
+	Accounts map[string]string
+}
+
+// CloneTo copies the configuration of the account to a new account in the operator,
+// which can be the operator of the account. The new account gets its own identity
+// and signing keys, and scoped signing keys keep their roles and permissions.
+// Activations for imports are reissued by the exporting accounts in the operator.
+// Users, revocations and the external authorization reference the identities of
+// the original account and are not copied.
+func (a *AccountData) CloneTo(operator Operator, name string, opts *CloneOptions) (Account, error) {
+	if err := NotEmpty(name); err != nil {
+		return nil, err
+	}
+	target, ok := operator.(*OperatorData)
+	if !ok || target == nil {
+		return nil, errors.New("invalid operator")
+	}
+	if err := target.checkReadOnly("operator"); err != nil {
+		return nil, err
+	}
+	if _, err := target.Get(name); err == nil {
+		return nil, fmt.Errorf("account %q already exists", name)
+	}
+	if opts == nil {
+		opts = &CloneOptions{}
+	}
+	// decoding the token makes a deep copy of the claim
+	ac, err := jwt.DecodeAccountClaims(a.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*Key
+	newKey := func() (*Key, error) {
+		k, err := target.SigningService.NewKey(nkeys.PrefixByteAccount)
+		if err == nil {
+			keys = append(keys, k)
+		}
+		return k, err
+	}
+	id, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	ac.Subject = id.Public
+	ac.Name = name
+	ac.Issuer = ""
+	ac.ID = ""
+	ac.Revocations = nil
+	ac.Authorization = jwt.ExternalAuthorization{}
+
+	// sort the keys so the clone is deterministic
+	pks := ac.SigningKeys.Keys()
+	sort.Strings(pks)
+	signingKeys := jwt.SigningKeys{}
+	var accountSigningKeys []*Key
+	for _, pk := range pks {
+		k, err := newKey()
+		if err != nil {
+			return nil, err
+		}
+		accountSigningKeys = append(accountSigningKeys, k)
+		if us, ok := ac.SigningKeys[pk].(*jwt.UserScope); ok && us != nil {
+			us.Key = k.Public
+			signingKeys.AddScopedSigner(us)
+		} else {
+			signingKeys.Add(k.Public)
+		}
+	}
+	ac.SigningKeys = signingKeys
+
+	for _, imp := range ac.Imports {
+		if err := a.rewriteImport(target, imp, opts.Accounts); err != nil {
+			return nil, fmt.Errorf("import %q: %w", imp.Subject, err)
+		}
+	}
+
+	ad := &AccountData{
+		BaseData:           BaseData{Key: id, EntityName: name},
+		Operator:           target,
+		AccountSigningKeys: accountSigningKeys,
+		Claim:              ac,
+	}
+	if ref := a.Template(); ref != nil {
+		ad.metadata().Template = ref
+	}
+	for _, imp := range ac.Imports {
+		if err := ad.activateImport(imp); err != nil {
+			return nil, fmt.Errorf("import %q: %w", imp.Subject, err)
+		}
+	}
+	if err := ad.update(); err != nil {
+		return nil, err
+	}
+	target.AddedKeys = append(target.AddedKeys, keys...)
+	target.AccountDatas = append(target.AccountDatas, ad)
+	return ad, nil
+}
+
+// rewriteImport points the import to the account that replaces its exporter
+func (a *AccountData) rewriteImport(target *OperatorData, imp *jwt.Import, accounts map[string]string) error {
+	replacement, ok := accounts[imp.Account]
+	if !ok && a.Operator != nil {
+		if exporter := a.Operator.accountData(imp.Account); exporter != nil {
+			replacement, ok = accounts[exporter.EntityName]
+		}
+	}
+	if !ok {
+		return nil
+	}
+	if exporter, err := target.Get(replacement); err == nil {
+		imp.Account = exporter.Subject()
+		return nil
+	}
+	if !nkeys.IsValidPublicAccountKey(replacement) {
+		return fmt.Errorf("account %q was not found", replacement)
+	}
+	imp.Account = replacement
+	return nil
+}
+
+// activateImport replaces the activation of the import, as activations are
+// issued for the identity of the importing account
+func (a *AccountData) activateImport(imp *jwt.Import) error {
+	if imp.Token == "" {
+		return nil
+	}
+	act, err := jwt.DecodeActivationClaims(imp.Token)
+	if err == nil && act.Subject == jwt.All && act.IssuerAccount == "" && act.Issuer == imp.Account {
+		// activations for all accounts remain valid
+		return nil
+	}
+	exporter := a.Operator.accountData(imp.Account)
+	if exporter == nil {
+		return fmt.Errorf("activation cannot be reissued as account %s is not in the operator", imp.Account)
+	}
+	for _, e := range exporter.Claim.Exports {
+		if e.Type != imp.Type || !importMatches(string(imp.Subject), string(e.Subject)) {
+			continue
+		}
+		if !e.TokenReq {
+			imp.Token = ""
+			return nil
+		}
+		be := &baseExportImpl{data: exporter, export: e}
+		imp.Token, err = be.GenerateActivationForSubject(a.Subject(), exporter.Subject(), string(imp.Subject))
+		return err
+	}
+	return fmt.Errorf("account %s has no matching %s export", imp.Account, imp.Type)
+}
+
+// CloneTo copies the user to a new user in the account, which can be the account
+// of the user. The new user gets its own identity key. Users issued by a scoped
+// signing key are issued by a scope with the same role in the account, and other
+// users are issued by the account identity.
+func (u *UserData) CloneTo(account Account, name string) (User, error) {
+	if err := NotEmpty(name); err != nil {
+		return nil, err
+	}
+	target, ok := account.(*AccountData)
+	if !ok || target == nil {
+		return nil, errors.New("invalid account")
+	}
+	if _, err := target.Users().Get(name); err == nil {
+		return nil, fmt.Errorf("user %q already exists", name)
+	}
+	issuer := ""
+	if role, scoped := u.scopeRole(); scoped {
+		if role == "" {
+			return nil, errors.New("user is issued by a scope without a role")
+		}
+		scope, err := target.ScopedSigningKeys().SelectScope(role, FirstScope)
+		if err != nil {
+			return nil, err
+		}
+		issuer = scope.Key()
+	}
+	nu, err := target.Users().Add(name, issuer)
+	if err != nil {
+		return nil, err
+	}
+	ud := nu.(*UserData)
+	if !ud.RejectEdits {
+		ud.Claim.UserPermissionLimits = cloneLimits(u.Claim.UserPermissionLimits)
+	}
+	ud.Claim.Tags.Add(u.Claim.Tags...)
+	ud.Claim.Expires = u.Claim.Expires
+	if err := ud.update(); err != nil {
+		(&UsersImpl{accountData: target}).rollback(ud)
+		return nil, err
+	}
+	return ud, nil
+}
+
+// scopeRole returns the role of the scope that issued the user, and whether
+// the user is scoped
+func (u *UserData) scopeRole() (string, bool) {
+	if u.AccountData == nil {
+		return "", false
+	}
+	scope, ok := u.AccountData.Claim.SigningKeys.GetScope(u.Claim.Issuer)
+	if !ok || scope == nil {
+		return "", false
+	}
+	if us, ok := scope.(*jwt.UserScope); ok {
+		return us.Role, true
+	}
+	return "", true
+}
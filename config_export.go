@@ -0,0 +1,430 @@
+package authb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// ServerConfig is a static nats-server configuration generated from an operator
+type ServerConfig struct {
+	// Config is the configuration with the `accounts {}` block and the system account
+	Config []byte
+	// Warnings describe the features that only exist in operator mode and
+	// were not carried over
+	Warnings []string
+}
+
+// GenerateServerConfig turns the accounts of the operator into a static
+// nats-server configuration for servers that can't run in operator mode.
+// Users authenticate with their nkeys, so they connect with the seed in their
+// creds. Permissions, exports, imports, mappings, account limits and JetStream
+// limits are mapped. Revoked and expired users are left out, and features that
+// only exist in operator mode are reported in the warnings.
+func GenerateServerConfig(o Operator) (*ServerConfig, error) {
+	od, ok := o.(*OperatorData)
+	if !ok || od == nil {
+		return nil, errors.New("invalid operator")
+	}
+	g := &configGenerator{o: od, r: &ServerConfig{}, now: time.Now()}
+	accounts := make(map[string]any)
+	for _, a := range od.AccountDatas {
+		if _, ok := accounts[a.EntityName]; ok {
+			return nil, fmt.Errorf("account name %q is not unique", a.EntityName)
+		}
+		v, err := g.account(a)
+		if err != nil {
+			return nil, fmt.Errorf("account %q: %w", a.EntityName, err)
+		}
+		accounts[a.EntityName] = v
+	}
+	config := map[string]any{"accounts": accounts}
+	if sys := od.accountData(od.Claim.SystemAccount); sys != nil {
+		config["system_account"] = sys.EntityName
+	}
+	var buf strings.Builder
+	writeConfigMap(&buf, "", config)
+	g.r.Config = []byte(buf.String())
+	return g.r, nil
+}
+
+type configGenerator struct {
+	o   *OperatorData
+	r   *ServerConfig
+	now time.Time
+}
+
+func (g *configGenerator) warn(a *AccountData, format string, args ...any) {
+	g.r.Warnings = append(g.r.Warnings, fmt.Sprintf("account %q: %s", a.EntityName, fmt.Sprintf(format, args...)))
+}
+
+func (g *configGenerator) account(a *AccountData) (map[string]any, error) {
+	ac := a.Claim
+	m := make(map[string]any)
+	if ac.Expires > 0 {
+		g.warn(a, "account expiry is not supported")
+	}
+	if ac.Authorization.AuthUsers != nil {
+		g.warn(a, "external authorization is not supported")
+	}
+	for pk, scope := range ac.SigningKeys {
+		if scope != nil {
+			g.warn(a, "scoped signing keys are not supported, users issued by %s get the permissions of the scope", pk)
+		}
+	}
+
+	var users []any
+	for _, u := range a.UserDatas {
+		v, err := g.user(a, u)
+		if err != nil {
+			return nil, fmt.Errorf("user %q: %w", u.EntityName, err)
+		}
+		if v != nil {
+			users = append(users, v)
+		}
+	}
+	if len(users) > 0 {
+		m["users"] = users
+	}
+
+	var exports []any
+	for _, e := range ac.Exports {
+		if v := g.export(a, e); v != nil {
+			exports = append(exports, v)
+		}
+	}
+	if len(exports) > 0 {
+		m["exports"] = exports
+	}
+	var imports []any
+	for _, imp := range ac.Imports {
+		if v := g.imp(a, imp); v != nil {
+			imports = append(imports, v)
+		}
+	}
+	if len(imports) > 0 {
+		m["imports"] = imports
+	}
+
+	if len(ac.Mappings) > 0 {
+		mappings := make(map[string]any)
+		for subject, wm := range ac.Mappings {
+			if len(wm) == 1 && wm[0].GetWeight() == 100 && wm[0].Cluster == "" {
+				mappings[string(subject)] = string(wm[0].Subject)
+				continue
+			}
+			var dests []any
+			for _, d := range wm {
+				dest := map[string]any{"destination": string(d.Subject), "weight": int64(d.GetWeight())}
+				if d.Cluster != "" {
+					dest["cluster"] = d.Cluster
+				}
+				dests = append(dests, dest)
+			}
+			mappings[string(subject)] = dests
+		}
+		m["mappings"] = mappings
+	}
+
+	limits := make(map[string]any)
+	setLimit(limits, "max_connections", ac.Limits.Conn)
+	setLimit(limits, "max_leafnodes", ac.Limits.LeafNodeConn)
+	setLimit(limits, "max_subscriptions", ac.Limits.Subs)
+	setLimit(limits, "max_payload", ac.Limits.Payload)
+	if len(limits) > 0 {
+		m["limits"] = limits
+	}
+	if ac.Limits.Data != jwt.NoLimit {
+		g.warn(a, "the account data limit is not supported")
+	}
+	if ac.Limits.Imports != jwt.NoLimit || ac.Limits.Exports != jwt.NoLimit {
+		g.warn(a, "import and export limits are not supported")
+	}
+	if js := g.jetStream(a); js != nil {
+		m["jetstream"] = js
+	}
+	return m, nil
+}
+
+func setLimit(m map[string]any, key string, v int64) {
+	if v != jwt.NoLimit {
+		m[key] = v
+	}
+}
+
+func (g *configGenerator) user(a *AccountData, u *UserData) (map[string]any, error) {
+	uc := u.Claim
+	if uc.Expires > 0 && uc.Expires <= g.now.Unix() {
+		g.warn(a, "user %q is left out as it expired", u.EntityName)
+		return nil, nil
+	}
+	if a.Claim.Revocations.IsRevoked(uc.Subject, time.Unix(uc.IssuedAt, 0)) {
+		g.warn(a, "user %q is left out as it is revoked", u.EntityName)
+		return nil, nil
+	}
+	ep, err := u.EffectivePermissions()
+	if err != nil {
+		return nil, err
+	}
+	lim := ep.Limits
+	m := map[string]any{"nkey": uc.Subject}
+	perms := make(map[string]any)
+	if p := subjectPermissions(lim.Pub); p != nil {
+		perms["publish"] = p
+	}
+	if p := subjectPermissions(lim.Sub); p != nil {
+		perms["subscribe"] = p
+	}
+	if lim.Resp != nil {
+		resp := map[string]any{"max": int64(lim.Resp.MaxMsgs)}
+		if lim.Resp.Expires > 0 {
+			resp["expires"] = lim.Resp.Expires.String()
+		}
+		perms["allow_responses"] = resp
+	}
+	if len(perms) > 0 {
+		m["permissions"] = perms
+	}
+	if len(lim.AllowedConnectionTypes) > 0 {
+		m["allowed_connection_types"] = toAnys(lim.AllowedConnectionTypes)
+	}
+
+	var unsupported []string
+	if uc.Expires > 0 {
+		unsupported = append(unsupported, "expiry")
+	}
+	if len(lim.Times) > 0 || lim.Locale != "" {
+		unsupported = append(unsupported, "connection times")
+	}
+	if len(lim.Src) > 0 {
+		unsupported = append(unsupported, "connection sources")
+	}
+	if lim.Subs != jwt.NoLimit || lim.Data != jwt.NoLimit || lim.Payload != jwt.NoLimit {
+		unsupported = append(unsupported, "limits")
+	}
+	if lim.BearerToken {
+		unsupported = append(unsupported, "bearer token")
+	}
+	if len(unsupported) > 0 {
+		g.warn(a, "user %q: %s not supported", u.EntityName, strings.Join(unsupported, ", "))
+	}
+	return m, nil
+}
+
+func subjectPermissions(p jwt.Permission) map[string]any {
+	if len(p.Allow) == 0 && len(p.Deny) == 0 {
+		return nil
+	}
+	m := make(map[string]any)
+	if len(p.Allow) > 0 {
+		m["allow"] = toAnys(p.Allow)
+	}
+	if len(p.Deny) > 0 {
+		m["deny"] = toAnys(p.Deny)
+	}
+	return m
+}
+
+func (g *configGenerator) export(a *AccountData, e *jwt.Export) map[string]any {
+	m := make(map[string]any)
+	if e.IsService() {
+		m["service"] = string(e.Subject)
+		switch e.ResponseType {
+		case jwt.ResponseTypeStream:
+			m["response_type"] = "stream"
+		case jwt.ResponseTypeChunked:
+			m["response_type"] = "chunked"
+		}
+		if e.ResponseThreshold > 0 {
+			m["response_threshold"] = e.ResponseThreshold.String()
+		}
+		if e.Latency != nil {
+			lat := map[string]any{"subject": string(e.Latency.Results)}
+			if e.Latency.Sampling == jwt.Headers {
+				lat["sampling"] = "headers"
+			} else {
+				lat["sampling"] = int64(e.Latency.Sampling)
+			}
+			m["latency"] = lat
+		}
+	} else {
+		m["stream"] = string(e.Subject)
+	}
+	if e.AccountTokenPosition > 0 {
+		m["account_token_position"] = int64(e.AccountTokenPosition)
+	}
+	if !e.TokenReq {
+		return m
+	}
+	if len(e.Revocations) > 0 {
+		g.warn(a, "export %q: revocations are not supported, revoked activations are left out", e.Subject)
+	}
+	// the accounts that hold a valid activation are allowed to import
+	accounts := make([]any, 0)
+	for _, importer := range g.o.AccountDatas {
+		for _, imp := range importer.Claim.Imports {
+			if imp.Account != a.Subject() || imp.Type != e.Type || imp.Token == "" ||
+				!importMatches(string(imp.Subject), string(e.Subject)) {
+				continue
+			}
+			ac, err := jwt.DecodeActivationClaims(imp.Token)
+			if err != nil || (ac.Expires > 0 && ac.Expires <= g.now.Unix()) {
+				continue
+			}
+			if e.Revocations.IsRevoked(importer.Subject(), time.Unix(ac.IssuedAt, 0)) {
+				continue
+			}
+			accounts = append(accounts, importer.EntityName)
+			break
+		}
+	}
+	// the server makes an export without accounts public
+	if len(accounts) == 0 {
+		g.warn(a, "export %q is left out as no account holds a valid activation", e.Subject)
+		return nil
+	}
+	m["accounts"] = accounts
+	return m
+}
+
+func (g *configGenerator) imp(a *AccountData, imp *jwt.Import) map[string]any {
+	exporter := g.o.accountData(imp.Account)
+	if exporter == nil {
+		g.warn(a, "import %q is left out as account %s is not in the operator", imp.Subject, imp.Account)
+		return nil
+	}
+	m := make(map[string]any)
+	src := map[string]any{"account": exporter.EntityName, "subject": string(imp.Subject)}
+	if imp.IsService() {
+		m["service"] = src
+		if imp.Share {
+			m["share"] = true
+		}
+	} else {
+		m["stream"] = src
+	}
+	if imp.LocalSubject != "" {
+		m["to"] = string(imp.LocalSubject)
+	}
+	return m
+}
+
+func (g *configGenerator) jetStream(a *AccountData) any {
+	lim := a.Claim.Limits
+	if len(lim.JetStreamTieredLimits) > 0 {
+		g.warn(a, "tiered JetStream limits are not supported, JetStream is enabled without limits")
+		return "enabled"
+	}
+	js := lim.JetStreamLimits
+	if js.MemoryStorage == 0 && js.DiskStorage == 0 {
+		return nil
+	}
+	m := make(map[string]any)
+	setLimit(m, "max_mem", js.MemoryStorage)
+	setLimit(m, "max_file", js.DiskStorage)
+	setLimit(m, "max_streams", js.Streams)
+	setLimit(m, "max_consumers", js.Consumer)
+	if js.MaxAckPending > 0 {
+		m["max_ack_pending"] = js.MaxAckPending
+	}
+	if js.MemoryMaxStreamBytes > 0 {
+		m["mem_max_stream_bytes"] = js.MemoryMaxStreamBytes
+	}
+	if js.DiskMaxStreamBytes > 0 {
+		m["disk_max_stream_bytes"] = js.DiskMaxStreamBytes
+	}
+	if js.MaxBytesRequired {
+		m["max_bytes_required"] = true
+	}
+	if len(m) == 0 {
+		return "enabled"
+	}
+	return m
+}
+
+func toAnys(v []string) []any {
+	buf := make([]any, len(v))
+	for i, s := range v {
+		buf[i] = s
+	}
+	return buf
+}
+
+// writeConfigMap writes the map in the nats-server configuration format with
+// its keys sorted
+func writeConfigMap(buf *strings.Builder, indent string, m map[string]any) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.WriteString(indent)
+		buf.WriteString(quoteConfig(k))
+		buf.WriteString(": ")
+		writeConfigValue(buf, indent, m[k])
+		buf.WriteString("\n")
+	}
+}
+
+func writeConfigValue(buf *strings.Builder, indent string, v any) {
+	switch vv := v.(type) {
+	case map[string]any:
+		buf.WriteString("{\n")
+		writeConfigMap(buf, indent+"  ", vv)
+		buf.WriteString(indent + "}")
+	case []any:
+		buf.WriteString("[")
+		for i, e := range vv {
+			if _, ok := e.(map[string]any); ok {
+				buf.WriteString("\n" + indent + "  ")
+				writeConfigValue(buf, indent+"  ", e)
+				if i == len(vv)-1 {
+					buf.WriteString("\n" + indent)
+				}
+				continue
+			}
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			writeConfigValue(buf, indent, e)
+		}
+		buf.WriteString("]")
+	case string:
+		buf.WriteString(quoteConfig(vv))
+	default:
+		buf.WriteString(fmt.Sprint(vv))
+	}
+}
+
+// quoteConfig quotes the string with the escapes supported by the
+// configuration parser
+func quoteConfig(s string) string {
+	var buf strings.Builder
+	buf.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if c < 0x20 {
+				fmt.Fprintf(&buf, `\x%02x`, c)
+				continue
+			}
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
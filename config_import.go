@@ -0,0 +1,701 @@
+package authb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/conf"
+)
+
+// ConfigMigration is the result of migrating the accounts of a nats-server
+// configuration into an operator
+type ConfigMigration struct {
+	// Accounts are the accounts that were created
+	Accounts []Account
+	// Users are the users that were created
+	Users []MigratedUser
+	// Warnings describe the configuration that could not be migrated
+	Warnings []string
+}
+
+// MigratedUser is a user created from a nats-server configuration
+type MigratedUser struct {
+	// Account is the name of the account
+	Account string
+	// User is the user that was created
+	User User
+	// Creds are the credentials for the user
+	Creds []byte
+	// Nkey is the public key the user authenticated with in the configuration,
+	// it is replaced by the new identity of the user
+	Nkey string
+	// Password is set if the user authenticated with a password in the
+	// configuration, passwords are not supported in operator mode
+	Password bool
+}
+
+// MigrateServerConfig creates the accounts in the `accounts {}` block of the
+// nats-server configuration in the operator. Users, permissions, exports,
+// imports, mappings, account limits and JetStream limits are migrated, and
+// every user is issued a new identity and creds, as passwords and nkeys are
+// not used in operator mode. The replaced passwords and nkeys are reported on
+// the migrated users. Configuration that can't be expressed in operator mode
+// is reported in the warnings. If the migration fails, the operator is left
+// as it was.
+func MigrateServerConfig(o Operator, config string) (*ConfigMigration, error) {
+	m, err := conf.Parse(config)
+	if err != nil {
+		return nil, err
+	}
+	return migrateConfig(o, m)
+}
+
+// MigrateServerConfigFile is like MigrateServerConfig for the configuration
+// file at the specified path, resolving its includes
+func MigrateServerConfigFile(o Operator, path string) (*ConfigMigration, error) {
+	m, err := conf.ParseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return migrateConfig(o, m)
+}
+
+// configExport is an export restricted to the listed accounts
+type configExport struct {
+	account  string
+	export   Export
+	accounts []string
+}
+
+type configMigrator struct {
+	o        Operator
+	r        *ConfigMigration
+	accounts map[string]Account
+	private  []configExport
+}
+
+// migrateConfig migrates the configuration, forgetting the accounts and keys it
+// added if it fails, so the operator is left as it was
+func migrateConfig(o Operator, m map[string]any) (*ConfigMigration, error) {
+	if isNil(o) {
+		return nil, errors.New("operator is required")
+	}
+	od, ok := o.(*OperatorData)
+	if !ok {
+		return nil, errors.New("invalid operator")
+	}
+	// copies, as the slices can be compacted in place while migrating
+	accounts := append([]*AccountData(nil), od.AccountDatas...)
+	keys := append([]*Key(nil), od.AddedKeys...)
+	r, err := migrateAccounts(od, m)
+	if err != nil {
+		od.AccountDatas = accounts
+		od.AddedKeys = keys
+		return nil, err
+	}
+	return r, nil
+}
+
+func migrateAccounts(o Operator, m map[string]any) (*ConfigMigration, error) {
+	v, ok := m["accounts"]
+	if !ok {
+		return nil, errors.New("configuration has no accounts block")
+	}
+	accounts, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected accounts to be a map, got %T", v)
+	}
+	c := &configMigrator{o: o, r: &ConfigMigration{}, accounts: make(map[string]Account)}
+	for k := range m {
+		switch strings.ToLower(k) {
+		case "authorization":
+			c.warn("users in the authorization block are not migrated")
+		case "no_auth_user":
+			c.warn("no_auth_user is not supported in operator mode")
+		}
+	}
+
+	names := sortedKeys(accounts)
+	for _, name := range names {
+		if _, ok := accounts[name].(map[string]any); !ok {
+			return nil, fmt.Errorf("account %q: expected a map, got %T", name, accounts[name])
+		}
+		if _, err := o.Accounts().Get(name); err == nil {
+			return nil, fmt.Errorf("account %q already exists", name)
+		}
+		a, err := o.Accounts().Add(name)
+		if err != nil {
+			return nil, fmt.Errorf("account %q: %w", name, err)
+		}
+		c.accounts[name] = a
+		c.r.Accounts = append(c.r.Accounts, a)
+	}
+	for _, name := range names {
+		if err := c.migrateAccount(name, accounts[name].(map[string]any)); err != nil {
+			return nil, fmt.Errorf("account %q: %w", name, err)
+		}
+	}
+	// imports reference exports of the other accounts, so they are migrated last
+	for _, name := range names {
+		mv := accounts[name].(map[string]any)
+		if v, ok := lookup(mv, "imports"); ok {
+			if err := c.migrateImports(name, v); err != nil {
+				return nil, fmt.Errorf("account %q: %w", name, err)
+			}
+		}
+	}
+
+	if v, ok := lookup(m, "system_account", "system"); ok {
+		sys, _ := v.(string)
+		if a, ok := c.accounts[sys]; ok {
+			if err := o.SetSystemAccount(a); err != nil {
+				return nil, err
+			}
+		} else {
+			c.warn("system account %q is not defined in the accounts block", sys)
+		}
+	}
+	return c.r, nil
+}
+
+func (c *configMigrator) warn(format string, args ...any) {
+	c.r.Warnings = append(c.r.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (c *configMigrator) migrateAccount(name string, m map[string]any) error {
+	a := c.accounts[name]
+	var defaults map[string]any
+	if v, ok := lookup(m, "default_permissions"); ok {
+		if defaults, ok = v.(map[string]any); !ok {
+			return fmt.Errorf("expected default_permissions to be a map, got %T", v)
+		}
+	}
+	for _, k := range sortedKeys(m) {
+		v := m[k]
+		var err error
+		switch strings.ToLower(k) {
+		case "users":
+			err = c.migrateUsers(name, v, defaults)
+		case "exports":
+			err = c.migrateExports(name, v)
+		case "jetstream":
+			err = c.migrateJetStream(a, v)
+		case "mappings", "maps":
+			err = c.migrateMappings(name, v)
+		case "limits":
+			err = c.migrateLimits(name, v)
+		case "nkey":
+			c.warn("account %q: nkey %v is replaced by the account identity %s", name, v, a.Subject())
+		case "default_permissions", "imports":
+			// handled separately
+		default:
+			c.warn("account %q: %q is not supported", name, k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *configMigrator) migrateUsers(account string, v any, defaults map[string]any) error {
+	entries, ok := v.([]any)
+	if !ok {
+		return fmt.Errorf("expected users to be an array, got %T", v)
+	}
+	a := c.accounts[account]
+	for i, e := range entries {
+		um, ok := e.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected user to be a map, got %T", e)
+		}
+		mu := MigratedUser{Account: account}
+		name := fmt.Sprintf("user%d", i+1)
+		if v, ok := lookup(um, "nkey"); ok {
+			mu.Nkey = fmt.Sprint(v)
+			name = mu.Nkey
+		}
+		if v, ok := lookup(um, "user", "username"); ok {
+			name = fmt.Sprint(v)
+		}
+		if mu.Nkey != "" {
+			c.warn("account %q: user %q: nkey %s is replaced by a new identity", account, name, mu.Nkey)
+		}
+		if _, ok := lookup(um, "pass", "password"); ok {
+			mu.Password = true
+			c.warn("account %q: user %q: password is not supported in operator mode, the user must use the creds", account, name)
+		}
+		u, err := a.Users().Add(name, "")
+		if err != nil {
+			return fmt.Errorf("user %q: %w", name, err)
+		}
+		perms := defaults
+		for _, k := range sortedKeys(um) {
+			v := um[k]
+			switch strings.ToLower(k) {
+			case "user", "username", "nkey", "pass", "password", "account":
+			case "permissions":
+				if perms, ok = v.(map[string]any); !ok {
+					return fmt.Errorf("user %q: expected permissions to be a map, got %T", name, v)
+				}
+			case "allowed_connection_types", "connection_types", "clients":
+				if err := u.ConnectionTypes().Set(toStrings(v)...); err != nil {
+					return fmt.Errorf("user %q: %w", name, err)
+				}
+			default:
+				c.warn("account %q: user %q: %q is not supported", account, name, k)
+			}
+		}
+		if perms != nil {
+			if err := c.migratePermissions(account, name, u, perms); err != nil {
+				return fmt.Errorf("user %q: %w", name, err)
+			}
+		}
+		creds, err := u.Creds(0)
+		if err != nil {
+			return fmt.Errorf("user %q: %w", name, err)
+		}
+		mu.User, mu.Creds = u, creds
+		c.r.Users = append(c.r.Users, mu)
+	}
+	return nil
+}
+
+func (c *configMigrator) migratePermissions(account string, name string, u User, m map[string]any) error {
+	var responses any
+	for _, k := range sortedKeys(m) {
+		v := m[k]
+		switch strings.ToLower(k) {
+		case "pub", "publish", "import":
+			if err := setSubjectPermissions(u.PubPermissions, v); err != nil {
+				return err
+			}
+		case "sub", "subscribe", "export":
+			if err := setSubjectPermissions(u.SubPermissions, v); err != nil {
+				return err
+			}
+		case "allow_responses", "publish_allow_responses":
+			responses = v
+		default:
+			c.warn("account %q: user %q: permission %q is not supported", account, name, k)
+		}
+	}
+	if responses == nil {
+		return nil
+	}
+	// these are the server defaults when responses are allowed
+	max, expires := 1, 2*time.Minute
+	switch rv := responses.(type) {
+	case bool:
+		if !rv {
+			return nil
+		}
+	case map[string]any:
+		for _, k := range sortedKeys(rv) {
+			switch strings.ToLower(k) {
+			case "max", "max_msgs", "max_messages", "max_responses":
+				if n, ok := rv[k].(int64); ok && n != 0 {
+					max = int(n)
+				}
+			case "expires", "expiration", "ttl":
+				d, err := time.ParseDuration(fmt.Sprint(rv[k]))
+				if err != nil {
+					return fmt.Errorf("allow_responses: %w", err)
+				}
+				if d != 0 {
+					expires = d
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("expected allow_responses to be a boolean or a map, got %T", responses)
+	}
+	if err := u.ResponsePermissions().SetMaxMessages(max); err != nil {
+		return err
+	}
+	return u.ResponsePermissions().SetExpires(expires)
+}
+
+// setSubjectPermissions sets the permissions, which are retrieved for each
+// edit as they reference the claim that is replaced when the user is updated
+func setSubjectPermissions(perms func() Permissions, v any) error {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return perms().SetAllow(toStrings(v)...)
+	}
+	if v, ok := lookup(m, "allow"); ok {
+		if err := perms().SetAllow(toStrings(v)...); err != nil {
+			return err
+		}
+	}
+	if v, ok := lookup(m, "deny"); ok {
+		if err := perms().SetDeny(toStrings(v)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *configMigrator) migrateExports(account string, v any) error {
+	entries, ok := v.([]any)
+	if !ok {
+		return fmt.Errorf("expected exports to be an array, got %T", v)
+	}
+	a := c.accounts[account]
+	for _, e := range entries {
+		em, ok := e.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected export to be a map, got %T", e)
+		}
+		var export Export
+		var service ServiceExport
+		if v, ok := lookup(em, "stream"); ok {
+			subject := fmt.Sprint(v)
+			se, err := a.Exports().Streams().Add(subject, subject)
+			if err != nil {
+				return fmt.Errorf("stream export %q: %w", subject, err)
+			}
+			export = se
+		} else if v, ok := lookup(em, "service"); ok {
+			subject := fmt.Sprint(v)
+			se, err := a.Exports().Services().Add(subject, subject)
+			if err != nil {
+				return fmt.Errorf("service export %q: %w", subject, err)
+			}
+			export, service = se, se
+		} else {
+			return errors.New("expected export to have a stream or a service")
+		}
+		for _, k := range sortedKeys(em) {
+			v := em[k]
+			switch strings.ToLower(k) {
+			case "stream", "service":
+			case "accounts":
+				if err := export.SetTokenRequired(true); err != nil {
+					return err
+				}
+				c.private = append(c.private, configExport{account: account, export: export, accounts: toStrings(v)})
+			case "account_token_position":
+				n, _ := v.(int64)
+				if err := export.SetAccountTokenPosition(uint(n)); err != nil {
+					return err
+				}
+			case "latency":
+				if service == nil {
+					c.warn("account %q: export %q: latency is only supported by services", account, export.Subject())
+					continue
+				}
+				lat, err := parseLatency(v)
+				if err != nil {
+					return fmt.Errorf("export %q: %w", export.Subject(), err)
+				}
+				if err := service.SetLatencyOptions(lat); err != nil {
+					return err
+				}
+			default:
+				c.warn("account %q: export %q: %q is not supported", account, export.Subject(), k)
+			}
+		}
+	}
+	return nil
+}
+
+func parseLatency(v any) (*LatencyOpts, error) {
+	if s, ok := v.(string); ok {
+		return &LatencyOpts{SamplingRate: 100, Subject: s}, nil
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected latency to be a subject or a map, got %T", v)
+	}
+	lat := &LatencyOpts{SamplingRate: 100, Subject: fmt.Sprint(m["subject"])}
+	switch s := m["sampling"].(type) {
+	case nil:
+	case int64:
+		lat.SamplingRate = SamplingRate(s)
+	case string:
+		if strings.EqualFold(strings.TrimSpace(s), "headers") {
+			lat.SamplingRate = 0
+			break
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid latency sampling %q", s)
+		}
+		lat.SamplingRate = SamplingRate(n)
+	default:
+		return nil, fmt.Errorf("expected latency sampling to be a number or a string, got %T", s)
+	}
+	return lat, nil
+}
+
+func (c *configMigrator) migrateImports(account string, v any) error {
+	entries, ok := v.([]any)
+	if !ok {
+		return fmt.Errorf("expected imports to be an array, got %T", v)
+	}
+	a := c.accounts[account]
+	for _, e := range entries {
+		im, ok := e.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected import to be a map, got %T", e)
+		}
+		kind := "stream"
+		src, ok := lookup(im, kind)
+		if !ok {
+			kind = "service"
+			if src, ok = lookup(im, kind); !ok {
+				return errors.New("expected import to have a stream or a service")
+			}
+		}
+		sm, ok := src.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected %s import to be a map, got %T", kind, src)
+		}
+		an, _ := lookup(sm, "account")
+		subject := fmt.Sprint(sm["subject"])
+		exporter, ok := c.accounts[fmt.Sprint(an)]
+		if !ok {
+			c.warn("account %q: %s import %q from account %q that is not defined", account, kind, subject, an)
+			continue
+		}
+
+		var imp Import
+		var err error
+		if kind == "stream" {
+			imp, err = a.Imports().Streams().Add(subject, exporter.Subject(), subject)
+		} else {
+			imp, err = a.Imports().Services().Add(subject, exporter.Subject(), subject)
+		}
+		if err != nil {
+			return fmt.Errorf("%s import %q: %w", kind, subject, err)
+		}
+		for _, k := range sortedKeys(im) {
+			v := im[k]
+			switch strings.ToLower(k) {
+			case "stream", "service":
+			case "to":
+				err = imp.SetLocalSubject(fmt.Sprint(v))
+			case "prefix":
+				err = imp.SetLocalSubject(fmt.Sprintf("%v.%s", v, subject))
+			case "share":
+				b, _ := v.(bool)
+				err = imp.SetShareConnectionInfo(b)
+			default:
+				c.warn("account %q: import %q: %q is not supported", account, subject, k)
+			}
+			if err != nil {
+				return fmt.Errorf("%s import %q: %w", kind, subject, err)
+			}
+		}
+		if err := c.activate(account, fmt.Sprint(an), subject, imp); err != nil {
+			return fmt.Errorf("%s import %q: %w", kind, subject, err)
+		}
+	}
+	return nil
+}
+
+// activate sets an activation token on the import if the export is restricted
+// to the importing account
+func (c *configMigrator) activate(account string, exporter string, subject string, imp Import) error {
+	for _, e := range c.private {
+		if e.account != exporter || !importMatches(subject, e.export.Subject()) {
+			continue
+		}
+		for _, an := range e.accounts {
+			if an != account {
+				continue
+			}
+			a := c.accounts[account]
+			token, err := e.export.GenerateActivation(a.Subject(), c.accounts[exporter].Subject())
+			if err != nil {
+				return err
+			}
+			return imp.SetToken(token)
+		}
+		c.warn("account %q: import %q is not allowed by account %q", account, subject, exporter)
+		return nil
+	}
+	return nil
+}
+
+// importMatches returns true if the imported subject is contained in the exported subject
+func importMatches(subject string, export string) bool {
+	return subjectMatches(export, subject)
+}
+
+func (c *configMigrator) migrateJetStream(a Account, v any) error {
+	switch jv := v.(type) {
+	case bool:
+		if !jv {
+			return nil
+		}
+	case string:
+		switch strings.ToLower(jv) {
+		case "enabled", "enable", "true":
+		case "disabled", "disable", "false":
+			return nil
+		default:
+			return fmt.Errorf("invalid jetstream value %q", jv)
+		}
+	case map[string]any:
+	default:
+		return fmt.Errorf("expected jetstream to be a boolean, a string or a map, got %T", v)
+	}
+	js, err := a.Limits().JetStream().Get(0)
+	if err != nil {
+		return err
+	}
+	if err := js.SetUnlimited(); err != nil {
+		return err
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	for _, k := range sortedKeys(m) {
+		n, _ := m[k].(int64)
+		switch strings.ToLower(k) {
+		case "max_memory", "max_mem", "mem", "memory":
+			err = js.SetMaxMemoryStorage(n)
+		case "max_store", "max_file", "max_disk", "store", "disk":
+			err = js.SetMaxDiskStorage(n)
+		case "max_streams", "streams":
+			err = js.SetMaxStreams(n)
+		case "max_consumers", "consumers":
+			err = js.SetMaxConsumers(n)
+		case "max_bytes_required", "max_stream_bytes", "max_bytes":
+			b, _ := m[k].(bool)
+			err = js.SetMaxStreamSizeRequired(b)
+		case "mem_max_stream_bytes", "memory_max_stream_bytes":
+			err = js.SetMaxMemoryStreamSize(n)
+		case "disk_max_stream_bytes", "store_max_stream_bytes":
+			err = js.SetMaxDiskStreamSize(n)
+		case "max_ack_pending":
+			err = js.SetMaxAckPending(n)
+		default:
+			c.warn("account %q: jetstream %q is not supported", a.Name(), k)
+		}
+		if err != nil {
+			return fmt.Errorf("jetstream %q: %w", k, err)
+		}
+	}
+	return nil
+}
+
+func (c *configMigrator) migrateMappings(account string, v any) error {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("expected mappings to be a map, got %T", v)
+	}
+	a := c.accounts[account]
+	for _, subject := range sortedKeys(m) {
+		var mappings []Mapping
+		switch dv := m[subject].(type) {
+		case string:
+			mappings = append(mappings, Mapping{Subject: dv, Weight: 100})
+		case map[string]any:
+			mappings = append(mappings, parseMapping(dv))
+		case []any:
+			for _, d := range dv {
+				dm, ok := d.(map[string]any)
+				if !ok {
+					return fmt.Errorf("mapping %q: expected a map, got %T", subject, d)
+				}
+				mappings = append(mappings, parseMapping(dm))
+			}
+		default:
+			return fmt.Errorf("mapping %q: expected a subject, a map or an array, got %T", subject, dv)
+		}
+		if err := a.SubjectMappings().Set(subject, mappings...); err != nil {
+			return fmt.Errorf("mapping %q: %w", subject, err)
+		}
+	}
+	return nil
+}
+
+func parseMapping(m map[string]any) Mapping {
+	var mapping Mapping
+	mapping.Weight = 100
+	if v, ok := lookup(m, "dest", "destination"); ok {
+		mapping.Subject = fmt.Sprint(v)
+	}
+	switch w := m["weight"].(type) {
+	case int64:
+		mapping.Weight = uint8(w)
+	case string:
+		n, _ := strconv.Atoi(strings.TrimSuffix(w, "%"))
+		mapping.Weight = uint8(n)
+	}
+	if v, ok := m["cluster"]; ok {
+		mapping.Cluster = fmt.Sprint(v)
+	}
+	return mapping
+}
+
+func (c *configMigrator) migrateLimits(account string, v any) error {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("expected limits to be a map, got %T", v)
+	}
+	limits := c.accounts[account].Limits()
+	for _, k := range sortedKeys(m) {
+		n, _ := m[k].(int64)
+		var err error
+		switch strings.ToLower(k) {
+		case "max_connections", "max_conn":
+			err = limits.SetMaxConnections(n)
+		case "max_subscriptions", "max_subs":
+			err = limits.SetMaxSubscriptions(n)
+		case "max_payload", "max_pay":
+			err = limits.SetMaxPayload(n)
+		case "max_leafnodes", "max_leafs":
+			err = limits.SetMaxLeafNodeConnections(n)
+		default:
+			c.warn("account %q: limit %q is not supported", account, k)
+		}
+		if err != nil {
+			return fmt.Errorf("limit %q: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// lookup returns the value of the first of the keys in the map, ignoring case
+func lookup(m map[string]any, keys ...string) (any, bool) {
+	for _, key := range keys {
+		for k, v := range m {
+			if strings.EqualFold(k, key) {
+				return v, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func toStrings(v any) []string {
+	switch vv := v.(type) {
+	case string:
+		return []string{vv}
+	case []any:
+		buf := make([]string, 0, len(vv))
+		for _, s := range vv {
+			buf = append(buf, fmt.Sprint(s))
+		}
+		return buf
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
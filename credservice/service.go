@@ -0,0 +1,299 @@
+// Package credservice serves short-lived user creds over NATS request/reply.
+//
+// Apps request creds on `<prefix>.<account>.<role>`, where account is the name
+// or public key of an account managed by the operator and role names one of
+// the account's scoped signing keys. The service runs in its own account, which
+// exports the prefix as a service. Accounts allowed to request creds import the
+// service with connection info sharing enabled, so that the server adds the
+// caller's identity to each request in the Nats-Request-Info header. Requests
+// without that header are rejected.
+//
+// The service account should only contain the service user, as any user in the
+// service account could otherwise forge the header.
+package credservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	"github.com/nats-io/nkeys"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+const (
+	// DefaultPrefix is the subject prefix the service listens on
+	DefaultPrefix = "authb.creds"
+	// DefaultExpiry is the expiry of creds when the request doesn't specify one
+	DefaultExpiry = 15 * time.Minute
+	// DefaultMaxExpiry is the longest expiry a request can ask for
+	DefaultMaxExpiry = time.Hour
+	// ClientInfoHdr is the header the server uses to share the caller's identity
+	ClientInfoHdr = "Nats-Request-Info"
+)
+
+// Caller is the identity of the connection requesting creds as reported by the server
+type Caller struct {
+	// Account is the public key of the caller's account
+	Account string `json:"acc,omitempty"`
+	// User is the public key of the caller
+	User string `json:"user,omitempty"`
+	// Name is the name of the caller's user JWT
+	Name string `json:"name_tag,omitempty"`
+	// IssuerKey is the key that issued the caller's user JWT
+	IssuerKey string   `json:"issuer_key,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// Request is a request for creds
+type Request struct {
+	Caller Caller
+	// Account the creds are for
+	Account authb.Account
+	// Role of the scoped signing key issuing the creds
+	Role string
+	// Name of the user, defaults to the caller's user public key
+	Name string
+	// Tags requested for the user, only tags in Options.RoleTags for the role
+	// are issued
+	Tags []string
+	// Expiry requested for the creds
+	Expiry time.Duration
+}
+
+// Policy decides if the caller can obtain the requested creds. A non-nil
+// error rejects the request and is returned to the caller.
+type Policy func(r *Request) error
+
+// SameAccount is a policy that allows callers to obtain creds for their own
+// account. If roles are specified, only those roles are allowed.
+func SameAccount(roles ...string) Policy {
+	return func(r *Request) error {
+		if r.Caller.Account != r.Account.Subject() {
+			return errors.New("creds can only be requested for the caller's account")
+		}
+		if len(roles) == 0 {
+			return nil
+		}
+		for _, role := range roles {
+			if role == r.Role {
+				return nil
+			}
+		}
+		return fmt.Errorf("role %q is not allowed", r.Role)
+	}
+}
+
+// Options configure the service
+type Options struct {
+	// Prefix is the subject prefix, defaults to DefaultPrefix
+	Prefix string
+	// DefaultExpiry is used when the request doesn't specify an expiry
+	DefaultExpiry time.Duration
+	// MaxExpiry is the longest expiry a request can ask for
+	MaxExpiry time.Duration
+	// Policy decides which roles each caller can obtain, it is required
+	Policy Policy
+	// RoleTags are the tags callers can request for each role. Scope templates
+	// such as {{tag(name)}} expand from the user's tags, so requests with tags
+	// that are not listed for the role are rejected.
+	RoleTags map[string][]string
+}
+
+// requestBody is the optional JSON payload of a request
+type requestBody struct {
+	Name   string   `json:"name,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Expiry string   `json:"expiry,omitempty"`
+}
+
+// Service answers creds requests
+type Service struct {
+	sync.Mutex
+	operator authb.Operator
+	opts     Options
+	svc      micro.Service
+}
+
+// New starts a service minting creds for accounts in the operator. Creds are
+// issued as ephemeral users and are never stored.
+func New(nc *nats.Conn, operator authb.Operator, opts Options) (*Service, error) {
+	if operator == nil {
+		return nil, errors.New("operator is required")
+	}
+	if opts.Policy == nil {
+		return nil, errors.New("policy is required")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultPrefix
+	}
+	if opts.DefaultExpiry <= 0 {
+		opts.DefaultExpiry = DefaultExpiry
+	}
+	if opts.MaxExpiry <= 0 {
+		opts.MaxExpiry = DefaultMaxExpiry
+	}
+	if opts.DefaultExpiry > opts.MaxExpiry {
+		opts.DefaultExpiry = opts.MaxExpiry
+	}
+	s := &Service{operator: operator, opts: opts}
+	svc, err := micro.AddService(nc, micro.Config{
+		Name:        "authb-creds",
+		Version:     "1.0.0",
+		Description: "issues short-lived user creds",
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = svc.AddEndpoint("creds", micro.HandlerFunc(s.handle),
+		micro.WithEndpointSubject(fmt.Sprintf("%s.*.*", opts.Prefix)))
+	if err != nil {
+		_ = svc.Stop()
+		return nil, err
+	}
+	s.svc = svc
+	return s, nil
+}
+
+// Stop stops the service
+func (s *Service) Stop() error {
+	return s.svc.Stop()
+}
+
+func (s *Service) handle(r micro.Request) {
+	creds, code, err := s.issue(r)
+	if err != nil {
+		_ = r.Error(code, err.Error(), nil)
+		return
+	}
+	_ = r.Respond(creds)
+}
+
+// issue returns the creds for the request, or an error code and error
+func (s *Service) issue(r micro.Request) ([]byte, string, error) {
+	hdr := r.Headers().Get(ClientInfoHdr)
+	if hdr == "" {
+		return nil, "401", errors.New("request doesn't identify the caller")
+	}
+	var caller Caller
+	if err := json.Unmarshal([]byte(hdr), &caller); err != nil || caller.Account == "" {
+		return nil, "401", errors.New("request doesn't identify the caller")
+	}
+
+	tokens := strings.Split(strings.TrimPrefix(r.Subject(), s.opts.Prefix+"."), ".")
+	if len(tokens) != 2 {
+		return nil, "400", fmt.Errorf("invalid request subject %q", r.Subject())
+	}
+	var body requestBody
+	if len(r.Data()) > 0 {
+		if err := json.Unmarshal(r.Data(), &body); err != nil {
+			return nil, "400", fmt.Errorf("invalid request: %w", err)
+		}
+	}
+	req := &Request{
+		Caller: caller,
+		Role:   tokens[1],
+		Name:   body.Name,
+		Tags:   body.Tags,
+		Expiry: s.opts.DefaultExpiry,
+	}
+	if req.Name == "" {
+		req.Name = caller.User
+	}
+	if body.Expiry != "" {
+		d, err := time.ParseDuration(body.Expiry)
+		if err != nil || d <= 0 {
+			return nil, "400", fmt.Errorf("invalid expiry %q", body.Expiry)
+		}
+		req.Expiry = d
+	}
+	if req.Expiry > s.opts.MaxExpiry {
+		req.Expiry = s.opts.MaxExpiry
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	a, err := s.operator.Accounts().Get(tokens[0])
+	if err != nil {
+		return nil, "404", fmt.Errorf("account %q not found", tokens[0])
+	}
+	req.Account = a
+	if err := s.opts.Policy(req); err != nil {
+		return nil, "403", err
+	}
+	if err := s.checkTags(req); err != nil {
+		return nil, "403", err
+	}
+	scope, err := s.scope(a, req.Role)
+	if err != nil {
+		return nil, "404", err
+	}
+	creds, err := mint(a, scope, req)
+	if err != nil {
+		return nil, "500", err
+	}
+	return creds, "", nil
+}
+
+// checkTags rejects tags that are not allowed for the role
+func (s *Service) checkTags(r *Request) error {
+	allowed := s.opts.RoleTags[r.Role]
+	for _, tag := range r.Tags {
+		ok := false
+		for _, v := range allowed {
+			if v == tag {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("tag %q is not allowed for role %q", tag, r.Role)
+		}
+	}
+	return nil
+}
+
+// scope returns the first scope for the role, so the choice is stable
+func (s *Service) scope(a authb.Account, role string) (authb.ScopeLimits, error) {
+	scope, err := a.ScopedSigningKeys().SelectScope(role, authb.FirstScope)
+	if err != nil {
+		return nil, err
+	}
+	if err := scope.ValidateTemplates(); err != nil {
+		return nil, err
+	}
+	return scope, nil
+}
+
+// mint issues an ephemeral user for the request, the user is not added to the account
+func mint(a authb.Account, scope authb.ScopeLimits, req *Request) ([]byte, error) {
+	kp, err := nkeys.CreateUser()
+	if err != nil {
+		return nil, err
+	}
+	defer kp.Wipe()
+	pk, err := kp.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	seed, err := kp.Seed()
+	if err != nil {
+		return nil, err
+	}
+	uc := jwt.NewUserClaims(pk)
+	uc.Name = req.Name
+	uc.Tags.Add(req.Tags...)
+	uc.Expires = time.Now().Add(req.Expiry).Unix()
+	token, err := a.IssueClaim(uc, scope.Key())
+	if err != nil {
+		return nil, err
+	}
+	return jwt.FormatUserConfig(token, seed)
+}
@@ -0,0 +1,166 @@
+package authb
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// EffectivePermissions are the permissions and limits the server will assign
+// to a user when it connects. For scoped users these are the scope's template
+// with all permission templates expanded for the user.
+type EffectivePermissions struct {
+	// Name of the user
+	Name string
+	// Subject is the user's public key
+	Subject string
+	// Account is the public key of the account owning the user
+	Account string
+	// AccountName is the name of the account owning the user
+	AccountName string
+	// Scoped is true if the permissions come from a scoped signing key
+	Scoped bool
+	// ScopeKey is the signing key that issued the user, when scoped
+	ScopeKey string
+	// Role is the role of the scope, when scoped
+	Role string
+	// Limits are the resolved permissions and limits
+	Limits jwt.UserPermissionLimits
+	// Templates maps each subject with templates to the subjects it expanded to.
+	// Templates that produced no valid subjects map to an empty list.
+	Templates map[string][]string
+}
+
+// cloneLimits returns a deep copy of the limits
+func cloneLimits(lim jwt.UserPermissionLimits) jwt.UserPermissionLimits {
+	var v jwt.UserPermissionLimits
+	d, err := json.Marshal(lim)
+	if err != nil {
+		return lim
+	}
+	if err := json.Unmarshal(d, &v); err != nil {
+		return lim
+	}
+	return v
+}
+
+// EffectivePermissions returns the permissions and limits the server assigns to
+// the user. It fails if a template of the user's scope can't be expanded.
+func (u *UserData) EffectivePermissions() (*EffectivePermissions, error) {
+	var ac *jwt.AccountClaims
+	if u.AccountData != nil {
+		ac = u.AccountData.Claim
+	}
+	scope := u.userScope()
+	ep, err := resolvePermissions(u.Claim, ac, scope)
+	if err != nil {
+		return nil, err
+	}
+	ep.Account = u.IssuerAccount()
+	return ep, nil
+}
+
+// resolvePermissions returns the permissions the server assigns to the user
+// claim. When a scope is provided, its template is expanded for the user.
+func resolvePermissions(uc *jwt.UserClaims, ac *jwt.AccountClaims, scope *jwt.UserScope) (*EffectivePermissions, error) {
+	ep := &EffectivePermissions{
+		Name:      uc.Name,
+		Subject:   uc.Subject,
+		Account:   uc.IssuerAccount,
+		Templates: make(map[string][]string),
+	}
+	if ac != nil {
+		ep.AccountName = ac.Name
+	}
+	if scope == nil {
+		ep.Limits = cloneLimits(uc.UserPermissionLimits)
+		return ep, nil
+	}
+	ep.Scoped = true
+	ep.ScopeKey = scope.Key
+	ep.Role = scope.Role
+	lim, err := expandPermissionTemplates(cloneLimits(scope.Template), uc, ac, ep.Templates)
+	if err != nil {
+		return nil, err
+	}
+	ep.Limits = lim
+	return ep, nil
+}
+
+func formatLimit(v int64) string {
+	if v == jwt.NoLimit {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d", v)
+}
+
+func formatList(v []string) string {
+	if len(v) == 0 {
+		return "-"
+	}
+	return strings.Join(v, ", ")
+}
+
+// String renders the permissions as a human readable report
+func (p *EffectivePermissions) String() string {
+	var buf strings.Builder
+	w := func(format string, args ...any) {
+		buf.WriteString(fmt.Sprintf(format, args...))
+		buf.WriteString("\n")
+	}
+	w("User %q (%s)", p.Name, p.Subject)
+	w("Account %q (%s)", p.AccountName, p.Account)
+	if p.Scoped {
+		w("Scoped by signing key %s (role %q) - permissions in the user JWT are ignored", p.ScopeKey, p.Role)
+	} else {
+		w("Permissions from the user JWT")
+	}
+	lim := p.Limits
+	w("")
+	w("Publish allow:     %s", formatList(lim.Pub.Allow))
+	w("Publish deny:      %s", formatList(lim.Pub.Deny))
+	w("Subscribe allow:   %s", formatList(lim.Sub.Allow))
+	w("Subscribe deny:    %s", formatList(lim.Sub.Deny))
+	if lim.Resp == nil {
+		w("Responses:         -")
+	} else {
+		w("Responses:         max %d message(s), expires %s", lim.Resp.MaxMsgs, lim.Resp.Expires)
+	}
+	w("")
+	w("Max subscriptions: %s", formatLimit(lim.Subs))
+	w("Max payload:       %s", formatLimit(lim.Payload))
+	w("Max data:          %s", formatLimit(lim.Data))
+	w("Bearer token:      %t", lim.BearerToken)
+	w("Connection types:  %s", formatList(lim.AllowedConnectionTypes))
+	w("Sources:           %s", formatList(lim.Src))
+	var times []string
+	for _, t := range lim.Times {
+		times = append(times, fmt.Sprintf("%s-%s", t.Start, t.End))
+	}
+	w("Times:             %s", formatList(times))
+	locale := lim.Locale
+	if locale == "" {
+		locale = "-"
+	}
+	w("Locale:            %s", locale)
+	if len(p.Templates) > 0 {
+		w("")
+		w("Templates:")
+		var keys []string
+		for k := range p.Templates {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if len(p.Templates[k]) == 0 {
+				w("  %s -> (no valid subjects)", k)
+			} else {
+				w("  %s -> %s", k, strings.Join(p.Templates[k], ", "))
+			}
+		}
+	}
+	return buf.String()
+}
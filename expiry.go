@@ -0,0 +1,334 @@
+package authb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// ExpiringKind identifies the kind of entity that is expiring
+type ExpiringKind string
+
+const (
+	ExpiringOperator   ExpiringKind = "operator"
+	ExpiringAccount    ExpiringKind = "account"
+	ExpiringUser       ExpiringKind = "user"
+	ExpiringActivation ExpiringKind = "activation"
+)
+
+// Expiring describes an entity that expires within the scan window
+type Expiring struct {
+	Kind ExpiringKind
+	// Operator is the name of the operator the entity belongs to
+	Operator string
+	// Account is the name of the account for accounts and users, and the name of
+	// the importing account for activations
+	Account string
+	// Name is the name of the entity, or the name of the import for activations
+	Name string
+	// Subject is the public key of the entity, or the subject of the import for activations
+	Subject string
+	// Issuer is the public key that issued the entity
+	Issuer string
+	// SigningKey is true if the entity was issued by a signing key
+	SigningKey bool
+	// Expires is when the entity expires
+	Expires time.Time
+	// Reissued is true if the entity was re-issued with a new expiry
+	Reissued bool
+	// Err is set if the entity could not be re-issued
+	Err error
+}
+
+// ExpiryPolicy returns how long an expiring entity is valid for once re-issued.
+// Returning zero leaves the entity to expire.
+type ExpiryPolicy func(e Expiring) time.Duration
+
+// RenewFor returns an ExpiryPolicy re-issuing the specified kinds of entities,
+// or all entities if no kinds are specified, to be valid for the duration
+func RenewFor(d time.Duration, kinds ...ExpiringKind) ExpiryPolicy {
+	return func(e Expiring) time.Duration {
+		if len(kinds) == 0 {
+			return d
+		}
+		for _, k := range kinds {
+			if k == e.Kind {
+				return d
+			}
+		}
+		return 0
+	}
+}
+
+// ExpiryOptions configure the ExpiryManager
+type ExpiryOptions struct {
+	// Window is how far ahead of their expiry entities are reported
+	Window time.Duration
+	// Interval between scans when running, defaults to half the window
+	Interval time.Duration
+	// Policy re-issues expiring entities, if not set entities are only reported
+	Policy ExpiryPolicy
+	// Push is called after changes are committed with the entities that were
+	// re-issued, for example to update the account resolvers and distribute
+	// the operator JWTs and user credentials
+	Push func(r *Renewed) error
+	// ReportFn is notified of the expiring entities found by each scan when running
+	ReportFn func(expiring []Expiring)
+	// ErrorFn is notified of errors when running
+	ErrorFn func(err error)
+	// Now returns the current time, defaults to time.Now
+	Now func() time.Time
+}
+
+// Renewed are the entities re-issued by ExpiryManager.Renew
+type Renewed struct {
+	// Operators that were re-issued
+	Operators []Operator
+	// Accounts that were re-issued, or whose activations were re-issued
+	Accounts []Account
+	// Users that were re-issued
+	Users []User
+}
+
+func (r *Renewed) add(e *expiringEntity) {
+	switch {
+	case e.operator != nil:
+		r.Operators = append(r.Operators, e.operator)
+	case e.user != nil:
+		r.Users = append(r.Users, e.user)
+	case e.account != nil:
+		for _, a := range r.Accounts {
+			if a == Account(e.account) {
+				return
+			}
+		}
+		r.Accounts = append(r.Accounts, e.account)
+	}
+}
+
+// ExpiryManager finds entities that are about to expire, and re-issues them
+// with a fresh expiry according to its policy. The manager modifies the Auth
+// it was created with, so other changes to it must not happen concurrently
+// with a scan.
+type ExpiryManager struct {
+	sync.Mutex
+	auth Auth
+	opts ExpiryOptions
+}
+
+// NewExpiryManager creates an ExpiryManager for the Auth
+func NewExpiryManager(auth Auth, opts ExpiryOptions) (*ExpiryManager, error) {
+	if auth == nil {
+		return nil, errors.New("auth is required")
+	}
+	if opts.Window <= 0 {
+		return nil, errors.New("window must be greater than zero")
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = opts.Window / 2
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &ExpiryManager{auth: auth, opts: opts}, nil
+}
+
+// expiringEntity is an Expiring with the means to re-issue it
+type expiringEntity struct {
+	Expiring
+	operator *OperatorData
+	account  *AccountData
+	user     *UserData
+	reissue  func(expires int64) error
+}
+
+// Scan returns the entities expiring within the window
+func (m *ExpiryManager) Scan() []Expiring {
+	m.Lock()
+	defer m.Unlock()
+	var buf []Expiring
+	for _, e := range m.scan() {
+		buf = append(buf, e.Expiring)
+	}
+	return buf
+}
+
+// Renew scans for expiring entities and re-issues them according to the policy.
+// If any were re-issued, the changes are committed and pushed. It returns
+// the expiring entities, and errors re-issuing are set on each entity.
+func (m *ExpiryManager) Renew() ([]Expiring, error) {
+	m.Lock()
+	defer m.Unlock()
+	entities := m.scan()
+	buf := make([]Expiring, 0, len(entities))
+	renewed := &Renewed{}
+	reissued := false
+	for _, e := range entities {
+		if m.opts.Policy != nil {
+			if d := m.opts.Policy(e.Expiring); d > 0 {
+				e.Err = e.reissue(m.opts.Now().Add(d).Unix())
+				e.Reissued = e.Err == nil
+				reissued = reissued || e.Reissued
+				if e.Reissued {
+					renewed.add(e)
+				}
+			}
+		}
+		buf = append(buf, e.Expiring)
+	}
+	if !reissued {
+		return buf, nil
+	}
+	if err := m.auth.Commit(); err != nil {
+		return buf, err
+	}
+	if m.opts.Push != nil {
+		if err := m.opts.Push(renewed); err != nil {
+			return buf, fmt.Errorf("error pushing renewed entities: %w", err)
+		}
+	}
+	return buf, nil
+}
+
+// Run renews expiring entities on the interval until the context is done
+func (m *ExpiryManager) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+	for {
+		expiring, err := m.Renew()
+		if err != nil && m.opts.ErrorFn != nil {
+			m.opts.ErrorFn(err)
+		}
+		if m.opts.ReportFn != nil {
+			m.opts.ReportFn(expiring)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *ExpiryManager) expiring(exp int64) bool {
+	return exp > 0 && time.Unix(exp, 0).Before(m.opts.Now().Add(m.opts.Window))
+}
+
+func (m *ExpiryManager) scan() []*expiringEntity {
+	var buf []*expiringEntity
+	for _, op := range m.auth.Operators().List() {
+		o, ok := op.(*OperatorData)
+		if !ok {
+			continue
+		}
+		if m.expiring(o.Claim.Expires) {
+			buf = append(buf, &expiringEntity{
+				Expiring: Expiring{
+					Kind:     ExpiringOperator,
+					Operator: o.EntityName,
+					Name:     o.EntityName,
+					Subject:  o.Subject(),
+					Issuer:   o.Claim.Issuer,
+					Expires:  time.Unix(o.Claim.Expires, 0),
+				},
+				operator: o,
+				reissue:  o.SetExpiry,
+			})
+		}
+		for _, a := range o.AccountDatas {
+			buf = append(buf, m.scanAccount(o, a)...)
+		}
+	}
+	return buf
+}
+
+func (m *ExpiryManager) scanAccount(o *OperatorData, a *AccountData) []*expiringEntity {
+	var buf []*expiringEntity
+	if m.expiring(a.Claim.Expires) {
+		buf = append(buf, &expiringEntity{
+			Expiring: Expiring{
+				Kind:       ExpiringAccount,
+				Operator:   o.EntityName,
+				Account:    a.EntityName,
+				Name:       a.EntityName,
+				Subject:    a.Subject(),
+				Issuer:     a.Claim.Issuer,
+				SigningKey: a.Claim.Issuer != o.Subject(),
+				Expires:    time.Unix(a.Claim.Expires, 0),
+			},
+			account: a,
+			reissue: a.SetExpiry,
+		})
+	}
+	for _, u := range a.UserDatas {
+		if u.Ephemeral || !m.expiring(u.Claim.Expires) {
+			continue
+		}
+		buf = append(buf, &expiringEntity{
+			Expiring: Expiring{
+				Kind:       ExpiringUser,
+				Operator:   o.EntityName,
+				Account:    a.EntityName,
+				Name:       u.EntityName,
+				Subject:    u.Subject(),
+				Issuer:     u.Claim.Issuer,
+				SigningKey: u.Claim.Issuer != a.Subject(),
+				Expires:    time.Unix(u.Claim.Expires, 0),
+			},
+			user: u,
+			reissue: func(expires int64) error {
+				u.Claim.Expires = expires
+				return u.update()
+			},
+		})
+	}
+	for _, imp := range a.Claim.Imports {
+		if imp.Token == "" {
+			continue
+		}
+		ac, err := jwt.DecodeActivationClaims(imp.Token)
+		if err != nil || !m.expiring(ac.Expires) {
+			continue
+		}
+		exporter := ac.IssuerAccount
+		if exporter == "" {
+			exporter = ac.Issuer
+		}
+		buf = append(buf, &expiringEntity{
+			Expiring: Expiring{
+				Kind:       ExpiringActivation,
+				Operator:   o.EntityName,
+				Account:    a.EntityName,
+				Name:       imp.Name,
+				Subject:    string(imp.Subject),
+				Issuer:     ac.Issuer,
+				SigningKey: ac.IssuerAccount != "",
+				Expires:    time.Unix(ac.Expires, 0),
+			},
+			account: a,
+			reissue: func(expires int64) error {
+				ea := o.accountData(exporter)
+				if ea == nil {
+					return fmt.Errorf("exporting account %s: %w", exporter, ErrNotFound)
+				}
+				ac.Expires = expires
+				token, err := ea.signActivation(ac)
+				if err != nil {
+					return err
+				}
+				previous := imp.Token
+				imp.Token = token
+				if err := a.update(); err != nil {
+					imp.Token = previous
+					return err
+				}
+				return nil
+			},
+		})
+	}
+	return buf
+}
@@ -3,6 +3,7 @@ package authb
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/nats-io/jwt/v2"
 	"github.com/nats-io/nkeys"
@@ -188,6 +189,15 @@ func (b *baseExportImpl) getRevocationPrefix() nkeys.PrefixByte {
 	return nkeys.PrefixByteAccount
 }
 
+func (b *baseExportImpl) getToken() string {
+	return b.data.Token
+}
+
+// expiredRevocations returns nil, activations don't track the importer's expiry
+func (b *baseExportImpl) expiredRevocations(_ time.Time) []string {
+	return nil
+}
+
 func (b *baseExportImpl) Revocations() Revocations {
 	return &revocations{data: b}
 }
@@ -0,0 +1,336 @@
+package authb
+
+import (
+	"fmt"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+// IdentityRotation describes the result of rotating the identity key of an
+// operator or account, and the artifacts that need to be deployed
+type IdentityRotation struct {
+	// Previous is the identity key that was rotated
+	Previous string
+	// Current is the new identity key
+	Current string
+	// Operator is the operator JWT, set when the operator was re-issued
+	Operator string
+	// Resolver is the mem resolver configuration for the operator, set when
+	// the operator was re-issued
+	Resolver []byte
+	// Accounts are the re-issued account JWTs keyed by account public key
+	Accounts map[string]string
+	// Users are the re-issued user JWTs keyed by user public key
+	Users map[string]string
+	// Remove are the account public keys that should be removed from resolvers
+	Remove []string
+	// Steps describe how to deploy the rotation
+	Steps []string
+}
+
+func newIdentityRotation(previous string, current string) *IdentityRotation {
+	return &IdentityRotation{
+		Previous: previous,
+		Current:  current,
+		Accounts: make(map[string]string),
+		Users:    make(map[string]string),
+	}
+}
+
+func (r *IdentityRotation) step(format string, args ...any) {
+	r.Steps = append(r.Steps, fmt.Sprintf(format, args...))
+}
+
+// RotateIdentity replaces the operator's identity key. The operator is re-issued,
+// and accounts issued by the previous identity key are re-issued by the new key.
+// Accounts issued by signing keys remain valid. The previous key is deleted on Commit.
+// If the rotation fails, the operator and its accounts are left unchanged.
+func (o *OperatorData) RotateIdentity() (*IdentityRotation, error) {
+	if err := o.checkReadOnly("operator"); err != nil {
+		return nil, err
+	}
+	k, err := o.SigningService.NewKey(nkeys.PrefixByteOperator)
+	if err != nil {
+		return nil, err
+	}
+	s := o.snapshot(nil)
+	r, err := o.rotateIdentity(k)
+	if err != nil {
+		s.restore()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (o *OperatorData) rotateIdentity(k *Key) (*IdentityRotation, error) {
+	var err error
+	previous := o.Key
+	o.Claim.Subject = k.Public
+	o.Key = k
+	if err := o.update(); err != nil {
+		return nil, err
+	}
+	if o.PreviousKey == "" && o.Loaded > 0 {
+		o.PreviousKey = previous.Public
+	}
+	o.AddedKeys = append(o.AddedKeys, k)
+	o.DeletedKeys = append(o.DeletedKeys, previous.Public)
+
+	r := newIdentityRotation(previous.Public, k.Public)
+	for _, a := range o.AccountDatas {
+		if a.Claim.Issuer == previous.Public {
+			a.Claim.Issuer = k.Public
+			if err := a.update(); err != nil {
+				return nil, err
+			}
+			r.Accounts[a.Subject()] = a.Token
+		}
+		// accounts are stored under the operator
+		a.Modified = true
+	}
+	r.Operator = o.Token
+	if r.Resolver, err = o.MemResolver(); err != nil {
+		return nil, err
+	}
+
+	r.step("Commit the changes to the store")
+	r.step("Replace the operator JWT for %s with the JWT for %s in the configuration of every server", previous.Public, k.Public)
+	if len(r.Accounts) > 0 {
+		r.step("Push the %d account JWTs that were re-issued by %s to the account resolvers", len(r.Accounts), k.Public)
+	}
+	r.step("Reload the servers, accounts issued by %s are no longer trusted", previous.Public)
+	return r, nil
+}
+
+// RotateIdentity replaces the account's identity key, changing the account's ID.
+// The account and all its users are re-issued. Imports, activations, external
+// authorization and the system account in the operator that reference the
+// previous ID are updated. The previous key is deleted on Commit. If the rotation
+// fails, the operator, its accounts and the users of the account are left unchanged.
+func (a *AccountData) RotateIdentity() (*IdentityRotation, error) {
+	if err := a.checkReadOnly("account"); err != nil {
+		return nil, err
+	}
+	o := a.Operator
+	k, err := o.SigningService.NewKey(nkeys.PrefixByteAccount)
+	if err != nil {
+		return nil, err
+	}
+	s := o.snapshot(a)
+	r, err := a.rotateIdentity(k)
+	if err != nil {
+		s.restore()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (a *AccountData) rotateIdentity(k *Key) (*IdentityRotation, error) {
+	o := a.Operator
+	previous := a.Key
+	a.Claim.Subject = k.Public
+	a.Key = k
+	if err := a.update(); err != nil {
+		return nil, err
+	}
+	if a.PreviousKey == "" && a.Loaded > 0 {
+		a.PreviousKey = previous.Public
+	}
+	a.users = nil
+	o.AddedKeys = append(o.AddedKeys, k)
+	o.DeletedKeys = append(o.DeletedKeys, previous.Public)
+
+	r := newIdentityRotation(previous.Public, k.Public)
+	r.Remove = append(r.Remove, previous.Public)
+
+	for _, u := range a.UserDatas {
+		if u.Claim.Issuer == previous.Public {
+			u.Claim.Issuer = k.Public
+			u.Claim.IssuerAccount = ""
+		} else {
+			u.Claim.IssuerAccount = k.Public
+		}
+		if err := u.update(); err != nil {
+			return nil, err
+		}
+		r.Users[u.Subject()] = u.Token
+	}
+
+	// activations issued by other accounts for the previous ID
+	var external []string
+	for _, imp := range a.Claim.Imports {
+		if imp.Token == "" {
+			continue
+		}
+		exporter := o.accountData(imp.Account)
+		if exporter == nil {
+			external = append(external, imp.Account)
+			continue
+		}
+		token, err := exporter.reissueActivation(imp.Token, previous.Public, k.Public)
+		if err != nil {
+			return nil, err
+		}
+		imp.Token = token
+	}
+	if err := a.update(); err != nil {
+		return nil, err
+	}
+	r.Accounts[a.Subject()] = a.Token
+
+	for _, oa := range o.AccountDatas {
+		if oa == a {
+			continue
+		}
+		modified := false
+		for _, imp := range oa.Claim.Imports {
+			if imp.Account != previous.Public {
+				continue
+			}
+			imp.Account = k.Public
+			if imp.Token != "" {
+				token, err := a.reissueActivation(imp.Token, previous.Public, k.Public)
+				if err != nil {
+					return nil, err
+				}
+				imp.Token = token
+			}
+			modified = true
+		}
+		for _, exp := range oa.Claim.Exports {
+			if at, ok := exp.Revocations[previous.Public]; ok {
+				delete(exp.Revocations, previous.Public)
+				exp.Revocations[k.Public] = at
+				modified = true
+			}
+		}
+		allowed := oa.Claim.Authorization.AllowedAccounts
+		for i, v := range allowed {
+			if v == previous.Public {
+				allowed[i] = k.Public
+				modified = true
+			}
+		}
+		if modified {
+			if err := oa.update(); err != nil {
+				return nil, err
+			}
+			r.Accounts[oa.Subject()] = oa.Token
+		}
+	}
+
+	system := o.Claim.SystemAccount == previous.Public
+	if system {
+		o.Claim.SystemAccount = k.Public
+		if err := o.update(); err != nil {
+			return nil, err
+		}
+		r.Operator = o.Token
+	}
+
+	r.step("Commit the changes to the store")
+	if system {
+		r.step("Deploy the operator JWT and set the system account to %s in the configuration of every server", k.Public)
+	}
+	r.step("Push the %d account JWTs to the account resolvers", len(r.Accounts))
+	if len(r.Users) > 0 {
+		r.step("Distribute new credentials to the %d users of the account", len(r.Users))
+	}
+	for _, ak := range external {
+		r.step("Request a new activation from account %s for account %s", ak, k.Public)
+	}
+	r.step("Remove account %s from the account resolvers", previous.Public)
+	return r, nil
+}
+
+// identitySnapshot is the state of an operator before an identity rotation,
+// restored when the rotation fails part way
+type identitySnapshot struct {
+	o           *OperatorData
+	operator    BaseData
+	accounts    []BaseData
+	users       map[*UserData]BaseData
+	addedKeys   []*Key
+	deletedKeys []string
+}
+
+// snapshot records the operator, its accounts and the users of the specified
+// account, which can be nil
+func (o *OperatorData) snapshot(a *AccountData) *identitySnapshot {
+	s := &identitySnapshot{
+		o:           o,
+		operator:    o.BaseData,
+		users:       make(map[*UserData]BaseData),
+		addedKeys:   append([]*Key(nil), o.AddedKeys...),
+		deletedKeys: append([]string(nil), o.DeletedKeys...),
+	}
+	for _, ad := range o.AccountDatas {
+		s.accounts = append(s.accounts, ad.BaseData)
+	}
+	if a != nil {
+		for _, u := range a.UserDatas {
+			s.users[u] = u.BaseData
+		}
+	}
+	return s
+}
+
+// restore reverts the recorded entities to their tokens, the claims are
+// decoded from the tokens as they are always up-to-date
+func (s *identitySnapshot) restore() {
+	o := s.o
+	o.BaseData = s.operator
+	o.Claim, _ = jwt.DecodeOperatorClaims(o.Token)
+	o.AddedKeys = s.addedKeys
+	o.DeletedKeys = s.deletedKeys
+	for i, ad := range o.AccountDatas {
+		ad.BaseData = s.accounts[i]
+		ad.Claim, _ = jwt.DecodeAccountClaims(ad.Token)
+		ad.users = nil
+	}
+	for u, bd := range s.users {
+		u.BaseData = bd
+		u.Claim, _ = jwt.DecodeUserClaims(u.Token)
+	}
+}
+
+// accountData returns the account with the specified public key
+func (o *OperatorData) accountData(pk string) *AccountData {
+	for _, a := range o.AccountDatas {
+		if a.Subject() == pk {
+			return a
+		}
+	}
+	return nil
+}
+
+// reissueActivation re-issues an activation issued by the account, replacing
+// the previous ID of a rotated account with the current one. Activations signed
+// by the previous identity key are signed by the current identity key.
+func (a *AccountData) reissueActivation(token string, previous string, current string) (string, error) {
+	ac, err := jwt.DecodeActivationClaims(token)
+	if err != nil {
+		return "", err
+	}
+	if ac.Subject == previous {
+		ac.Subject = current
+	}
+	if ac.Issuer == previous {
+		ac.Issuer = a.Key.Public
+	}
+	return a.signActivation(ac)
+}
+
+// signActivation signs the activation with the key that issued it
+func (a *AccountData) signActivation(ac *jwt.ActivationClaims) (string, error) {
+	k, signingKey, err := a.getKey(ac.Issuer)
+	if err != nil {
+		return "", fmt.Errorf("unable to re-issue activation for %s: %w", ac.Subject, err)
+	}
+	ac.IssuerAccount = ""
+	if signingKey {
+		ac.IssuerAccount = a.Subject()
+	}
+	return a.Operator.SigningService.Sign(ac, k)
+}
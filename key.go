@@ -14,10 +14,15 @@ type Key struct {
 }
 
 func (k *Key) MarshalJSON() ([]byte, error) {
+	// keys loaded without their seed are stored as public keys
+	v := string(k.Seed)
+	if v == "" {
+		v = k.Public
+	}
 	return json.Marshal(&struct {
 		Key string `json:"key"`
 	}{
-		Key: string(k.Seed),
+		Key: v,
 	})
 }
 
@@ -28,14 +33,12 @@ func (k *Key) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &v); err != nil {
 		return err
 	}
-	var err error
-	k.Seed = []byte(v.Key)
-	k.Pair, err = nkeys.FromSeed(k.Seed)
+	key, err := KeyFrom(v.Key)
 	if err != nil {
 		return err
 	}
-	k.Public, err = k.Pair.PublicKey()
-	return err
+	*k = *key
+	return nil
 }
 
 func KeyFromNkey(kp nkeys.KeyPair, check ...nkeys.PrefixByte) (*Key, error) {
@@ -87,6 +90,25 @@ func KeyFrom(key string, check ...nkeys.PrefixByte) (*Key, error) {
 	return k, nil
 }
 
+// LoadKey returns the key from the KeyStore. If the seed is not stored, the
+// public key is returned so that the entity can be inspected and Verify
+// reports it.
+func LoadKey(ks KeyStore, pk string, check ...nkeys.PrefixByte) (*Key, error) {
+	k, err := ks.GetKey(pk)
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return KeyFrom(pk, check...)
+	}
+	if len(check) > 0 {
+		if err := nkeys.CompatibleKeyPair(k.Pair, check...); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
 func KeyFor(p nkeys.PrefixByte) (*Key, error) {
 	k := &Key{}
 	var err error
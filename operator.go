@@ -81,6 +81,9 @@ func (o *OperatorData) SetSystemAccount(account Account) error {
 }
 
 func (o *OperatorData) Add(name string) (Account, error) {
+	if err := o.checkReadOnly("operator"); err != nil {
+		return nil, err
+	}
 	sk, err := o.SigningService.NewKey(nkeys.PrefixByteAccount)
 	if err != nil {
 		return nil, err
@@ -132,8 +135,10 @@ func (o *OperatorData) List() []Account {
 }
 
 func (o *OperatorData) update() error {
-	if o.BaseData.readOnly {
-		return fmt.Errorf("account is read-only")
+	if err := o.checkReadOnly("operator"); err != nil {
+		// discard the edit
+		o.Claim, _ = jwt.DecodeOperatorClaims(o.Token)
+		return err
 	}
 
 	var err error
@@ -166,6 +171,9 @@ func (o *OperatorData) IssueClaim(claim jwt.Claims, key string) (string, error)
 	case *jwt.AuthorizationRequestClaims:
 		return "", errors.New("operators cannot issue authorization request claims")
 	}
+	if err := o.checkReadOnly("operator"); err != nil {
+		return "", err
+	}
 
 	var k *Key
 	if key == "" || key == o.Key.Public {
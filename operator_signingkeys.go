@@ -15,6 +15,9 @@ func (os *operatorSigningKeys) Add() (string, error) {
 }
 
 func (os *operatorSigningKeys) add() (*Key, error) {
+	if err := os.data.checkReadOnly("operator"); err != nil {
+		return nil, err
+	}
 	key, err := os.data.SigningService.NewKey(nkeys.PrefixByteOperator)
 	if err != nil {
 		return nil, err
@@ -32,6 +35,7 @@ func (os *operatorSigningKeys) add() (*Key, error) {
 func (os *operatorSigningKeys) Delete(key string) (bool, error) {
 	for idx, k := range os.data.Claim.SigningKeys {
 		if k == key {
+			os.data.dropRetiring(key)
 			os.data.DeletedKeys = append(os.data.DeletedKeys, key)
 			os.data.Claim.SigningKeys = append(os.data.Claim.SigningKeys[:idx], os.data.Claim.SigningKeys[idx+1:]...)
 			return true, os.data.update()
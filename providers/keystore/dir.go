@@ -0,0 +1,128 @@
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nats-io/nkeys"
+	"github.com/synadia-io/jwt-auth-builder.go"
+)
+
+// DirKeyStore is a KeyStore that stores each seed in a file named
+// <publicKey>.nk in a directory. If an encryption key (an nkey CurveKeys seed)
+// is used, the seeds are sealed with it, so the directory can be copied or
+// synced without exposing them, and require the same key to be opened.
+type DirKeyStore struct {
+	dir     string
+	encrypt nkeys.KeyPair
+}
+
+// NewDirKeyStore returns a DirKeyStore for the directory, creating it if needed.
+// If encrypt is not empty, it must be a curve seed, and the seeds are sealed with it.
+func NewDirKeyStore(dir string, encrypt string) (*DirKeyStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	ks := &DirKeyStore{dir: dir}
+	if encrypt != "" {
+		kp, err := nkeys.FromCurveSeed([]byte(encrypt))
+		if err != nil {
+			return nil, err
+		}
+		ks.encrypt = kp
+	}
+	return ks, nil
+}
+
+func (ks *DirKeyStore) path(pk string) (string, error) {
+	if !nkeys.IsValidPublicKey(pk) && !nkeys.IsValidPublicCurveKey(pk) {
+		return "", fmt.Errorf("invalid public key %q", pk)
+	}
+	return filepath.Join(ks.dir, pk+".nk"), nil
+}
+
+// GetKey returns the key with the seed for the public key. It returns (nil, nil)
+// if the seed is not stored, and an error if the stored seed can't be opened or
+// doesn't match the public key.
+func (ks *DirKeyStore) GetKey(pk string) (*authb.Key, error) {
+	fp, err := ks.path(pk)
+	if err != nil {
+		return nil, err
+	}
+	d, err := os.ReadFile(fp)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if ks.encrypt != nil {
+		epk, err := ks.encrypt.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		if d, err = ks.encrypt.Open(d, epk); err != nil {
+			return nil, fmt.Errorf("unable to open seed for %s: %w", pk, err)
+		}
+	}
+	k, err := authb.KeyFrom(string(d))
+	if err != nil {
+		return nil, err
+	}
+	if k.Public != pk || k.Seed == nil {
+		return nil, fmt.Errorf("stored seed doesn't match %s", pk)
+	}
+	return k, nil
+}
+
+// PutKey writes the seed of the key, replacing a stored seed atomically. Keys
+// without a seed are ignored.
+func (ks *DirKeyStore) PutKey(k *authb.Key) error {
+	if k == nil || k.Seed == nil {
+		return nil
+	}
+	fp, err := ks.path(k.Public)
+	if err != nil {
+		return err
+	}
+	d := k.Seed
+	if ks.encrypt != nil {
+		epk, err := ks.encrypt.PublicKey()
+		if err != nil {
+			return err
+		}
+		if d, err = ks.encrypt.Seal(d, epk); err != nil {
+			return err
+		}
+	}
+	// write to a temporary file so that a seed is never partially written
+	f, err := os.CreateTemp(ks.dir, ".seed-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(d); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fp)
+}
+
+// DeleteKey removes the seed for the public key, it is not an error if the seed
+// is not stored
+func (ks *DirKeyStore) DeleteKey(pk string) error {
+	fp, err := ks.path(pk)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(fp)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+package keystore
+
+import (
+	"sync"
+
+	"github.com/synadia-io/jwt-auth-builder.go"
+)
+
+// MemoryKeyStore is a KeyStore that keeps the seeds in memory, for tools
+// that receive the seeds they need for a session and must not persist them.
+type MemoryKeyStore struct {
+	sync.Mutex
+	keys map[string]*authb.Key
+}
+
+func NewMemoryKeyStore(keys ...*authb.Key) *MemoryKeyStore {
+	ks := &MemoryKeyStore{keys: make(map[string]*authb.Key)}
+	for _, k := range keys {
+		_ = ks.PutKey(k)
+	}
+	return ks
+}
+
+func (ks *MemoryKeyStore) GetKey(pk string) (*authb.Key, error) {
+	ks.Lock()
+	defer ks.Unlock()
+	return ks.keys[pk], nil
+}
+
+func (ks *MemoryKeyStore) PutKey(k *authb.Key) error {
+	if k == nil || k.Seed == nil {
+		return nil
+	}
+	ks.Lock()
+	defer ks.Unlock()
+	ks.keys[k.Public] = k
+	return nil
+}
+
+func (ks *MemoryKeyStore) DeleteKey(pk string) error {
+	ks.Lock()
+	defer ks.Unlock()
+	delete(ks.keys, pk)
+	return nil
+}
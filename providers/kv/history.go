@@ -0,0 +1,135 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// EntityRevision is a value stored for an operator, account or user
+type EntityRevision struct {
+	// Key is the key in the bucket
+	Key string
+	// Revision is the revision of the value in the bucket
+	Revision uint64
+	// Created is the time the value was stored
+	Created time.Time
+	// Deleted is true if the entity was deleted at this revision
+	Deleted bool
+	// Token is the JWT stored at this revision, empty if the entity was deleted
+	Token string
+}
+
+// AsOf returns a read-only view of the store as it was at the specified time,
+// which can be loaded with authb.NewAuth. Only the values kept by the bucket
+// history are visible.
+func (p *KvProvider) AsOf(at time.Time) *KvProvider {
+	return p.view(func(e jetstream.KeyValueEntry) bool {
+		return !e.Created().After(at)
+	})
+}
+
+// AtRevision returns a read-only view of the store as it was at the specified
+// bucket revision, which can be loaded with authb.NewAuth. Revisions are per
+// bucket, seeds stored in a separate keys bucket are read at their latest value.
+func (p *KvProvider) AtRevision(revision uint64) *KvProvider {
+	return p.view(func(e jetstream.KeyValueEntry) bool {
+		return e.Bucket() != p.Bucket || e.Revision() <= revision
+	})
+}
+
+// view returns a copy of the provider reading the entries selected by the filter,
+// seeds in a KeyStore other than the keys bucket are read at their latest value
+func (p *KvProvider) view(filter func(e jetstream.KeyValueEntry) bool) *KvProvider {
+	v := *p
+	v.filter = filter
+	if _, ok := p.KeyStore.(*kvKeyStore); ok {
+		v.KeyStore = &kvKeyStore{p: &v}
+	}
+	return &v
+}
+
+// EntityHistory returns the values stored for the operator, account or user with
+// the specified public key, oldest first. Pass a token to the Restore method of
+// the account or user to re-issue it with an earlier configuration.
+func (p *KvProvider) EntityHistory(pk string) ([]EntityRevision, error) {
+	entries, err := history(p.Kv, p.key("*", pk))
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var buf []EntityRevision
+	for _, e := range entries {
+		if strings.HasPrefix(e.Key(), p.key("keys", "")) || strings.HasPrefix(e.Key(), p.key("meta", "")) {
+			continue
+		}
+		if p.filter != nil && !p.filter(e) {
+			continue
+		}
+		r := EntityRevision{
+			Key:      e.Key(),
+			Revision: e.Revision(),
+			Created:  e.Created(),
+			Deleted:  e.Operation() != jetstream.KeyValuePut,
+		}
+		if !r.Deleted {
+			r.Token = string(e.Value())
+		}
+		buf = append(buf, r)
+	}
+	sort.Slice(buf, func(i, j int) bool {
+		return buf[i].Revision < buf[j].Revision
+	})
+	return buf, nil
+}
+
+// get returns the value of the key in the bucket visible to the provider
+func (p *KvProvider) get(kv jetstream.KeyValue, key string) (jetstream.KeyValueEntry, error) {
+	if p.filter == nil {
+		return kv.Get(context.Background(), key)
+	}
+	entries, err := history(kv, key)
+	if err != nil {
+		return nil, err
+	}
+	var last jetstream.KeyValueEntry
+	for _, e := range entries {
+		if p.filter(e) {
+			last = e
+		}
+	}
+	if last == nil || last.Operation() != jetstream.KeyValuePut {
+		return nil, jetstream.ErrKeyNotFound
+	}
+	return last, nil
+}
+
+// history returns the values of the keys matching the filter like KeyValue.History,
+// but deletes the consumer of the watcher in the background when the context is
+// cancelled, as Stop blocks until the request times out for clients that are not
+// allowed to delete consumers.
+func history(kv jetstream.KeyValue, filter string) ([]jetstream.KeyValueEntry, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, err := kv.Watch(ctx, filter, jetstream.IncludeHistory())
+	if err != nil {
+		return nil, err
+	}
+	var entries []jetstream.KeyValueEntry
+	for e := range w.Updates() {
+		if e == nil {
+			break
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) == 0 {
+		return nil, jetstream.ErrKeyNotFound
+	}
+	return entries, nil
+}
@@ -0,0 +1,65 @@
+package kv
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nats-io/nats.go/jetstream"
+	ab "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+// kvKeyStore is the default KeyStore of the provider, storing the seeds as
+// "keys.<publicKey>" in the keys bucket, encrypted if the provider has an
+// encryption key
+type kvKeyStore struct {
+	p *KvProvider
+}
+
+func (ks *kvKeyStore) GetKey(pk string) (*ab.Key, error) {
+	p := ks.p
+	e, err := p.get(p.Keys, p.key("keys", pk))
+	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	value := e.Value()
+	if p.EncryptKey != nil {
+		pk, err := p.EncryptKey.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		value, err = p.EncryptKey.Open(value, pk)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ab.KeyFrom(string(value))
+}
+
+func (ks *kvKeyStore) PutKey(key *ab.Key) error {
+	p := ks.p
+	if key == nil || key.Seed == nil {
+		// the seed is not available, so the stored key is left as is
+		return nil
+	}
+	v := key.Seed
+	if p.EncryptKey != nil {
+		pk, err := p.EncryptKey.PublicKey()
+		if err != nil {
+			return err
+		}
+		v, err = p.EncryptKey.Seal(v, pk)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := p.Keys.Put(context.Background(), p.key("keys", key.Public), v)
+	return err
+}
+
+func (ks *kvKeyStore) DeleteKey(pk string) error {
+	p := ks.p
+	return p.Keys.Delete(context.Background(), p.key("keys", pk))
+}
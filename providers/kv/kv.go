@@ -2,6 +2,7 @@ package kv
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -19,16 +20,33 @@ import (
 // Operators "O.<operatorPublicKey>" -> operator JWT
 // Accounts "<operatorPublicKey>.<accountPublicKey>" -> account JWT
 // Users "<accountPublicKey>.<userPublicKey>" -> user JWT
-// Keys "keys.<publicKey>" -> seeds
+// Keys "keys.<publicKey>" -> seeds, unless a KeyStore is set
+// Metadata "meta.<publicKey>" -> JSON entity metadata for operators and accounts
 // The required arguments are a natsURL, bucket name, and an optional encryption key.
 // if an optional encryption key (an nkey CurveKeys) is used, the keys will be encrypted
 // and require the same key to be decrypted.
+// When a tenant is set, all keys are prefixed by "<tenant>.", so that each tenant
+// has its own slice of the bucket, and seeds can be stored in a separate keys
+// bucket. Use Permissions to generate the NATS permissions for a tenant.
 type KvProvider struct {
 	Bucket     string
 	Nc         *nats.Conn
 	Js         jetstream.JetStream
 	Kv         jetstream.KeyValue
 	EncryptKey nkeys.KeyPair
+	// History is the number of values kept for each key when the bucket is created
+	History uint8
+	// Tenant is the prefix for all the keys stored by the provider
+	Tenant string
+	// KeysBucket is the bucket storing the seeds, the same as Bucket if empty
+	KeysBucket string
+	// Keys is the KeyValue storing the seeds, the same as Kv if there is no KeysBucket
+	Keys jetstream.KeyValue
+	// KeyStore stores the seeds, by default in the keys bucket
+	KeyStore ab.KeyStore
+
+	// filter selects the entries visible to a point-in-time view, nil for the latest values
+	filter func(e jetstream.KeyValueEntry) bool
 }
 
 const (
@@ -40,6 +58,10 @@ type KvProviderOptions struct {
 	NatsOptions []nats.Option
 	Bucket      string
 	EncryptKey  string
+	History     uint8
+	Tenant      string
+	KeysBucket  string
+	KeyStore    ab.KeyStore
 }
 
 type KvProviderOption func(*KvProviderOptions) error
@@ -77,6 +99,49 @@ func NatsContext(context string) KvProviderOption {
 	}
 }
 
+// History sets the number of values kept for each key when the bucket is created,
+// which bounds how far back History, AsOf and AtRevision can look
+func History(n uint8) KvProviderOption {
+	return func(o *KvProviderOptions) error {
+		if n > jetstream.KeyValueMaxHistory {
+			return fmt.Errorf("history is limited to %d values", jetstream.KeyValueMaxHistory)
+		}
+		o.History = n
+		return nil
+	}
+}
+
+// Tenant prefixes all the keys stored by the provider with the tenant name, so
+// that several tenants can share the bucket with permissions restricted to
+// their own keys. A bucket should be used either with or without tenants.
+func Tenant(name string) KvProviderOption {
+	return func(o *KvProviderOptions) error {
+		if name == "" || strings.ContainsAny(name, ".*> \t\r\n") {
+			return fmt.Errorf("invalid tenant name %q", name)
+		}
+		o.Tenant = name
+		return nil
+	}
+}
+
+// KeysBucket stores the seeds in a separate bucket, which can be restricted to
+// the clients that need to sign. Tenant prefixes apply to the keys bucket too.
+func KeysBucket(bucket string) KvProviderOption {
+	return func(o *KvProviderOptions) error {
+		o.KeysBucket = bucket
+		return nil
+	}
+}
+
+// KeyStore stores the seeds in the specified KeyStore rather than in the
+// bucket, the KeysBucket and EncryptKey options don't apply to it
+func KeyStore(ks ab.KeyStore) KvProviderOption {
+	return func(o *KvProviderOptions) error {
+		o.KeyStore = ks
+		return nil
+	}
+}
+
 func EncryptKey(key string) KvProviderOption {
 	return func(o *KvProviderOptions) error {
 		o.EncryptKey = key
@@ -93,6 +158,9 @@ func NewKvProvider(opts ...KvProviderOption) (*KvProvider, error) {
 		}
 	}
 	var nc *nats.Conn
+	if config.Tenant != "" && (config.NatsContext != "" || len(config.NatsOptions) > 0) {
+		config.NatsOptions = append(config.NatsOptions, nats.CustomInboxPrefix(InboxPrefix(config.Tenant)))
+	}
 	name := config.NatsContext
 	if name != "" {
 		nc, _, err = natscontext.Connect(name, config.NatsOptions...)
@@ -114,11 +182,22 @@ func NewKvProvider(opts ...KvProviderOption) (*KvProvider, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewKvProviderWithConnection(nc, config.Bucket, config.EncryptKey)
+	return newKvProvider(nc, config)
 }
 
 func NewKvProviderWithConnection(nc *nats.Conn, bucket string, encrypt string) (*KvProvider, error) {
-	p := &KvProvider{Bucket: bucket}
+	return newKvProvider(nc, &KvProviderOptions{Bucket: bucket, EncryptKey: encrypt})
+}
+
+func newKvProvider(nc *nats.Conn, config *KvProviderOptions) (*KvProvider, error) {
+	encrypt := config.EncryptKey
+	p := &KvProvider{
+		Bucket:     config.Bucket,
+		History:    config.History,
+		Tenant:     config.Tenant,
+		KeysBucket: config.KeysBucket,
+		KeyStore:   config.KeyStore,
+	}
 	p.Nc = nc
 	if encrypt != "" {
 		kp, err := nkeys.FromCurveSeed([]byte(encrypt))
@@ -146,17 +225,18 @@ func (p *KvProvider) init() error {
 		p.Disconnect()
 		return err
 	}
-	p.Kv, err = p.Js.KeyValue(context.Background(), p.Bucket)
+	p.Kv, err = p.bucket(p.Bucket)
 	if err != nil {
-		if errors.Is(err, jetstream.ErrBucketNotFound) {
-			p.Kv, err = p.Js.CreateKeyValue(context.Background(), jetstream.KeyValueConfig{
-				Bucket: p.Bucket,
-			})
-			if err != nil {
-				p.Disconnect()
-				return err
-			}
-		} else if err != nil {
+		p.Disconnect()
+		return err
+	}
+	p.Keys = p.Kv
+	if p.KeyStore == nil {
+		p.KeyStore = &kvKeyStore{p: p}
+	}
+	if p.KeysBucket != "" && p.KeysBucket != p.Bucket {
+		p.Keys, err = p.bucket(p.KeysBucket)
+		if err != nil {
 			p.Disconnect()
 			return err
 		}
@@ -164,13 +244,34 @@ func (p *KvProvider) init() error {
 	return nil
 }
 
+// bucket binds to the bucket, creating it if it doesn't exist
+func (p *KvProvider) bucket(name string) (jetstream.KeyValue, error) {
+	kv, err := p.Js.KeyValue(context.Background(), name)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		kv, err = p.Js.CreateKeyValue(context.Background(), jetstream.KeyValueConfig{
+			Bucket:  name,
+			History: p.History,
+		})
+	}
+	return kv, err
+}
+
+// key returns the key for the tokens, prefixed by the tenant
+func (p *KvProvider) key(tokens ...string) string {
+	if p.Tenant != "" {
+		tokens = append([]string{p.Tenant}, tokens...)
+	}
+	return strings.Join(tokens, ".")
+}
+
 func (p *KvProvider) Disconnect() {
 	p.Nc.Close()
 }
 
 // GetChildren returns entities are stored under <prefix>.<childPublicKey>
 func (p *KvProvider) GetChildren(prefix string) (map[string][]byte, error) {
-	entries, err := p.Kv.History(context.Background(), fmt.Sprintf("%s.*", prefix))
+	prefix = p.key(prefix)
+	entries, err := history(p.Kv, fmt.Sprintf("%s.*", prefix))
 	if err != nil {
 		if errors.Is(err, jetstream.ErrKeyNotFound) {
 			return nil, nil
@@ -180,13 +281,15 @@ func (p *KvProvider) GetChildren(prefix string) (map[string][]byte, error) {
 
 	m := make(map[string][]byte)
 	for _, e := range entries {
+		if p.filter != nil && !p.filter(e) {
+			continue
+		}
+		n := e.Key()[len(prefix)+1:]
 		if e.Operation() != jetstream.KeyValuePut {
-			delete(m, e.Key())
+			delete(m, n)
 			continue
-		} else {
-			n := e.Key()[len(prefix)+1:]
-			m[n] = e.Value()
 		}
+		m[n] = e.Value()
 	}
 	return m, nil
 }
@@ -229,17 +332,21 @@ func (p *KvProvider) LoadOperators() ([]*ab.OperatorData, error) {
 		o.Modified = false
 		o.Loaded = o.Claim.IssuedAt
 		o.EntityName = o.Claim.Name
-		o.Key, err = p.GetKey(o.Claim.Subject)
+		o.Key, err = p.loadKey(o.Claim.Subject)
 		if err != nil {
 			return nil, err
 		}
 		for _, sk := range o.Claim.SigningKeys {
-			k, err := p.GetKey(sk)
+			k, err := p.loadKey(sk)
 			if err != nil {
 				return nil, err
 			}
 			o.OperatorSigningKeys = append(o.OperatorSigningKeys, k)
 		}
+		o.Metadata, err = p.GetMetadata(o.Claim.Subject)
+		if err != nil {
+			return nil, err
+		}
 		operators = append(operators, o)
 	}
 	return operators, nil
@@ -266,22 +373,53 @@ func (p *KvProvider) LoadAccounts(od *ab.OperatorData) error {
 		a.Claim = ac
 		a.Loaded = a.Claim.IssuedAt
 		a.EntityName = a.Claim.Name
-		a.Key, err = p.GetKey(a.Claim.Subject)
+		a.Key, err = p.loadKey(a.Claim.Subject)
 		if err != nil {
 			return err
 		}
 		for pk := range a.Claim.SigningKeys {
-			k, err := p.GetKey(pk)
+			k, err := p.loadKey(pk)
 			if err != nil {
 				return err
 			}
 			a.AccountSigningKeys = append(a.AccountSigningKeys, k)
 		}
+		a.Metadata, err = p.GetMetadata(a.Claim.Subject)
+		if err != nil {
+			return err
+		}
+		if err := p.loadXKeys(a); err != nil {
+			return err
+		}
 		od.AccountDatas = append(od.AccountDatas, a)
 	}
 	return nil
 }
 
+// loadXKeys loads the managed xkeys for the account. The encryption key may not
+// be managed by the library, so missing keys are ignored.
+func (p *KvProvider) loadXKeys(a *ab.AccountData) error {
+	pks := []string{a.Claim.Authorization.XKey}
+	if a.Metadata != nil {
+		for _, rk := range a.Metadata.RetiredXKeys {
+			pks = append(pks, rk.Key)
+		}
+	}
+	for _, pk := range pks {
+		if pk == "" {
+			continue
+		}
+		k, err := p.KeyStore.GetKey(pk)
+		if err != nil {
+			return err
+		}
+		if k != nil {
+			a.XKeys = append(a.XKeys, k)
+		}
+	}
+	return nil
+}
+
 func (p *KvProvider) LoadUsers(ad *ab.AccountData) error {
 	// users stored under <accountPublicKey>.<userPublicKey>
 	m, err := p.GetChildren(ad.Claim.Subject)
@@ -303,7 +441,7 @@ func (p *KvProvider) LoadUsers(ad *ab.AccountData) error {
 		u.Modified = false
 		u.Loaded = u.Claim.IssuedAt
 		u.EntityName = u.Claim.Name
-		u.Key, err = p.GetKey(u.Claim.Subject)
+		u.Key, err = p.loadKey(u.Claim.Subject)
 		if err != nil {
 			return err
 		}
@@ -313,50 +451,70 @@ func (p *KvProvider) LoadUsers(ad *ab.AccountData) error {
 	return nil
 }
 
+// GetKey returns the key from the KeyStore, or jetstream.ErrKeyNotFound if
+// the seed is not stored
 func (p *KvProvider) GetKey(pk string) (*ab.Key, error) {
-	e, err := p.Kv.Get(context.Background(), fmt.Sprintf("keys.%s", pk))
+	k, err := p.KeyStore.GetKey(pk)
+	if err == nil && k == nil {
+		return nil, jetstream.ErrKeyNotFound
+	}
+	return k, err
+}
+
+// loadKey returns the stored key. If the seed is missing, the public key is
+// returned so that the entity can be inspected and authb.Verify reports it.
+func (p *KvProvider) loadKey(pk string) (*ab.Key, error) {
+	return ab.LoadKey(p.KeyStore, pk)
+}
+
+func (p *KvProvider) PutKey(key *ab.Key) error {
+	return p.KeyStore.PutKey(key)
+}
+
+func (p *KvProvider) DeleteKey(key string) error {
+	return p.KeyStore.DeleteKey(key)
+}
+
+// GetMetadata returns the metadata stored for the entity, or nil if none
+func (p *KvProvider) GetMetadata(pk string) (*ab.EntityMetadata, error) {
+	e, err := p.get(p.Kv, p.key("meta", pk))
 	if err != nil {
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			return nil, nil
+		}
 		return nil, err
 	}
-	if e == nil {
-		return nil, nil
-	}
-	value := e.Value()
-	if p.EncryptKey != nil {
-		pk, err := p.EncryptKey.PublicKey()
-		if err != nil {
-			return nil, err
-		}
-		value, err = p.EncryptKey.Open(value, pk)
-		if err != nil {
-			return nil, err
-		}
+	var md ab.EntityMetadata
+	if err := json.Unmarshal(e.Value(), &md); err != nil {
+		return nil, err
 	}
-	seed := string(value)
-	return ab.KeyFrom(seed)
+	return &md, nil
 }
 
-func (p *KvProvider) PutKey(key *ab.Key) error {
-	v := key.Seed
-	if p.EncryptKey != nil {
-		pk, err := p.EncryptKey.PublicKey()
-		if err != nil {
-			return err
-		}
-		v, err = p.EncryptKey.Seal(v, pk)
-		if err != nil {
-			return err
-		}
+func (p *KvProvider) PutMetadata(pk string, md *ab.EntityMetadata) error {
+	if md == nil {
+		return nil
 	}
-	_, err := p.Kv.Put(context.Background(), fmt.Sprintf("keys.%s", key.Public), v)
+	d, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+	_, err = p.Kv.Put(context.Background(), p.key("meta", pk), d)
 	return err
 }
 
-func (p *KvProvider) DeleteKey(key string) error {
-	return p.Kv.Delete(context.Background(), fmt.Sprintf("keys.%s", key))
+func (p *KvProvider) DeleteMetadata(pk string) error {
+	err := p.Kv.Delete(context.Background(), p.key("meta", pk))
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil
+	}
+	return err
 }
 
 func (p *KvProvider) Store(operators []*ab.OperatorData) error {
+	if p.filter != nil {
+		return errors.New("point-in-time views are read-only")
+	}
 	for _, o := range operators {
 		if err := p.StoreOperator(o); err != nil {
 			return err
@@ -402,15 +560,64 @@ func (p *KvProvider) Store(operators []*ab.OperatorData) error {
 				}
 			}
 		}
+		if err := p.deleteRotated(o); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
+// deleteRotated removes the entries stored under identity keys that were rotated
+func (p *KvProvider) deleteRotated(o *ab.OperatorData) error {
+	ctx := context.Background()
+	op := o.Subject()
+	if o.PreviousKey != "" {
+		op = o.PreviousKey
+		if err := p.Kv.Delete(ctx, p.key(OperatorPrefix, op)); err != nil {
+			return err
+		}
+		if err := p.DeleteMetadata(op); err != nil {
+			return err
+		}
+		for _, a := range o.DeletedAccounts {
+			if err := p.Kv.Delete(ctx, p.key(op, a.Subject())); err != nil {
+				return err
+			}
+		}
+	}
+	for _, a := range o.AccountDatas {
+		acct := a.Subject()
+		if a.PreviousKey != "" {
+			acct = a.PreviousKey
+			users, err := p.GetChildren(acct)
+			if err != nil {
+				return err
+			}
+			for u := range users {
+				if err := p.Kv.Delete(ctx, p.key(acct, u)); err != nil {
+					return err
+				}
+			}
+			if err := p.DeleteMetadata(acct); err != nil {
+				return err
+			}
+		}
+		if op != o.Subject() || acct != a.Subject() {
+			if err := p.Kv.Delete(ctx, p.key(op, acct)); err != nil {
+				return err
+			}
+		}
+		a.PreviousKey = ""
+	}
+	o.PreviousKey = ""
+	return nil
+}
+
 func (p *KvProvider) StoreOperator(o *ab.OperatorData) error {
 	if !o.Modified {
 		return nil
 	}
-	_, err := p.Kv.Put(context.Background(), fmt.Sprintf("%s.%s", OperatorPrefix, o.Subject()), []byte(o.Token))
+	_, err := p.Kv.Put(context.Background(), p.key(OperatorPrefix, o.Subject()), []byte(o.Token))
 	if err != nil {
 		return err
 	}
@@ -422,6 +629,9 @@ func (p *KvProvider) StoreOperator(o *ab.OperatorData) error {
 			return err
 		}
 	}
+	if err := p.PutMetadata(o.Subject(), o.Metadata); err != nil {
+		return err
+	}
 	o.Loaded = o.Claim.IssuedAt
 	o.Modified = false
 	return nil
@@ -431,9 +641,7 @@ func (p *KvProvider) StoreAccount(a *ab.AccountData) error {
 	if !a.Modified {
 		return nil
 	}
-	_, err := p.Kv.Put(context.Background(),
-		fmt.Sprintf("%s.%s", a.Operator.Subject(), a.Subject()),
-		[]byte(a.Token))
+	_, err := p.Kv.Put(context.Background(), p.key(a.Operator.Subject(), a.Subject()), []byte(a.Token))
 	if err != nil {
 		return err
 	}
@@ -445,6 +653,9 @@ func (p *KvProvider) StoreAccount(a *ab.AccountData) error {
 			return err
 		}
 	}
+	if err := p.PutMetadata(a.Subject(), a.Metadata); err != nil {
+		return err
+	}
 	a.Loaded = a.Claim.IssuedAt
 	a.Modified = false
 	return nil
@@ -454,9 +665,7 @@ func (p *KvProvider) StoreUser(u *ab.UserData) error {
 	if !u.Modified {
 		return nil
 	}
-	_, err := p.Kv.Put(context.Background(),
-		fmt.Sprintf("%s.%s", u.AccountData.Subject(), u.Subject()),
-		[]byte(u.Token))
+	_, err := p.Kv.Put(context.Background(), p.key(u.AccountData.Subject(), u.Subject()), []byte(u.Token))
 	if err != nil {
 		return err
 	}
@@ -466,13 +675,25 @@ func (p *KvProvider) StoreUser(u *ab.UserData) error {
 }
 
 func (p *KvProvider) DeleteAccount(a *ab.AccountData) error {
-	return p.Kv.Delete(context.Background(), fmt.Sprintf("%s.%s", a.Operator.Subject(), a.Subject()))
+	if err := p.Kv.Delete(context.Background(), p.key(a.Operator.Subject(), a.Subject())); err != nil {
+		return err
+	}
+	if a.Metadata != nil {
+		return p.DeleteMetadata(a.Subject())
+	}
+	return nil
 }
 
 func (p *KvProvider) DeleteUser(u *ab.UserData) error {
-	return p.Kv.Delete(context.Background(), fmt.Sprintf("%s.%s", u.AccountData.Subject(), u.Subject()))
+	return p.Kv.Delete(context.Background(), p.key(u.AccountData.Subject(), u.Subject()))
 }
 
+// Destroy deletes the bucket and the keys bucket, including the data of all tenants
 func (p *KvProvider) Destroy() error {
+	if p.Keys != p.Kv {
+		if err := p.Js.DeleteKeyValue(context.Background(), p.KeysBucket); err != nil {
+			return err
+		}
+	}
 	return p.Js.DeleteKeyValue(context.Background(), p.Bucket)
 }
@@ -0,0 +1,63 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+
+	jwt "github.com/nats-io/jwt/v2"
+)
+
+// InboxPrefix returns the inbox prefix used by the connections of a tenant, so
+// that replies for a tenant are not visible to other tenants. NewKvProvider sets
+// it on the connections it creates, connections passed to the provider should
+// use nats.CustomInboxPrefix.
+func InboxPrefix(tenant string) string {
+	if tenant == "" {
+		return "_INBOX"
+	}
+	return fmt.Sprintf("_INBOX_%s", tenant)
+}
+
+// Permissions returns the NATS permissions for a client of a provider with the
+// specified bucket, keys bucket and tenant options. The client can only read and
+// write the keys of its tenant, and can't create or delete the buckets, which
+// should be created by an administrator. Tenants require a separate keys bucket.
+// Clients can't delete consumers, as their names are not scoped to the tenant,
+// the ephemeral consumers they create are removed by the server when inactive.
+func Permissions(opts ...KvProviderOption) (jwt.Permissions, error) {
+	var perms jwt.Permissions
+	config := &KvProviderOptions{}
+	for _, o := range opts {
+		if err := o(config); err != nil {
+			return perms, err
+		}
+	}
+	if config.Bucket == "" {
+		return perms, errors.New("bucket is required")
+	}
+	// the seeds must not have the same permissions as the JWTs
+	if config.Tenant != "" && (config.KeysBucket == "" || config.KeysBucket == config.Bucket) {
+		return perms, errors.New("tenant permissions require a separate keys bucket")
+	}
+	buckets := []string{config.Bucket}
+	if config.KeysBucket != "" && config.KeysBucket != config.Bucket {
+		buckets = append(buckets, config.KeysBucket)
+	}
+	perms.Pub.Allow.Add("$JS.API.INFO")
+	for _, b := range buckets {
+		stream := fmt.Sprintf("KV_%s", b)
+		keys := fmt.Sprintf("$KV.%s.>", b)
+		if config.Tenant != "" {
+			keys = fmt.Sprintf("$KV.%s.%s.>", b, config.Tenant)
+		}
+		perms.Pub.Allow.Add(
+			fmt.Sprintf("$JS.API.STREAM.INFO.%s", stream),
+			keys,
+			fmt.Sprintf("$JS.API.DIRECT.GET.%s.%s", stream, keys),
+			fmt.Sprintf("$JS.API.CONSUMER.CREATE.%s.*.%s", stream, keys),
+			fmt.Sprintf("$JS.FC.%s.>", stream),
+		)
+	}
+	perms.Sub.Allow.Add(fmt.Sprintf("%s.>", InboxPrefix(config.Tenant)))
+	return perms, nil
+}
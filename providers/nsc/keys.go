@@ -0,0 +1,32 @@
+package nsc
+
+import (
+	"github.com/nats-io/nsc/v2/cmd/store"
+	"github.com/synadia-io/jwt-auth-builder.go"
+)
+
+// nscKeyStore is the default KeyStore of the provider, storing the seeds in
+// the nsc keys directory
+type nscKeyStore struct {
+	ks store.KeyStore
+}
+
+func (k *nscKeyStore) GetKey(pk string) (*authb.Key, error) {
+	kp, err := k.ks.GetKeyPair(pk)
+	if err != nil || kp == nil {
+		return nil, err
+	}
+	return authb.KeyFromNkey(kp)
+}
+
+func (k *nscKeyStore) PutKey(key *authb.Key) error {
+	if key == nil || key.Seed == nil {
+		return nil
+	}
+	_, err := k.ks.Store(key.Pair)
+	return err
+}
+
+func (k *nscKeyStore) DeleteKey(pk string) error {
+	return k.ks.Remove(pk)
+}
@@ -1,6 +1,7 @@
 package nsc
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
 
@@ -11,21 +12,36 @@ import (
 	"github.com/synadia-io/jwt-auth-builder.go"
 )
 
+// MetadataFile is the name of the file storing the entity metadata
+// in the operator and account directories
+const MetadataFile = "authb.json"
+
 // NscProvider is an AuthProvider that stores data using the nsc Store.
+// Seeds are stored in the nsc keys directory, or in the KeyStore
+// the provider is created with.
 type NscProvider struct {
 	storesDir string
 	keysDir   string
+	keys      authb.KeyStore
 }
 
 func NewNscProvider(storesDir string, keysDir string) *NscProvider {
-	if storesDir == "" {
-		storesDir = home.NscDataHome(home.StoresSubDirName)
-	}
 	if keysDir == "" {
 		keysDir = home.NscDataHome(home.KeysSubDirName)
 	}
 	store.KeyStorePath = keysDir
-	return &NscProvider{storesDir: storesDir, keysDir: keysDir}
+	p := NewNscProviderWithKeyStore(storesDir, &nscKeyStore{ks: store.NewKeyStore("")})
+	p.keysDir = keysDir
+	return p
+}
+
+// NewNscProviderWithKeyStore returns a provider that stores the JWTs in the
+// nsc stores directory and the seeds in the KeyStore
+func NewNscProviderWithKeyStore(storesDir string, keys authb.KeyStore) *NscProvider {
+	if storesDir == "" {
+		storesDir = home.NscDataHome(home.StoresSubDirName)
+	}
+	return &NscProvider{storesDir: storesDir, keys: keys}
 }
 
 func (a *NscProvider) MaybeMakeDir(path string) error {
@@ -84,26 +100,22 @@ func (a *NscProvider) loadOperator(si store.IStore) (*authb.OperatorData, error)
 		return nil, err
 	}
 	od := &authb.OperatorData{BaseData: authb.BaseData{EntityName: si.GetName(), Loaded: oc.IssuedAt, Token: string(token)}, Claim: oc}
-	ks := store.NewKeyStore(od.EntityName)
-	kp, err := ks.GetKeyPair(oc.Issuer)
+	od.Key, err = authb.LoadKey(a.keys, oc.Subject, nkeys.PrefixByteOperator)
 	if err != nil {
 		return nil, err
 	}
-	if kp != nil {
-		od.Key, _ = authb.KeyFromNkey(kp, nkeys.PrefixByteOperator)
-	}
-	if len(oc.SigningKeys) > 0 {
-		for _, sk := range oc.SigningKeys {
-			skp, _ := ks.GetKeyPair(sk)
-			if skp != nil {
-				k, _ := authb.KeyFromNkey(skp, nkeys.PrefixByteOperator)
-				if k != nil {
-					od.OperatorSigningKeys = append(od.OperatorSigningKeys, k)
-				}
-			}
+	for _, sk := range oc.SigningKeys {
+		k, err := authb.LoadKey(a.keys, sk, nkeys.PrefixByteOperator)
+		if err != nil {
+			return nil, err
 		}
+		od.OperatorSigningKeys = append(od.OperatorSigningKeys, k)
 	}
-	od.AccountDatas, err = a.loadAccounts(si, ks)
+	od.Metadata, err = a.loadMetadata(si, MetadataFile)
+	if err != nil {
+		return nil, err
+	}
+	od.AccountDatas, err = a.loadAccounts(si)
 	if err != nil {
 		return nil, err
 	}
@@ -113,14 +125,14 @@ func (a *NscProvider) loadOperator(si store.IStore) (*authb.OperatorData, error)
 	return od, err
 }
 
-func (a *NscProvider) loadAccounts(si store.IStore, ks store.KeyStore) ([]*authb.AccountData, error) {
+func (a *NscProvider) loadAccounts(si store.IStore) ([]*authb.AccountData, error) {
 	var datas []*authb.AccountData
 	accountNames, err := si.ListSubContainers(store.Accounts)
 	if err != nil {
 		return nil, err
 	}
 	for _, name := range accountNames {
-		data, err := a.loadAccount(si, ks, name)
+		data, err := a.loadAccount(si, name)
 		if err != nil {
 			return nil, err
 		}
@@ -129,7 +141,7 @@ func (a *NscProvider) loadAccounts(si store.IStore, ks store.KeyStore) ([]*authb
 	return datas, nil
 }
 
-func (a *NscProvider) loadAccount(si store.IStore, ks store.KeyStore, name string) (*authb.AccountData, error) {
+func (a *NscProvider) loadAccount(si store.IStore, name string) (*authb.AccountData, error) {
 	ad := &authb.AccountData{BaseData: authb.BaseData{EntityName: name}}
 	token, err := si.ReadRawAccountClaim(name)
 	if err != nil {
@@ -141,22 +153,38 @@ func (a *NscProvider) loadAccount(si store.IStore, ks store.KeyStore, name strin
 		return nil, err
 	}
 	ad.Loaded = ad.Claim.IssuedAt
-	k, _ := ks.GetKeyPair(ad.Claim.Subject)
-	if k != nil {
-		ad.Key, _ = authb.KeyFromNkey(k, nkeys.PrefixByteAccount)
-	}
-	keys := ad.Claim.SigningKeys.Keys()
-	for _, k := range keys {
-		skp, _ := ks.GetKeyPair(k)
-		if skp != nil {
-			sk, _ := authb.KeyFromNkey(skp, nkeys.PrefixByteAccount)
-			if sk != nil {
-				ad.AccountSigningKeys = append(ad.AccountSigningKeys, sk)
-			}
+	ad.Key, err = authb.LoadKey(a.keys, ad.Claim.Subject, nkeys.PrefixByteAccount)
+	if err != nil {
+		return nil, err
+	}
+	for _, pk := range ad.Claim.SigningKeys.Keys() {
+		sk, err := authb.LoadKey(a.keys, pk, nkeys.PrefixByteAccount)
+		if err != nil {
+			return nil, err
+		}
+		ad.AccountSigningKeys = append(ad.AccountSigningKeys, sk)
+	}
+	ad.Metadata, err = a.loadMetadata(si, store.Accounts, name, MetadataFile)
+	if err != nil {
+		return nil, err
+	}
+	pks := []string{ad.Claim.Authorization.XKey}
+	if ad.Metadata != nil {
+		for _, rk := range ad.Metadata.RetiredXKeys {
+			pks = append(pks, rk.Key)
+		}
+	}
+	for _, pk := range pks {
+		if pk == "" {
+			continue
+		}
+		xk, _ := a.keys.GetKey(pk)
+		if xk != nil && nkeys.CompatibleKeyPair(xk.Pair, nkeys.PrefixByteCurve) == nil {
+			ad.XKeys = append(ad.XKeys, xk)
 		}
 	}
 
-	ad.UserDatas, err = a.loadUsers(si, ks, name)
+	ad.UserDatas, err = a.loadUsers(si, name)
 	if err != nil {
 		return nil, err
 	}
@@ -168,14 +196,40 @@ func (a *NscProvider) loadAccount(si store.IStore, ks store.KeyStore, name strin
 	return ad, err
 }
 
-func (a *NscProvider) loadUsers(si store.IStore, ks store.KeyStore, account string) ([]*authb.UserData, error) {
+func (a *NscProvider) loadMetadata(si store.IStore, name ...string) (*authb.EntityMetadata, error) {
+	if !si.Has(name...) {
+		return nil, nil
+	}
+	d, err := si.Read(name...)
+	if err != nil {
+		return nil, err
+	}
+	var md authb.EntityMetadata
+	if err := json.Unmarshal(d, &md); err != nil {
+		return nil, err
+	}
+	return &md, nil
+}
+
+func (a *NscProvider) storeMetadata(si store.IStore, md *authb.EntityMetadata, name ...string) error {
+	if md == nil {
+		return nil
+	}
+	d, err := json.Marshal(md)
+	if err != nil {
+		return err
+	}
+	return si.Write(d, name...)
+}
+
+func (a *NscProvider) loadUsers(si store.IStore, account string) ([]*authb.UserData, error) {
 	var datas []*authb.UserData
 	names, err := si.ListEntries(store.Accounts, account, store.Users)
 	if err != nil {
 		return nil, err
 	}
 	for _, name := range names {
-		data, err := a.loadUser(si, ks, account, name)
+		data, err := a.loadUser(si, account, name)
 		if err != nil {
 			return nil, err
 		}
@@ -184,7 +238,7 @@ func (a *NscProvider) loadUsers(si store.IStore, ks store.KeyStore, account stri
 	return datas, nil
 }
 
-func (a *NscProvider) loadUser(si store.IStore, ks store.KeyStore, account string, name string) (*authb.UserData, error) {
+func (a *NscProvider) loadUser(si store.IStore, account string, name string) (*authb.UserData, error) {
 	var err error
 	ud := &authb.UserData{BaseData: authb.BaseData{EntityName: name}}
 	token, err := si.ReadRawUserClaim(account, name)
@@ -197,11 +251,7 @@ func (a *NscProvider) loadUser(si store.IStore, ks store.KeyStore, account strin
 		return nil, err
 	}
 	ud.Loaded = ud.Claim.IssuedAt
-	kp, err := ks.GetKeyPair(ud.Claim.Subject)
-	if err != nil {
-		return nil, err
-	}
-	ud.Key, err = authb.KeyFromNkey(kp, nkeys.PrefixByteUser)
+	ud.Key, err = authb.LoadKey(a.keys, ud.Claim.Subject, nkeys.PrefixByteUser)
 	if err != nil {
 		return nil, err
 	}
@@ -211,16 +261,17 @@ func (a *NscProvider) loadUser(si store.IStore, ks store.KeyStore, account strin
 func (a *NscProvider) Store(operators []*authb.OperatorData) error {
 	for _, o := range operators {
 		var err error
-		ks := store.NewKeyStore(o.EntityName)
-
 		if o.Loaded == 0 {
-			nk := &store.NamedKey{Name: o.EntityName, KP: o.Key.Pair}
+			// operators without their seed, such as imported bundles, are managed
+			nk := &store.NamedKey{Name: o.EntityName}
+			if o.Key.Seed != nil {
+				nk.KP = o.Key.Pair
+			}
 			_, err = store.CreateStore("", a.storesDir, nk)
 			if err != nil {
 				return err
 			}
-			_, err = ks.Store(o.Key.Pair)
-			if err != nil {
+			if err := a.keys.PutKey(o.Key); err != nil {
 				return err
 			}
 		}
@@ -234,18 +285,19 @@ func (a *NscProvider) Store(operators []*authb.OperatorData) error {
 				return err
 			}
 		}
+		if err := a.storeMetadata(s, o.Metadata, MetadataFile); err != nil {
+			return err
+		}
 		// this will save all keys that were added, operator, account, users..
 		for _, k := range o.AddedKeys {
-			_, err := ks.Store(k.Pair)
-			if err != nil {
+			if err := a.keys.PutKey(k); err != nil {
 				return err
 			}
 		}
 		o.AddedKeys = nil
 		// this will remove all keys that were added, operator, account, users..
 		for _, k := range o.DeletedKeys {
-			err := ks.Remove(k)
-			if err != nil {
+			if err := a.keys.DeleteKey(k); err != nil {
 				return err
 			}
 		}
@@ -260,6 +312,9 @@ func (a *NscProvider) Store(operators []*authb.OperatorData) error {
 				// check that signing keys were not modified
 				account.Loaded = account.Claim.IssuedAt
 			}
+			if err := a.storeMetadata(s, account.Metadata, store.Accounts, account.EntityName, MetadataFile); err != nil {
+				return err
+			}
 
 			for _, u := range account.UserDatas {
 				if u.Ephemeral {
@@ -279,6 +334,11 @@ func (a *NscProvider) Store(operators []*authb.OperatorData) error {
 				}
 			}
 		}
+		// entities are stored by name, so rotated identities overwrite their entries
+		o.PreviousKey = ""
+		for _, account := range o.AccountDatas {
+			account.PreviousKey = ""
+		}
 		// update the loaded so that other mods can be detected
 		o.Loaded = o.Claim.IssuedAt
 	}
@@ -0,0 +1,98 @@
+package authb
+
+import (
+	"fmt"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+// checkReadOnly returns an error if the entity is a read-only view of a JWT
+// that is not owned by the store
+func (b *BaseData) checkReadOnly(kind string) error {
+	if b.readOnly {
+		return fmt.Errorf("%s is read-only", kind)
+	}
+	return nil
+}
+
+// NewOperatorFromJWT returns a read-only Operator for inspecting the JWT.
+// The operator has no accounts, and edits to it fail.
+func NewOperatorFromJWT(token string) (Operator, error) {
+	oc, err := jwt.DecodeOperatorClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	return &OperatorData{
+		Claim: oc,
+		BaseData: BaseData{
+			Loaded:     oc.IssuedAt,
+			EntityName: oc.Name,
+			Token:      token,
+			readOnly:   true,
+		},
+	}, nil
+}
+
+// NewUserFromJWT returns a read-only User for inspecting the JWT. The account
+// is optional, typically created with NewAccountFromJWT, and is used to resolve
+// the scope of users issued by scoped signing keys.
+func NewUserFromJWT(token string, account Account) (User, error) {
+	uc, err := jwt.DecodeUserClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	return newReadOnlyUser(uc, token, nil, account)
+}
+
+// ParseCreds returns a read-only User for inspecting a creds file. The account
+// is optional, and is used to resolve the scope of the user. The user's Creds
+// returns the original credentials as long as no expiry is requested.
+func ParseCreds(creds []byte, account Account) (User, error) {
+	token, err := jwt.ParseDecoratedJWT(creds)
+	if err != nil {
+		return nil, err
+	}
+	uc, err := jwt.DecodeUserClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	kp, err := jwt.ParseDecoratedUserNKey(creds)
+	if err != nil {
+		return nil, err
+	}
+	k, err := KeyFromNkey(kp, nkeys.PrefixByteUser)
+	if err != nil {
+		return nil, err
+	}
+	if k.Public != uc.Subject {
+		return nil, fmt.Errorf("creds seed doesn't match the user %q", uc.Subject)
+	}
+	return newReadOnlyUser(uc, token, k, account)
+}
+
+func newReadOnlyUser(uc *jwt.UserClaims, token string, key *Key, account Account) (User, error) {
+	ud := &UserData{
+		BaseData: BaseData{
+			Loaded:     uc.IssuedAt,
+			EntityName: uc.Name,
+			Key:        key,
+			Token:      token,
+			readOnly:   true,
+		},
+		Claim: uc,
+	}
+	if !isNil(account) {
+		ad, ok := account.(*AccountData)
+		if !ok {
+			return nil, fmt.Errorf("unsupported account type %T", account)
+		}
+		if ad.Subject() != ud.IssuerAccount() {
+			return nil, fmt.Errorf("user was not issued by account %q", ad.Subject())
+		}
+		ud.AccountData = ad
+		scope, ok := ad.Claim.SigningKeys.GetScope(uc.Issuer)
+		ud.RejectEdits = ok && scope != nil
+	}
+	return ud, nil
+}
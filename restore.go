@@ -0,0 +1,57 @@
+package authb
+
+import (
+	"fmt"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// Restore replaces the configuration of the account with the one in the specified
+// JWT, typically an earlier version of the account, and re-issues it with the
+// current key. Signing keys and revocations are not restored.
+func (a *AccountData) Restore(token string) error {
+	if err := a.checkReadOnly("account"); err != nil {
+		return err
+	}
+	ac, err := jwt.DecodeAccountClaims(token)
+	if err != nil {
+		return err
+	}
+	if ac.Subject != a.Subject() {
+		return fmt.Errorf("JWT is for account %s", ac.Subject)
+	}
+	// keys and revocations are kept, restoring them would re-enable keys
+	// that were removed and users that were revoked
+	ac.Name = a.Claim.Name
+	ac.Issuer = a.Claim.Issuer
+	ac.SigningKeys = a.Claim.SigningKeys
+	ac.Revocations = a.Claim.Revocations
+	ac.Authorization.XKey = a.Claim.Authorization.XKey
+	a.Claim = ac
+	return a.update()
+}
+
+// Restore replaces the configuration of the user with the one in the specified
+// JWT, typically an earlier version of the user, and re-issues it with the
+// current issuer.
+func (u *UserData) Restore(token string) error {
+	if err := u.checkReadOnly("user"); err != nil {
+		return err
+	}
+	uc, err := jwt.DecodeUserClaims(token)
+	if err != nil {
+		return err
+	}
+	if uc.Subject != u.Subject() {
+		return fmt.Errorf("JWT is for user %s", uc.Subject)
+	}
+	// the user is issued by the current key, scoped users have no permissions
+	uc.Name = u.Claim.Name
+	uc.Issuer = u.Claim.Issuer
+	uc.IssuerAccount = u.Claim.IssuerAccount
+	if u.RejectEdits {
+		uc.UserPermissionLimits = u.Claim.UserPermissionLimits
+	}
+	u.Claim = uc
+	return u.update()
+}
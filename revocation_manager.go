@@ -0,0 +1,179 @@
+package authb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+// RevocationReport describes the effect of a revocation
+type RevocationReport struct {
+	// Revoked are the public keys added to revocation lists
+	Revoked []string
+	// Exports are the subjects of the exports whose revocation lists were updated
+	Exports []string
+	// Affected is the number of live entities in the store affected by the revocation
+	Affected int
+	// Compacted are the revocations made redundant by wildcard revocations
+	Compacted []RevocationEntry
+	// Key is the signing key that replaced a revoked signing key
+	Key string
+	// StoredOnly is set when only the users in the store were revoked. Users that
+	// were issued but not stored, such as ephemeral users, remain valid.
+	StoredOnly bool
+}
+
+type revocationManager struct {
+	data *AccountData
+}
+
+func (a *AccountData) RevocationManager() RevocationManager {
+	return &revocationManager{data: a}
+}
+
+// live returns true if the user is not expired and not already revoked at the specified time
+func (m *revocationManager) live(u *UserData, now time.Time) bool {
+	if u.Claim.Expires > 0 && u.Claim.Expires <= now.Unix() {
+		return false
+	}
+	return !m.data.Claim.Revocations.IsRevoked(u.Subject(), time.Unix(u.Claim.IssuedAt, 0))
+}
+
+// revokeUsers revokes the stored users matching the filter
+func (m *revocationManager) revokeUsers(at time.Time, match func(u *UserData) bool) (*RevocationReport, error) {
+	now := time.Now()
+	if at.IsZero() {
+		at = now
+	}
+	r := &RevocationReport{StoredOnly: true}
+	var users []*UserData
+	for _, u := range m.data.UserDatas {
+		if !match(u) {
+			continue
+		}
+		if m.live(u, now) && u.Claim.IssuedAt <= at.Unix() {
+			r.Affected++
+		}
+		users = append(users, u)
+	}
+	return r, m.revoke(r, users, at)
+}
+
+// revoke adds the users to the revocation list and compacts it
+func (m *revocationManager) revoke(r *RevocationReport, users []*UserData, at time.Time) error {
+	if len(users) == 0 {
+		return nil
+	}
+	list := m.data.getRevocations()
+	for _, u := range users {
+		list.Revoke(u.Subject(), at)
+		r.Revoked = append(r.Revoked, u.Subject())
+	}
+	for _, e := range list.MaybeCompact() {
+		r.Compacted = append(r.Compacted, &revocation{publicKey: e.PublicKey, before: time.Unix(e.TimeStamp, 0)})
+	}
+	return m.data.update()
+}
+
+func (m *revocationManager) RevokeSigningKey(key string, at time.Time) (*RevocationReport, error) {
+	if _, ok := m.data.Claim.SigningKeys[key]; !ok {
+		return nil, fmt.Errorf("signing key %q: %w", key, ErrNotFound)
+	}
+	if err := m.data.checkReadOnly("account"); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if at.IsZero() {
+		at = now
+	}
+	r := &RevocationReport{}
+	var users []*UserData
+	for _, u := range m.data.UserDatas {
+		if u.Claim.Issuer != key || u.Claim.IssuedAt > at.Unix() {
+			continue
+		}
+		if m.live(u, now) {
+			r.Affected++
+		}
+		users = append(users, u)
+	}
+	// replacing the key invalidates everything it issued, including users that
+	// are not stored. The rotation reissues the stored users, so the ones issued
+	// before the revocation time are revoked after it.
+	k, err := m.data.ScopedSigningKeys().Rotate(key)
+	if err != nil {
+		return nil, err
+	}
+	r.Key = k
+	return r, m.revoke(r, users, time.Now())
+}
+
+func (m *revocationManager) RevokeTag(tag string, at time.Time) (*RevocationReport, error) {
+	if err := NotEmpty(tag); err != nil {
+		return nil, err
+	}
+	return m.revokeUsers(at, func(u *UserData) bool {
+		return u.Claim.Tags.Contains(tag)
+	})
+}
+
+func (m *revocationManager) RevokeImporter(account string, at time.Time) (*RevocationReport, error) {
+	if err := m.data.checkReadOnly("account"); err != nil {
+		return nil, err
+	}
+	k, err := KeyFrom(account, nkeys.PrefixByteAccount)
+	if err != nil {
+		if importer, gerr := m.data.Operator.Get(account); gerr == nil {
+			k, err = KeyFrom(importer.Subject(), nkeys.PrefixByteAccount)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	if at.IsZero() {
+		at = now
+	}
+	r := &RevocationReport{}
+	var exports []*jwt.Export
+	for _, e := range m.data.Claim.Exports {
+		if !e.TokenReq {
+			continue
+		}
+		if e.Revocations == nil {
+			e.Revocations = jwt.RevocationList{}
+		}
+		e.Revocations.Revoke(k.Public, at)
+		for _, c := range e.Revocations.MaybeCompact() {
+			r.Compacted = append(r.Compacted, &revocation{publicKey: c.PublicKey, before: time.Unix(c.TimeStamp, 0)})
+		}
+		exports = append(exports, e)
+		r.Exports = append(r.Exports, string(e.Subject))
+	}
+	if len(exports) == 0 {
+		return nil, errors.New("account doesn't have exports requiring activations")
+	}
+	r.Revoked = append(r.Revoked, k.Public)
+
+	if importer := m.data.Operator.accountData(k.Public); importer != nil {
+		for _, imp := range importer.Claim.Imports {
+			if imp.Account != m.data.Subject() || imp.Token == "" {
+				continue
+			}
+			ac, err := jwt.DecodeActivationClaims(imp.Token)
+			if err != nil {
+				continue
+			}
+			if ac.Expires > 0 && ac.Expires <= now.Unix() {
+				continue
+			}
+			if ac.IssuedAt <= at.Unix() {
+				r.Affected++
+			}
+		}
+	}
+	return r, m.data.update()
+}
@@ -1,6 +1,8 @@
 package authb
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -11,6 +13,23 @@ import (
 
 var ErrRevocationPublicExportsNotAllowed = fmt.Errorf("public exports are not allowed")
 
+// ErrSizeBudgetExceeded is returned when an account JWT exceeds the size budget
+var ErrSizeBudgetExceeded = errors.New("account JWT exceeds the size budget")
+
+// RevocationStats describes a revocation list
+type RevocationStats struct {
+	// Entries is the number of revocations
+	Entries int
+	// Bytes is the approximate number of bytes the revocations add to the encoded JWT
+	Bytes int
+	// JWTSize is the size in bytes of the JWT containing the revocations
+	JWTSize int
+	// Oldest is the time of the oldest revocation, zero if there are none
+	Oldest time.Time
+	// Expired is the number of revocations that Prune would remove
+	Expired int
+}
+
 func NewRevocationEntry(key string, before time.Time) RevocationEntry {
 	return &revocation{publicKey: key, before: before}
 }
@@ -31,6 +50,8 @@ func (t *revocation) At() time.Time {
 type revocationTarget interface {
 	getRevocationPrefix() nkeys.PrefixByte
 	getRevocations() jwt.RevocationList
+	getToken() string
+	expiredRevocations(now time.Time) []string
 	update() error
 }
 
@@ -85,10 +106,63 @@ func (b *revocations) addRevocation(key string, before time.Time) error {
 }
 
 func (b *revocations) Add(key string, at time.Time) error {
+	list := b.data.getRevocations()
+	previous := make(map[string]int64, len(list))
+	for pk, ts := range list {
+		previous[pk] = ts
+	}
 	if err := b.addRevocation(key, at); err != nil {
 		return err
 	}
-	return b.data.update()
+	if err := b.data.update(); err != nil {
+		// restore the list, the revocation is not applied
+		for pk := range list {
+			delete(list, pk)
+		}
+		for pk, ts := range previous {
+			list[pk] = ts
+		}
+		return err
+	}
+	return nil
+}
+
+// prune removes revocations that no longer match any valid credentials
+func (b *revocations) prune() []RevocationEntry {
+	var buf []RevocationEntry
+	list := b.data.getRevocations()
+	for _, pk := range b.data.expiredRevocations(time.Now()) {
+		buf = append(buf, &revocation{publicKey: pk, before: time.Unix(list[pk], 0)})
+		delete(list, pk)
+	}
+	return buf
+}
+
+func (b *revocations) Prune() ([]RevocationEntry, error) {
+	buf := b.prune()
+	if len(buf) == 0 {
+		return nil, nil
+	}
+	return buf, b.data.update()
+}
+
+func (b *revocations) Stats() RevocationStats {
+	list := b.data.getRevocations()
+	s := RevocationStats{
+		Entries: len(list),
+		JWTSize: len(b.data.getToken()),
+		Expired: len(b.data.expiredRevocations(time.Now())),
+	}
+	for _, at := range list {
+		if s.Oldest.IsZero() || at < s.Oldest.Unix() {
+			s.Oldest = time.Unix(at, 0)
+		}
+	}
+	if len(list) > 0 {
+		d, _ := json.Marshal(list)
+		s.Bytes = base64.RawURLEncoding.EncodedLen(len(d) + len(`,"revocations":`))
+	}
+	return s
 }
 
 func (b *revocations) delete(key string) (bool, error) {
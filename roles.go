@@ -0,0 +1,279 @@
+package authb
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// ScopeStrategy selects the scoped signing key used to issue a user when
+// several scopes share the same role
+type ScopeStrategy int
+
+const (
+	// FirstScope picks the scope with the lowest public key, so the choice is stable
+	FirstScope ScopeStrategy = iota
+	// RandomScope picks one of the scopes at random
+	RandomScope
+	// LeastUsedScope picks the scope that has issued the fewest users in the account
+	LeastUsedScope
+)
+
+// RoleOptions customize users provisioned by role
+type RoleOptions struct {
+	// Strategy used to pick the scope when several scopes share the role
+	Strategy ScopeStrategy
+	// Tags to add to the user. Tags referenced by tag() templates in
+	// the scope are required.
+	Tags []string
+	// Expiry of the user, if zero the user doesn't expire
+	Expiry time.Duration
+}
+
+// ProvisionedUser is a user provisioned by role and its creds
+type ProvisionedUser struct {
+	User  User
+	Creds []byte
+}
+
+// RosterFormat is the encoding of a roster
+type RosterFormat string
+
+const (
+	// RosterCSV is a CSV roster with a header row naming the columns
+	// name, role, tags and expiry. Tags are separated by ';' and the
+	// expiry is a duration such as 720h. Only name and role are required.
+	RosterCSV RosterFormat = "csv"
+	// RosterJSON is a JSON array of objects with the fields name, role,
+	// tags and expiry.
+	RosterJSON RosterFormat = "json"
+)
+
+// RosterEntry describes a user to provision by role
+type RosterEntry struct {
+	Name   string
+	Role   string
+	Tags   []string
+	Expiry time.Duration
+}
+
+type rosterEntryJSON struct {
+	Name   string   `json:"name"`
+	Role   string   `json:"role"`
+	Tags   []string `json:"tags,omitempty"`
+	Expiry string   `json:"expiry,omitempty"`
+}
+
+func newRosterEntry(name, role string, tags []string, expiry string) (RosterEntry, error) {
+	e := RosterEntry{Name: strings.TrimSpace(name), Role: strings.TrimSpace(role), Tags: tags}
+	if e.Name == "" || e.Role == "" {
+		return e, errors.New("roster entries require a name and a role")
+	}
+	if expiry = strings.TrimSpace(expiry); expiry != "" {
+		d, err := time.ParseDuration(expiry)
+		if err != nil {
+			return e, fmt.Errorf("invalid expiry for %q: %w", e.Name, err)
+		}
+		e.Expiry = d
+	}
+	return e, nil
+}
+
+// ParseRoster reads the roster entries in the specified format
+func ParseRoster(r io.Reader, format RosterFormat) ([]RosterEntry, error) {
+	var entries []RosterEntry
+	switch format {
+	case RosterJSON:
+		var buf []rosterEntryJSON
+		if err := json.NewDecoder(r).Decode(&buf); err != nil {
+			return nil, err
+		}
+		for _, v := range buf {
+			e, err := newRosterEntry(v.Name, v.Role, v.Tags, v.Expiry)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		}
+	case RosterCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		records, err := cr.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, nil
+		}
+		columns := make(map[string]int)
+		for i, c := range records[0] {
+			columns[strings.ToLower(strings.TrimSpace(c))] = i
+		}
+		for _, c := range []string{"name", "role"} {
+			if _, ok := columns[c]; !ok {
+				return nil, fmt.Errorf("roster header is missing the %q column", c)
+			}
+		}
+		field := func(record []string, name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return record[i]
+		}
+		for _, record := range records[1:] {
+			var tags []string
+			for _, t := range strings.Split(field(record, "tags"), ";") {
+				if t = strings.TrimSpace(t); t != "" {
+					tags = append(tags, t)
+				}
+			}
+			e, err := newRosterEntry(field(record, "name"), field(record, "role"), tags, field(record, "expiry"))
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported roster format %q", format)
+	}
+	return entries, nil
+}
+
+// scopeForRole selects the scope used to issue a user with the specified role
+func (a *UsersImpl) scopeForRole(role string, strategy ScopeStrategy) (*jwt.UserScope, error) {
+	var scopes []*jwt.UserScope
+	for _, v := range a.accountData.Claim.SigningKeys {
+		if scope, ok := v.(*jwt.UserScope); ok && scope != nil && scope.Role == role {
+			if _, retiring := a.accountData.retiring(scope.Key); retiring {
+				continue
+			}
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: no scope with role %q", ErrNotFound, role)
+	}
+	sort.Slice(scopes, func(i, j int) bool {
+		return scopes[i].Key < scopes[j].Key
+	})
+	switch strategy {
+	case FirstScope:
+		return scopes[0], nil
+	case RandomScope:
+		return scopes[rand.Intn(len(scopes))], nil
+	case LeastUsedScope:
+		counts := make(map[string]int)
+		for _, u := range a.accountData.UserDatas {
+			counts[u.Claim.Issuer]++
+		}
+		selected := scopes[0]
+		for _, s := range scopes[1:] {
+			if counts[s.Key] < counts[selected.Key] {
+				selected = s
+			}
+		}
+		return selected, nil
+	default:
+		return nil, fmt.Errorf("unsupported scope strategy %d", strategy)
+	}
+}
+
+// requireTags checks that the tags can satisfy the tag() templates in the scope
+func requireTags(scope *jwt.UserScope, tags []string) error {
+	var tl jwt.TagList
+	tl.Add(tags...)
+	for _, name := range templateTags(scope.Template) {
+		if len(tagValues(tl, name)) == 0 {
+			return fmt.Errorf("role %q requires a %q tag", scope.Role, name)
+		}
+	}
+	return nil
+}
+
+func (a *UsersImpl) AddWithRole(name string, role string, opts *RoleOptions) (User, []byte, error) {
+	if opts == nil {
+		opts = &RoleOptions{}
+	}
+	pu, err := a.addWithRole(name, role, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pu.User, pu.Creds, nil
+}
+
+func (a *UsersImpl) addWithRole(name string, role string, opts *RoleOptions) (*ProvisionedUser, error) {
+	if err := NotEmpty(name); err != nil {
+		return nil, err
+	}
+	scope, err := a.scopeForRole(role, opts.Strategy)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireTags(scope, opts.Tags); err != nil {
+		return nil, err
+	}
+	u, err := a.Add(name, scope.Key)
+	if err != nil {
+		return nil, err
+	}
+	ud := u.(*UserData)
+	ud.Claim.Tags.Add(opts.Tags...)
+	if opts.Expiry > 0 {
+		ud.Claim.Expires = time.Now().Add(opts.Expiry).Unix()
+	}
+	if err := ud.update(); err != nil {
+		a.rollback(ud)
+		return nil, err
+	}
+	creds, err := ud.Creds(0)
+	if err != nil {
+		a.rollback(ud)
+		return nil, err
+	}
+	return &ProvisionedUser{User: ud, Creds: creds}, nil
+}
+
+func (a *UsersImpl) AddRoster(entries []RosterEntry, strategy ScopeStrategy) ([]ProvisionedUser, error) {
+	var added []*UserData
+	buf := make([]ProvisionedUser, 0, len(entries))
+	for _, e := range entries {
+		pu, err := a.addWithRole(e.Name, e.Role, &RoleOptions{Strategy: strategy, Tags: e.Tags, Expiry: e.Expiry})
+		if err != nil {
+			a.rollback(added...)
+			return nil, fmt.Errorf("error provisioning %q: %w", e.Name, err)
+		}
+		added = append(added, pu.User.(*UserData))
+		buf = append(buf, *pu)
+	}
+	return buf, nil
+}
+
+// rollback forgets users that were added but not yet stored
+func (a *UsersImpl) rollback(users ...*UserData) {
+	for _, u := range users {
+		for idx, v := range a.accountData.UserDatas {
+			if v == u {
+				a.accountData.UserDatas = append(a.accountData.UserDatas[:idx], a.accountData.UserDatas[idx+1:]...)
+				a.accountData.users = nil
+				break
+			}
+		}
+		keys := a.accountData.Operator.AddedKeys
+		for idx, k := range keys {
+			if k == u.Key {
+				a.accountData.Operator.AddedKeys = append(keys[:idx], keys[idx+1:]...)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package authb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+// ErrRotationPending is returned when completing a staged rotation before its deadline
+var ErrRotationPending = errors.New("signing key rotation deadline has not been reached")
+
+// metadata returns the entity metadata, creating it if necessary
+func (b *BaseData) metadata() *EntityMetadata {
+	if b.Metadata == nil {
+		b.Metadata = &EntityMetadata{}
+	}
+	return b.Metadata
+}
+
+// retiring returns the staged rotation for the key
+func (b *BaseData) retiring(key string) (RetiringKey, bool) {
+	if b.Metadata != nil {
+		for _, rk := range b.Metadata.RetiringKeys {
+			if rk.Key == key {
+				return rk, true
+			}
+		}
+	}
+	return RetiringKey{}, false
+}
+
+// replacement returns the key that issues new entities in place of the
+// specified key, which is the key itself unless it is retiring. Replacements
+// are new keys, so following rotations that were chained always terminates.
+func (b *BaseData) replacement(key string) string {
+	for {
+		rk, ok := b.retiring(key)
+		if !ok {
+			return key
+		}
+		key = rk.Replacement
+	}
+}
+
+func (b *BaseData) dropRetiring(key string) {
+	if b.Metadata == nil {
+		return
+	}
+	var buf []RetiringKey
+	for _, rk := range b.Metadata.RetiringKeys {
+		if rk.Key != key && rk.Replacement != key {
+			buf = append(buf, rk)
+		}
+	}
+	b.Metadata.RetiringKeys = buf
+}
+
+func (b *BaseData) startRotation(key string, replacement string, overlap time.Duration) {
+	md := b.metadata()
+	md.RetiringKeys = append(md.RetiringKeys, RetiringKey{
+		Key:         key,
+		Replacement: replacement,
+		Deadline:    time.Now().Add(overlap).Unix(),
+	})
+}
+
+func (b *BaseData) canComplete(key string, force bool) (RetiringKey, error) {
+	rk, ok := b.retiring(key)
+	if !ok {
+		return rk, fmt.Errorf("signing key %q is not being rotated: %w", key, ErrNotFound)
+	}
+	if !force && time.Now().Unix() < rk.Deadline {
+		return rk, fmt.Errorf("%w: rotation of %q completes after %s", ErrRotationPending, key,
+			time.Unix(rk.Deadline, 0).UTC().Format(time.RFC3339))
+	}
+	return rk, nil
+}
+
+func (os *operatorSigningKeys) StartRotation(key string, overlap time.Duration) (string, error) {
+	if !os.data.Claim.SigningKeys.Contains(key) {
+		return "", fmt.Errorf("signing key %q: %w", key, ErrNotFound)
+	}
+	if _, ok := os.data.retiring(key); ok {
+		return "", fmt.Errorf("signing key %q is already being rotated", key)
+	}
+	k, err := os.add()
+	if err != nil {
+		return "", err
+	}
+	os.data.startRotation(key, k.Public, overlap)
+	return k.Public, nil
+}
+
+func (os *operatorSigningKeys) CompleteRotation(key string, force bool) (string, error) {
+	rk, err := os.data.canComplete(key, force)
+	if err != nil {
+		return "", err
+	}
+	var k *Key
+	for _, sk := range os.data.OperatorSigningKeys {
+		if sk.Public == rk.Replacement {
+			k = sk
+			break
+		}
+	}
+	if k == nil {
+		return "", fmt.Errorf("replacement signing key %q: %w", rk.Replacement, ErrNotFound)
+	}
+	// re-issue the accounts before deleting the key, restoring them on failure
+	reissued := make(map[*AccountData]BaseData)
+	restore := func() {
+		for a, bd := range reissued {
+			a.BaseData = bd
+			a.Claim, _ = jwt.DecodeAccountClaims(a.Token)
+		}
+	}
+	for _, a := range os.data.AccountDatas {
+		if a.Claim.Issuer == key {
+			reissued[a] = a.BaseData
+			if err := a.issue(k); err != nil {
+				restore()
+				return "", err
+			}
+		}
+	}
+	if _, err := os.Delete(key); err != nil {
+		restore()
+		return "", err
+	}
+	return k.Public, nil
+}
+
+func (os *operatorSigningKeys) Retiring() []RotationStatus {
+	if os.data.Metadata == nil {
+		return nil
+	}
+	var buf []RotationStatus
+	for _, rk := range os.data.Metadata.RetiringKeys {
+		s := RotationStatus{RetiringKey: rk}
+		for _, a := range os.data.AccountDatas {
+			if a.Claim.Issuer == rk.Key {
+				s.Dependents = append(s.Dependents, a.EntityName)
+			}
+		}
+		buf = append(buf, s)
+	}
+	return buf
+}
+
+func (as *accountSigningKeys) StartRotation(key string, overlap time.Duration) (string, error) {
+	if err := as.data.checkReadOnly("account"); err != nil {
+		return "", err
+	}
+	v, ok := as.data.Claim.SigningKeys[key]
+	if !ok {
+		return "", fmt.Errorf("signing key %q: %w", key, ErrNotFound)
+	}
+	if _, ok := as.data.retiring(key); ok {
+		return "", fmt.Errorf("signing key %q is already being rotated", key)
+	}
+	if v == nil {
+		pk, err := as.Add()
+		if err != nil {
+			return "", err
+		}
+		as.data.startRotation(key, pk, overlap)
+		return pk, nil
+	}
+
+	k, err := as.data.Operator.SigningService.NewKey(nkeys.PrefixByteAccount)
+	if err != nil {
+		return "", err
+	}
+	// deep copy the scope so that edits to either key don't affect the other
+	d, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	scope := jwt.NewUserScope()
+	if err := json.Unmarshal(d, scope); err != nil {
+		return "", err
+	}
+	scope.Key = k.Public
+	as.data.Claim.SigningKeys.AddScopedSigner(scope)
+	if err := as.data.update(); err != nil {
+		delete(as.data.Claim.SigningKeys, k.Public)
+		return "", err
+	}
+	as.data.Operator.AddedKeys = append(as.data.Operator.AddedKeys, k)
+	as.data.AccountSigningKeys = append(as.data.AccountSigningKeys, k)
+	as.data.startRotation(key, k.Public, overlap)
+	return k.Public, nil
+}
+
+func (as *accountSigningKeys) CompleteRotation(key string, force bool) (string, error) {
+	rk, err := as.data.canComplete(key, force)
+	if err != nil {
+		return "", err
+	}
+	if _, _, err := as.data.getKey(rk.Replacement); err != nil {
+		return "", fmt.Errorf("replacement signing key %q: %w", rk.Replacement, ErrNotFound)
+	}
+	// re-issue the users before deleting the key, restoring them on failure
+	reissued := make(map[*UserData]BaseData)
+	restore := func() {
+		for u, bd := range reissued {
+			u.BaseData = bd
+			u.Claim, _ = jwt.DecodeUserClaims(u.Token)
+		}
+	}
+	for _, u := range as.data.UserDatas {
+		if u.Claim.Issuer == key {
+			reissued[u] = u.BaseData
+			u.Claim.Issuer = rk.Replacement
+			if err := u.update(); err != nil {
+				restore()
+				return "", err
+			}
+		}
+	}
+	if _, err := as.Delete(key); err != nil {
+		restore()
+		return "", err
+	}
+	return rk.Replacement, nil
+}
+
+func (as *accountSigningKeys) Retiring() []RotationStatus {
+	if as.data.Metadata == nil {
+		return nil
+	}
+	var buf []RotationStatus
+	for _, rk := range as.data.Metadata.RetiringKeys {
+		s := RotationStatus{RetiringKey: rk}
+		for _, u := range as.data.UserDatas {
+			if u.Claim.Issuer == rk.Key {
+				s.Dependents = append(s.Dependents, u.EntityName)
+			}
+		}
+		buf = append(buf, s)
+	}
+	return buf
+}
@@ -80,6 +80,9 @@ func (p *PermissionsImpl) SetAllow(subjects ...string) error {
 	if p.rejectEdits {
 		return ErrUserIsScoped
 	}
+	if err := p.validateTemplates(subjects); err != nil {
+		return err
+	}
 	if p.pub {
 		p.limits.Pub.Allow = subjects
 	} else {
@@ -88,6 +91,21 @@ func (p *PermissionsImpl) SetAllow(subjects ...string) error {
 	return p.update()
 }
 
+// validateTemplates rejects malformed permission templates on scopes,
+// as the server only processes templates for scoped users
+func (p *PermissionsImpl) validateTemplates(subjects []string) error {
+	if p.scope == nil {
+		return nil
+	}
+	var errs []error
+	for _, subject := range subjects {
+		if err := validateTemplateSyntax(subject); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func (p *PermissionsImpl) Deny() []string {
 	if p.pub {
 		return p.limits.Pub.Deny
@@ -100,6 +118,9 @@ func (p *PermissionsImpl) SetDeny(subjects ...string) error {
 	if p.rejectEdits {
 		return ErrUserIsScoped
 	}
+	if err := p.validateTemplates(subjects); err != nil {
+		return err
+	}
 	if p.pub {
 		p.limits.Pub.Deny = subjects
 	} else {
@@ -366,6 +387,25 @@ func (s *ScopeImpl) SetDescription(description string) error {
 	return s.update()
 }
 
+func (s *ScopeImpl) ValidateTemplates() error {
+	return validateTemplates(s.limits, s.accountData.Claim)
+}
+
+func (s *ScopeImpl) PreviewTemplates(u User) (*EffectivePermissions, error) {
+	ud, ok := u.(*UserData)
+	if !ok || ud.Claim == nil {
+		return nil, errors.New("unsupported user")
+	}
+	if err := s.ValidateTemplates(); err != nil {
+		return nil, err
+	}
+	// preview the user as if it had been issued by the scope
+	uc := *ud.Claim
+	uc.Issuer = s.scope.Key
+	uc.IssuerAccount = s.accountData.Subject()
+	return resolvePermissions(&uc, s.accountData.Claim, s.scope)
+}
+
 func (s *ScopeImpl) update() error {
 	s.accountData.Claim.SigningKeys[s.scope.Key] = s.scope
 	return s.accountData.update()
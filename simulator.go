@@ -0,0 +1,640 @@
+package authb
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// Action is the client operation evaluated by CheckPermission
+type Action string
+
+const (
+	// Publish evaluates publishing a message to a subject
+	Publish Action = "publish"
+	// Subscribe evaluates creating a subscription on a subject
+	Subscribe Action = "subscribe"
+)
+
+// maxRouteDepth bounds how many imports are followed when resolving routes
+const maxRouteDepth = 8
+
+// ConnectionContext describes the client connection used to evaluate
+// a permission check. Unset values skip the associated checks unless
+// the user requires them.
+type ConnectionContext struct {
+	// ConnectionType is the type of connection, such as jwt.ConnectionTypeStandard.
+	// If empty, jwt.ConnectionTypeStandard is assumed.
+	ConnectionType string
+	// Source is the IP address of the client
+	Source string
+	// Time is the time of the operation. If zero, the current time is used
+	Time time.Time
+	// Queue is the queue group used by a subscription
+	Queue string
+}
+
+// PermissionHop is an account and subject a message travels through
+type PermissionHop struct {
+	// Account is the public key of the account
+	Account string
+	// AccountName is the name of the account if it is known
+	AccountName string
+	// Subject is the subject in the account
+	Subject string
+	// Via describes how the message arrived at the account, empty for the
+	// account of the user
+	Via string
+}
+
+// PermissionDecision is the result of CheckPermission
+type PermissionDecision struct {
+	// Allowed is true if the server will allow the operation
+	Allowed bool
+	// Action that was evaluated
+	Action Action
+	// Subject that was evaluated
+	Subject string
+	// ResponseOnly is true if the publish was denied by the permissions, but
+	// the user has response permissions - the publish will be allowed only as
+	// a reply to a request the user received.
+	ResponseOnly bool
+	// Reasons explains the decision in the order the checks were done
+	Reasons []string
+	// Routes lists the accounts and subjects the message will flow through
+	// when the operation is allowed. For subscriptions these are the sources
+	// of the messages.
+	Routes [][]PermissionHop
+}
+
+func (d *PermissionDecision) String() string {
+	var buf strings.Builder
+	verdict := "DENIED"
+	if d.Allowed {
+		verdict = "ALLOWED"
+	} else if d.ResponseOnly {
+		verdict = "DENIED (allowed only as a response)"
+	}
+	buf.WriteString(fmt.Sprintf("%s %q: %s\n", d.Action, d.Subject, verdict))
+	for _, r := range d.Reasons {
+		buf.WriteString(fmt.Sprintf("  - %s\n", r))
+	}
+	for _, route := range d.Routes {
+		var hops []string
+		for _, h := range route {
+			n := h.AccountName
+			if n == "" {
+				n = h.Account
+			}
+			s := fmt.Sprintf("%s:%s", n, h.Subject)
+			if h.Via != "" {
+				s = fmt.Sprintf("(%s) %s", h.Via, s)
+			}
+			hops = append(hops, s)
+		}
+		buf.WriteString(fmt.Sprintf("  route: %s\n", strings.Join(hops, " -> ")))
+	}
+	return buf.String()
+}
+
+func (d *PermissionDecision) explain(format string, args ...any) {
+	d.Reasons = append(d.Reasons, fmt.Sprintf(format, args...))
+}
+
+func (d *PermissionDecision) deny(format string, args ...any) *PermissionDecision {
+	d.Allowed = false
+	d.explain(format, args...)
+	return d
+}
+
+// CheckPermission evaluates whether the server would allow the user to perform
+// the action on the subject, reproducing the server's checks for revocations,
+// expiration, connection types, sources and times, and subject permissions. For
+// scoped users the permissions are resolved from the scope of the signing key.
+// When allowed, imports and mappings are followed to find the final accounts
+// and subjects.
+func CheckPermission(u User, action Action, subject string, ctx *ConnectionContext) (*PermissionDecision, error) {
+	ud, ok := u.(*UserData)
+	if !ok || ud == nil || ud.Claim == nil {
+		return nil, errors.New("invalid user")
+	}
+	if action != Publish && action != Subscribe {
+		return nil, fmt.Errorf("invalid action %q", action)
+	}
+	if ctx == nil {
+		ctx = &ConnectionContext{}
+	}
+	now := ctx.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	d := &PermissionDecision{Action: action, Subject: subject, Allowed: true}
+	if !isValidSubject(subject) {
+		return d.deny("%q is not a valid subject", subject), nil
+	}
+	if action == Publish && !isLiteralSubject(subject) {
+		return d.deny("cannot publish to wildcard subject %q", subject), nil
+	}
+
+	uc := ud.Claim
+	ad := ud.AccountData
+	if ad != nil && ad.Claim != nil {
+		if ad.Claim.IsClaimRevoked(uc) {
+			return d.deny("user %s is revoked by account %s", uc.Subject, ad.Claim.Name), nil
+		}
+		if ad.Claim.Expires > 0 && time.Unix(ad.Claim.Expires, 0).Before(now) {
+			return d.deny("account %s expired on %s", ad.Claim.Name, time.Unix(ad.Claim.Expires, 0).UTC()), nil
+		}
+	}
+	if uc.Expires > 0 && time.Unix(uc.Expires, 0).Before(now) {
+		return d.deny("user expired on %s", time.Unix(uc.Expires, 0).UTC()), nil
+	}
+
+	ep, err := ud.EffectivePermissions()
+	if err != nil {
+		return d.deny("the server will reject the user: %v", err), nil
+	}
+	limits := ep.Limits
+	if ep.Scoped {
+		d.explain("permissions resolved from scope %s (role %q)", ep.ScopeKey, ep.Role)
+	}
+
+	if reason := checkConnectionType(limits, ctx.ConnectionType); reason != "" {
+		return d.deny("%s", reason), nil
+	}
+	if reason := checkSource(limits, ctx.Source); reason != "" {
+		return d.deny("%s", reason), nil
+	}
+	if reason := checkTimes(limits, now); reason != "" {
+		return d.deny("%s", reason), nil
+	}
+
+	if action == Publish {
+		checkPublish(d, limits.Permissions)
+	} else {
+		checkSubscribe(d, limits.Permissions, ctx.Queue)
+	}
+	if d.Allowed && ad != nil {
+		if action == Publish {
+			d.Routes = publishRoutes(d, ad, subject)
+		} else {
+			d.Routes = subscribeRoutes(d, ad, subject)
+		}
+	}
+	return d, nil
+}
+
+// CheckCredsPermission is like CheckPermission but evaluates the user in the
+// specified creds. The account issuing the user is located in the operator.
+func CheckCredsPermission(o Operator, creds []byte, action Action, subject string, ctx *ConnectionContext) (*PermissionDecision, error) {
+	od, ok := o.(*OperatorData)
+	if !ok || od == nil {
+		return nil, errors.New("invalid operator")
+	}
+	token, err := jwt.ParseDecoratedJWT(creds)
+	if err != nil {
+		return nil, err
+	}
+	uc, err := jwt.DecodeUserClaims(token)
+	if err != nil {
+		return nil, err
+	}
+	account := uc.IssuerAccount
+	if account == "" {
+		account = uc.Issuer
+	}
+	a, err := od.Get(account)
+	if err != nil {
+		return nil, fmt.Errorf("account %s: %w", account, err)
+	}
+	ud := &UserData{
+		BaseData:    BaseData{EntityName: uc.Name, Token: token, Loaded: uc.IssuedAt, readOnly: true},
+		AccountData: a.(*AccountData),
+		Claim:       uc,
+	}
+	return CheckPermission(ud, action, subject, ctx)
+}
+
+// userScope returns the scope of the signing key that issued the user, or nil
+// if the user is not scoped
+func (u *UserData) userScope() *jwt.UserScope {
+	if u.AccountData != nil && u.AccountData.Claim != nil {
+		s, ok := u.AccountData.Claim.SigningKeys.GetScope(u.Claim.Issuer)
+		if ok && s != nil {
+			if us, ok := s.(*jwt.UserScope); ok {
+				return us
+			}
+		}
+	}
+	return nil
+}
+
+func checkConnectionType(limits jwt.UserPermissionLimits, kind string) string {
+	if len(limits.AllowedConnectionTypes) == 0 {
+		return ""
+	}
+	if kind == "" {
+		kind = jwt.ConnectionTypeStandard
+	}
+	for _, ct := range limits.AllowedConnectionTypes {
+		if strings.EqualFold(ct, kind) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("connection type %q is not in allowed connection types %v", kind, limits.AllowedConnectionTypes)
+}
+
+func checkSource(limits jwt.UserPermissionLimits, source string) string {
+	if len(limits.Src) == 0 {
+		return ""
+	}
+	if source == "" {
+		return fmt.Sprintf("connection source is required by %v", limits.Src)
+	}
+	ip := net.ParseIP(source)
+	if ip == nil {
+		return fmt.Sprintf("connection source %q is not an IP address", source)
+	}
+	for _, cidr := range limits.Src {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Sprintf("invalid connection source %q", cidr)
+		}
+		if n.Contains(ip) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("connection source %s is not in %v", source, limits.Src)
+}
+
+func checkTimes(limits jwt.UserPermissionLimits, now time.Time) string {
+	if len(limits.Times) == 0 {
+		return ""
+	}
+	loc := time.Local
+	if limits.Locale != "" {
+		var err error
+		if loc, err = time.LoadLocation(limits.Locale); err != nil {
+			return fmt.Sprintf("invalid locale %q", limits.Locale)
+		}
+	}
+	now = now.In(loc)
+	for _, tr := range limits.Times {
+		y, m, d := now.Date()
+		m = m - 1
+		d = d - 1
+		start, err := time.ParseInLocation("15:04:05", tr.Start, loc)
+		if err != nil {
+			return fmt.Sprintf("invalid connection time start %q", tr.Start)
+		}
+		end, err := time.ParseInLocation("15:04:05", tr.End, loc)
+		if err != nil {
+			return fmt.Sprintf("invalid connection time end %q", tr.End)
+		}
+		if start.After(end) {
+			start = start.AddDate(y, int(m), d)
+			d++
+		} else {
+			start = start.AddDate(y, int(m), d)
+		}
+		if start.Before(now) {
+			end = end.AddDate(y, int(m), d)
+			if end.After(now) {
+				return ""
+			}
+		}
+	}
+	return fmt.Sprintf("%s is outside the allowed connection times", now.Format(time.RFC3339))
+}
+
+// splitQueue splits a subscribe permission into its subject and queue
+func splitQueue(p string) (string, string) {
+	fields := strings.Fields(p)
+	switch len(fields) {
+	case 0:
+		return "", ""
+	case 1:
+		return fields[0], ""
+	default:
+		return fields[0], fields[1]
+	}
+}
+
+func checkPublish(d *PermissionDecision, perms jwt.Permissions) {
+	if len(perms.Pub.Allow) > 0 {
+		match := ""
+		for _, p := range perms.Pub.Allow {
+			if subjectMatches(p, d.Subject) {
+				match = p
+				break
+			}
+		}
+		if match == "" {
+			d.deny("%q doesn't match any publish allow %v", d.Subject, []string(perms.Pub.Allow))
+		} else {
+			d.explain("%q is allowed by publish allow %q", d.Subject, match)
+		}
+	} else {
+		d.explain("no publish allow list, all subjects are allowed")
+	}
+	if d.Allowed {
+		for _, p := range perms.Pub.Deny {
+			if subjectMatches(p, d.Subject) {
+				d.deny("%q is denied by publish deny %q", d.Subject, p)
+				break
+			}
+		}
+	}
+	if !d.Allowed && perms.Resp != nil {
+		d.ResponseOnly = true
+		d.explain("response permissions allow replying up to %d message(s) within %s to requests the user receives",
+			perms.Resp.MaxMsgs, perms.Resp.Expires)
+	}
+}
+
+func checkSubscribe(d *PermissionDecision, perms jwt.Permissions, queue string) {
+	subject := d.Subject
+	if len(perms.Sub.Allow) > 0 {
+		var match string
+		for _, p := range perms.Sub.Allow {
+			s, q := splitQueue(p)
+			if !subjectMatches(s, subject) {
+				continue
+			}
+			if q == "" || (queue != "" && subjectMatches(q, queue)) {
+				match = p
+				break
+			}
+		}
+		if match == "" {
+			d.deny("%q doesn't match any subscribe allow %v", subject, []string(perms.Sub.Allow))
+		} else {
+			d.explain("%q is allowed by subscribe allow %q", subject, match)
+		}
+	} else {
+		d.explain("no subscribe allow list, all subjects are allowed")
+	}
+	if !d.Allowed {
+		return
+	}
+	for _, p := range perms.Sub.Deny {
+		s, q := splitQueue(p)
+		if !subjectMatches(s, subject) {
+			continue
+		}
+		if q == "" {
+			d.deny("%q is denied by subscribe deny %q", subject, p)
+			return
+		}
+		if queue != "" && subjectMatches(q, queue) {
+			d.deny("queue %q on %q is denied by subscribe deny %q", queue, subject, p)
+			return
+		}
+	}
+	if !isLiteralSubject(subject) {
+		for _, p := range perms.Sub.Deny {
+			s, _ := splitQueue(p)
+			if subjectIsSubset(s, subject) {
+				d.explain("messages on %q will be filtered from the subscription", s)
+			}
+		}
+	}
+}
+
+func accountName(a *AccountData) string {
+	if a == nil || a.Claim == nil {
+		return ""
+	}
+	return a.Claim.Name
+}
+
+// applyMappings returns the subjects a published subject is mapped to
+func applyMappings(d *PermissionDecision, a *AccountData, subject string) []string {
+	source := mappingSource(a.Claim.Mappings, subject)
+	if source == "" {
+		return []string{subject}
+	}
+	destinations := a.Claim.Mappings[jwt.Subject(source)]
+	var buf []string
+	total := uint8(0)
+	for _, m := range destinations {
+		dest, err := transformSubject(source, string(m.Subject), subject)
+		if err != nil {
+			d.explain("mapping %q -> %q: %v", source, m.Subject, err)
+			continue
+		}
+		w := m.GetWeight()
+		if m.Cluster != "" {
+			d.explain("mapping %q -> %q applies to cluster %q with weight %d%%", source, dest, m.Cluster, w)
+		} else {
+			total += w
+			d.explain("mapping %q -> %q with weight %d%%", source, dest, w)
+		}
+		buf = append(buf, dest)
+	}
+	if total < 100 {
+		buf = append(buf, subject)
+	}
+	return buf
+}
+
+// mappingSource returns the source of the mapping that applies to the subject,
+// or an empty string if none matches. An exact match wins, otherwise the wildcard
+// match with the most literal tokens, then one without a full wildcard, then the
+// first in lexical order. The JWT doesn't order the mappings, so when several
+// match the server may pick a different one.
+func mappingSource(mappings jwt.Mapping, subject string) string {
+	var sources []string
+	for k := range mappings {
+		sources = append(sources, string(k))
+	}
+	sort.Strings(sources)
+	best, literals, full := "", -1, false
+	for _, src := range sources {
+		if src == subject {
+			return src
+		}
+		if !subjectMatches(src, subject) {
+			continue
+		}
+		l, f := 0, false
+		for _, t := range strings.Split(src, tsep) {
+			switch t {
+			case pwc:
+			case fwc:
+				f = true
+			default:
+				l++
+			}
+		}
+		if l > literals || (l == literals && full && !f) {
+			best, literals, full = src, l, f
+		}
+	}
+	return best
+}
+
+// transformSubject maps a subject matching the source pattern into the
+// destination, resolving wildcard references
+func transformSubject(source string, destination string, subject string) (string, error) {
+	values := wildcardValues(source, subject)
+	tokens := strings.Split(destination, tsep)
+	for i, t := range tokens {
+		if n, ok := wildcardReference(t); ok {
+			if n > len(values) {
+				return "", fmt.Errorf("wildcard %d is not in %q", n, source)
+			}
+			tokens[i] = values[n-1]
+		} else if strings.HasPrefix(t, "{{") {
+			return "", fmt.Errorf("mapping function %s is not supported by the simulator", t)
+		}
+	}
+	// a trailing full wildcard carries over what the full wildcard of the source matched
+	st := strings.Split(source, tsep)
+	if tokens[len(tokens)-1] == fwc && st[len(st)-1] == fwc {
+		rest := strings.Split(subject, tsep)[len(st)-1:]
+		tokens = append(tokens[:len(tokens)-1], rest...)
+	}
+	return strings.Join(tokens, tsep), nil
+}
+
+// importSubject translates a subject in the importing account to the
+// subject in the exporting account
+func importSubject(in *jwt.Import, subject string) (string, bool) {
+	local := string(in.LocalSubject)
+	if local == "" {
+		if subjectMatches(string(in.Subject), subject) {
+			return subject, true
+		}
+		return "", false
+	}
+	lt := strings.Split(local, tsep)
+	refs := make(map[int]int)
+	for i, t := range lt {
+		if n, ok := wildcardReference(t); ok {
+			refs[n] = i
+			lt[i] = pwc
+		}
+	}
+	if !subjectMatches(strings.Join(lt, tsep), subject) {
+		return "", false
+	}
+	st := strings.Split(subject, tsep)
+	rt := strings.Split(string(in.Subject), tsep)
+	n := 0
+	for i, t := range rt {
+		if t == pwc {
+			n++
+			if idx, ok := refs[n]; ok && idx < len(st) {
+				rt[i] = st[idx]
+			}
+		}
+	}
+	return strings.Join(rt, tsep), true
+}
+
+func findExport(a *AccountData, subject string, kind jwt.ExportType) *jwt.Export {
+	for _, e := range a.Claim.Exports {
+		if e.Type == kind && subjectIsSubset(subject, string(e.Subject)) {
+			return e
+		}
+	}
+	return nil
+}
+
+func publishRoutes(d *PermissionDecision, a *AccountData, subject string) [][]PermissionHop {
+	start := PermissionHop{Account: a.Subject(), AccountName: accountName(a), Subject: subject}
+	var routes [][]PermissionHop
+	for _, s := range applyMappings(d, a, subject) {
+		route := []PermissionHop{start}
+		if s != subject {
+			route = append(route, PermissionHop{Account: a.Subject(), AccountName: accountName(a), Subject: s, Via: "mapping"})
+		}
+		routes = append(routes, followServiceImports(d, a, route, s, 0))
+	}
+	return routes
+}
+
+// importedAccount returns the account an import references, or nil if the
+// account is not managed by the same operator
+func importedAccount(a *AccountData, in *jwt.Import) *AccountData {
+	if a.Operator == nil {
+		return nil
+	}
+	return a.Operator.accountData(in.Account)
+}
+
+func followServiceImports(d *PermissionDecision, a *AccountData, route []PermissionHop, subject string, depth int) []PermissionHop {
+	if depth >= maxRouteDepth {
+		d.explain("stopped following service imports after %d hops", depth)
+		return route
+	}
+	for _, in := range a.Claim.Imports {
+		if !in.IsService() {
+			continue
+		}
+		remote, ok := importSubject(in, subject)
+		if !ok {
+			continue
+		}
+		exporter := importedAccount(a, in)
+		hop := PermissionHop{Account: in.Account, Subject: remote, Via: fmt.Sprintf("service import %q", in.Name)}
+		if exporter == nil {
+			d.explain("service import %q targets account %s which is not in the store", in.Name, in.Account)
+			return append(route, hop)
+		}
+		hop.AccountName = accountName(exporter)
+		e := findExport(exporter, remote, jwt.Service)
+		if e == nil {
+			d.explain("account %s doesn't export a service matching %q", hop.AccountName, remote)
+		} else if e.TokenReq && in.Token == "" {
+			d.explain("service export %q requires an activation token that import %q doesn't have", e.Name, in.Name)
+		}
+		return followServiceImports(d, exporter, append(route, hop), remote, depth+1)
+	}
+	return route
+}
+
+func subscribeRoutes(d *PermissionDecision, a *AccountData, subject string) [][]PermissionHop {
+	start := PermissionHop{Account: a.Subject(), AccountName: accountName(a), Subject: subject}
+	routes := [][]PermissionHop{{start}}
+	for _, in := range a.Claim.Imports {
+		if !in.IsStream() {
+			continue
+		}
+		local := string(in.LocalSubject)
+		if local == "" {
+			local = string(in.Subject)
+		}
+		lt := strings.Split(local, tsep)
+		for i, t := range lt {
+			if _, ok := wildcardReference(t); ok {
+				lt[i] = pwc
+			}
+		}
+		if !subjectsCollide(strings.Join(lt, tsep), subject) {
+			continue
+		}
+		hop := PermissionHop{Account: in.Account, Subject: string(in.Subject), Via: fmt.Sprintf("stream import %q", in.Name)}
+		exporter := importedAccount(a, in)
+		if exporter == nil {
+			d.explain("stream import %q sources account %s which is not in the store", in.Name, in.Account)
+		} else {
+			hop.AccountName = accountName(exporter)
+			e := findExport(exporter, string(in.Subject), jwt.Stream)
+			if e == nil {
+				d.explain("account %s doesn't export a stream matching %q", hop.AccountName, in.Subject)
+			} else if e.TokenReq && in.Token == "" {
+				d.explain("stream export %q requires an activation token that import %q doesn't have", e.Name, in.Name)
+			}
+		}
+		routes = append(routes, []PermissionHop{hop, start})
+	}
+	return routes
+}
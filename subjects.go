@@ -0,0 +1,143 @@
+package authb
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	pwc  = "*"
+	fwc  = ">"
+	tsep = "."
+)
+
+// isValidSubject returns true if the subject is well-formed, that is
+// it has no empty tokens and the full wildcard only appears as the last token.
+func isValidSubject(subject string) bool {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return false
+	}
+	tokens := strings.Split(subject, tsep)
+	for i, t := range tokens {
+		if t == "" {
+			return false
+		}
+		if t == fwc && i != len(tokens)-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// isLiteralSubject returns true if the subject doesn't have wildcards
+func isLiteralSubject(subject string) bool {
+	for _, t := range strings.Split(subject, tsep) {
+		if t == pwc || t == fwc {
+			return false
+		}
+	}
+	return true
+}
+
+// subjectMatches returns true if the subject matches the pattern using
+// the same rules the nats-server uses for its sublist. Wildcard tokens
+// in the subject are treated as literals, so "a.*" matches the pattern
+// "a.*" or "a.>", but not "a.b".
+func subjectMatches(pattern string, subject string) bool {
+	pt := strings.Split(pattern, tsep)
+	st := strings.Split(subject, tsep)
+	for i, p := range pt {
+		if p == fwc {
+			return len(st) > i
+		}
+		if i >= len(st) {
+			return false
+		}
+		if p != pwc && p != st[i] {
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
+
+// subjectIsSubset returns true if all the subjects matched by subject
+// are also matched by the pattern
+func subjectIsSubset(subject string, pattern string) bool {
+	st := strings.Split(subject, tsep)
+	pt := strings.Split(pattern, tsep)
+	for i, p := range pt {
+		if p == fwc {
+			return len(st) > i
+		}
+		if i >= len(st) {
+			return false
+		}
+		s := st[i]
+		switch {
+		case s == fwc:
+			return false
+		case p == pwc:
+			continue
+		case s == pwc || s != p:
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
+
+// subjectsCollide returns true if there's at least one literal subject
+// that is matched by both subjects
+func subjectsCollide(a string, b string) bool {
+	at := strings.Split(a, tsep)
+	bt := strings.Split(b, tsep)
+	for i := 0; i < len(at) && i < len(bt); i++ {
+		x, y := at[i], bt[i]
+		if x == fwc || y == fwc {
+			return true
+		}
+		if x != pwc && y != pwc && x != y {
+			return false
+		}
+	}
+	return len(at) == len(bt)
+}
+
+// wildcardValues returns the tokens of the subject that matched wildcards
+// in the pattern. The full wildcard contributes the remaining tokens joined.
+func wildcardValues(pattern string, subject string) []string {
+	var buf []string
+	pt := strings.Split(pattern, tsep)
+	st := strings.Split(subject, tsep)
+	for i, p := range pt {
+		if i >= len(st) {
+			break
+		}
+		if p == pwc {
+			buf = append(buf, st[i])
+		} else if p == fwc {
+			buf = append(buf, strings.Join(st[i:], tsep))
+			break
+		}
+	}
+	return buf
+}
+
+// wildcardReference parses "$n" and "{{wildcard(n)}}" tokens returning
+// the referenced wildcard position
+func wildcardReference(token string) (int, bool) {
+	var v string
+	if strings.HasPrefix(token, "$") {
+		v = token[1:]
+	} else {
+		op := strings.ReplaceAll(strings.ToLower(token), " ", "")
+		if !strings.HasPrefix(op, "{{wildcard(") || !strings.HasSuffix(op, ")}}") {
+			return 0, false
+		}
+		v = strings.TrimSuffix(strings.TrimPrefix(op, "{{wildcard("), ")}}")
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,284 @@
+package authb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// ErrInvalidTemplate is returned when a permission template is malformed or
+// cannot be expanded for a user
+var ErrInvalidTemplate = errors.New("invalid permission template")
+
+// templateFunctions are the template functions without arguments supported by the server
+var templateFunctions = map[string]bool{
+	"name()":            true,
+	"subject()":         true,
+	"account-name()":    true,
+	"account-subject()": true,
+}
+
+// parseTagTemplate returns the tag name and whether the tag is an account tag
+// for the tag(name) and account-tag(name) template functions
+func parseTagTemplate(op string) (string, bool, bool) {
+	if !strings.HasSuffix(op, ")") {
+		return "", false, false
+	}
+	if strings.HasPrefix(op, "account-tag(") {
+		return strings.TrimSuffix(strings.TrimPrefix(op, "account-tag("), ")"), true, true
+	}
+	if strings.HasPrefix(op, "tag(") {
+		return strings.TrimSuffix(strings.TrimPrefix(op, "tag("), ")"), false, true
+	}
+	return "", false, false
+}
+
+// validateTemplateSyntax checks that all templates in the subject are
+// well-formed and reference functions supported by the server
+func validateTemplateSyntax(subject string) error {
+	for _, tk := range strings.Split(subject, tsep) {
+		open := strings.Contains(tk, "{{")
+		closed := strings.Contains(tk, "}}")
+		if !open && !closed {
+			continue
+		}
+		if !isTemplateToken(tk) || strings.Count(tk, "{{") != 1 || strings.Count(tk, "}}") != 1 {
+			return fmt.Errorf("%w: %q in %q must be a complete subject token", ErrInvalidTemplate, tk, subject)
+		}
+		op := strings.ToLower(strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(tk, "{{"), "}}")))
+		if templateFunctions[op] {
+			continue
+		}
+		if strings.Count(op, "(") != 1 || strings.Count(op, ")") != 1 || !strings.HasSuffix(op, ")") {
+			return fmt.Errorf("%w: %q in %q has unbalanced parentheses", ErrInvalidTemplate, tk, subject)
+		}
+		name, _, ok := parseTagTemplate(op)
+		if !ok {
+			return fmt.Errorf("%w: %q in %q is not a supported function", ErrInvalidTemplate, tk, subject)
+		}
+		if name == "" {
+			return fmt.Errorf("%w: %q in %q doesn't specify a tag name", ErrInvalidTemplate, tk, subject)
+		}
+		if strings.ContainsAny(name, " \t:{}()") {
+			return fmt.Errorf("%w: %q in %q references a tag name that cannot be carried by a tag", ErrInvalidTemplate, tk, subject)
+		}
+	}
+	return nil
+}
+
+// validateTemplates checks the syntax of all the templates in the limits. If the
+// account is provided, references to account tags are checked as well.
+func validateTemplates(lim *jwt.UserPermissionLimits, ac *jwt.AccountClaims) error {
+	var errs []error
+	lists := [][]string{lim.Pub.Allow, lim.Pub.Deny, lim.Sub.Allow, lim.Sub.Deny}
+	for _, list := range lists {
+		for _, subject := range list {
+			if err := validateTemplateSyntax(subject); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if ac == nil {
+				continue
+			}
+			for _, tk := range strings.Split(subject, tsep) {
+				if !isTemplateToken(tk) {
+					continue
+				}
+				op := strings.ToLower(strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(tk, "{{"), "}}")))
+				name, account, ok := parseTagTemplate(op)
+				if ok && account && len(tagValues(ac.Tags, name)) == 0 {
+					errs = append(errs, fmt.Errorf("%w: %q in %q references tag %q that account %q doesn't have",
+						ErrInvalidTemplate, tk, subject, name, ac.Name))
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// templateTags returns the names of the user tags referenced by tag(name)
+// templates in the limits
+func templateTags(lim jwt.UserPermissionLimits) []string {
+	var buf []string
+	seen := make(map[string]bool)
+	lists := [][]string{lim.Pub.Allow, lim.Pub.Deny, lim.Sub.Allow, lim.Sub.Deny}
+	for _, list := range lists {
+		for _, subject := range list {
+			for _, tk := range strings.Split(subject, tsep) {
+				if !isTemplateToken(tk) {
+					continue
+				}
+				op := strings.ToLower(strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(tk, "{{"), "}}")))
+				name, account, ok := parseTagTemplate(op)
+				if ok && !account && name != "" && !seen[name] {
+					seen[name] = true
+					buf = append(buf, name)
+				}
+			}
+		}
+	}
+	return buf
+}
+
+// isTemplateToken returns true if the subject token is a permission template
+func isTemplateToken(token string) bool {
+	return strings.HasPrefix(token, "{{") && strings.HasSuffix(token, "}}")
+}
+
+// hasTemplates returns true if any of the subjects contain a template token
+func hasTemplates(subjects []string) bool {
+	for _, s := range subjects {
+		for _, t := range strings.Split(s, tsep) {
+			if isTemplateToken(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cartesianProduct returns all the combinations of picking one value from each list
+func cartesianProduct(lists [][]string) [][]string {
+	buf := [][]string{{}}
+	for _, l := range lists {
+		var next [][]string
+		for _, prefix := range buf {
+			for _, v := range l {
+				c := make([]string, len(prefix), len(prefix)+1)
+				copy(c, prefix)
+				next = append(next, append(c, v))
+			}
+		}
+		buf = next
+	}
+	return buf
+}
+
+// tagValues returns the values of the tags in the form "name:value"
+func tagValues(tags jwt.TagList, name string) []string {
+	var buf []string
+	prefix := fmt.Sprintf("%s:", strings.ToLower(name))
+	for _, t := range tags {
+		if strings.HasPrefix(t, prefix) {
+			buf = append(buf, strings.TrimPrefix(t, prefix))
+		}
+	}
+	return buf
+}
+
+// expandTemplate expands the templates in the list the same way the
+// nats-server does when a scoped user connects. Expansions that produce
+// invalid subjects are skipped unless failOnBadSubject is set.
+func expandTemplate(list jwt.StringList, uc *jwt.UserClaims, ac *jwt.AccountClaims, expanded map[string][]string, failOnBadSubject bool) (jwt.StringList, error) {
+	if !hasTemplates(list) {
+		return append(jwt.StringList(nil), list...), nil
+	}
+	emitted := make(jwt.StringList, 0, len(list))
+	for _, s := range list {
+		tokens := strings.Split(s, tsep)
+		var values [][]string
+		placeholders := make([]bool, len(tokens))
+		for i, tk := range tokens {
+			if !isTemplateToken(tk) {
+				continue
+			}
+			op := strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(tk, "{{"), "}}"))
+			switch {
+			case op == "name()":
+				tokens[i] = uc.Name
+			case op == "subject()":
+				tokens[i] = uc.Subject
+			case op == "account-name()":
+				tokens[i] = ""
+				if ac != nil {
+					tokens[i] = ac.Name
+				}
+			case op == "account-subject()":
+				tokens[i] = uc.IssuerAccount
+			case strings.HasPrefix(op, "account-tag(") && strings.HasSuffix(op, ")"):
+				tokens[i] = ""
+				if ac != nil {
+					name := strings.TrimSuffix(strings.TrimPrefix(op, "account-tag("), ")")
+					if v := tagValues(ac.Tags, name); len(v) > 0 {
+						values = append(values, v)
+						placeholders[i] = true
+					}
+				}
+			case strings.HasPrefix(op, "tag(") && strings.HasSuffix(op, ")"):
+				tokens[i] = ""
+				name := strings.TrimSuffix(strings.TrimPrefix(op, "tag("), ")")
+				if v := tagValues(uc.Tags, name); len(v) > 0 {
+					values = append(values, v)
+					placeholders[i] = true
+				}
+			default:
+				// unknown functions generate an invalid subject on purpose
+				tokens[i] = " "
+			}
+		}
+
+		var candidates []string
+		if len(values) == 0 {
+			candidates = append(candidates, strings.Join(tokens, tsep))
+		} else {
+			for _, combination := range cartesianProduct(values) {
+				c := make([]string, len(tokens))
+				copy(c, tokens)
+				idx := 0
+				for i := range c {
+					if placeholders[i] {
+						c[i] = combination[idx]
+						idx++
+					}
+				}
+				candidates = append(candidates, strings.Join(c, tsep))
+			}
+		}
+		for _, c := range candidates {
+			if isValidSubject(c) {
+				emitted = append(emitted, c)
+				if expanded != nil && c != s {
+					expanded[s] = append(expanded[s], c)
+				}
+			} else if failOnBadSubject {
+				return nil, fmt.Errorf("%w: %q generated an invalid subject", ErrInvalidTemplate, s)
+			} else if expanded != nil {
+				if _, ok := expanded[s]; !ok {
+					expanded[s] = nil
+				}
+			}
+		}
+	}
+	return emitted, nil
+}
+
+// expandPermissionTemplates applies the templates in the limits for the
+// specified user and account. If pub or sub allow lists become empty
+// after processing, a deny of ">" is added, same as the server.
+func expandPermissionTemplates(lim jwt.UserPermissionLimits, uc *jwt.UserClaims, ac *jwt.AccountClaims, expanded map[string][]string) (jwt.UserPermissionLimits, error) {
+	subAllow := len(lim.Sub.Allow) > 0
+	pubAllow := len(lim.Pub.Allow) > 0
+
+	var err error
+	if lim.Sub.Allow, err = expandTemplate(lim.Sub.Allow, uc, ac, expanded, false); err != nil {
+		return jwt.UserPermissionLimits{}, err
+	}
+	if lim.Sub.Deny, err = expandTemplate(lim.Sub.Deny, uc, ac, expanded, true); err != nil {
+		return jwt.UserPermissionLimits{}, err
+	}
+	if lim.Pub.Allow, err = expandTemplate(lim.Pub.Allow, uc, ac, expanded, false); err != nil {
+		return jwt.UserPermissionLimits{}, err
+	}
+	if lim.Pub.Deny, err = expandTemplate(lim.Pub.Deny, uc, ac, expanded, true); err != nil {
+		return jwt.UserPermissionLimits{}, err
+	}
+	if subAllow && len(lim.Sub.Allow) == 0 {
+		lim.Sub.Deny.Add(">")
+	}
+	if pubAllow && len(lim.Pub.Allow) == 0 {
+		lim.Pub.Deny.Add(">")
+	}
+	return lim, nil
+}
@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"github.com/nats-io/jwt/v2"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+func tenantTemplate() *authb.AccountTemplate {
+	return &authb.AccountTemplate{
+		Name:    "tenant",
+		Version: 1,
+		Params:  map[string]string{"tier": "silver"},
+		Tags:    []string{"tier:${tier}", "tenant:${name}"},
+		Limits:  authb.TemplateLimits{MaxConnections: "${conns}"},
+		JetStream: []authb.TemplateJetStreamLimits{
+			{Tier: 0, MaxMemoryStorage: "${mem}", MaxDiskStorage: "1000000", MaxStreams: "10"},
+		},
+		Exports: []authb.TemplateExport{
+			{Name: "events", Subject: "${name}.events.>"},
+		},
+		Imports: []authb.TemplateImport{
+			{Name: "billing", Account: "SVC", Subject: "billing.>", LocalSubject: "billing.${name}.>", Service: true},
+			{Name: "announce", Account: "SVC", Subject: "announce.>"},
+		},
+		Roles: []authb.TemplateRole{
+			{Role: "app", PubAllow: []string{"${name}.>", "billing.${name}.>"}, SubAllow: []string{"_INBOX.>", "{{name()}}.>"}},
+		},
+		Mappings: map[string][]authb.Mapping{
+			"in.${name}": {{Subject: "${name}.in", Weight: 100}},
+		},
+	}
+}
+
+func (t *ProviderSuite) Test_AccountTemplate() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	svc, err := o.Accounts().Add("SVC")
+	t.NoError(err)
+	se, err := svc.Exports().Services().Add("billing", "billing.>")
+	t.NoError(err)
+	t.NoError(se.SetTokenRequired(true))
+	_, err = svc.Exports().Streams().Add("announce", "announce.>")
+	t.NoError(err)
+
+	tmpl := tenantTemplate()
+	_, err = o.Accounts().AddFromTemplate("T1", tmpl, map[string]string{"conns": "10"})
+	t.Error(err)
+	t.Contains(err.Error(), "mem")
+	_, err = o.Accounts().Get("T1")
+	t.ErrorIs(err, authb.ErrNotFound)
+
+	a, err := o.Accounts().AddFromTemplate("T1", tmpl, map[string]string{"conns": "10", "mem": "1000"})
+	t.NoError(err)
+	t.True(a.Tags().Contains("tier:silver"))
+	t.True(a.Tags().Contains("tenant:t1"))
+	t.Equal(int64(10), a.Limits().MaxConnections())
+	js, err := a.Limits().JetStream().Get(0)
+	t.NoError(err)
+	mem, err := js.MaxMemoryStorage()
+	t.NoError(err)
+	t.Equal(int64(1000), mem)
+	_, err = a.Exports().Streams().Get("T1.events.>")
+	t.NoError(err)
+	si, err := a.Imports().Services().Get("billing.>")
+	t.NoError(err)
+	t.Equal("billing.T1.>", si.LocalSubject())
+	ac, err := jwt.DecodeActivationClaims(si.Token())
+	t.NoError(err)
+	t.Equal(a.Subject(), ac.Subject)
+	sti, err := a.Imports().Streams().Get("announce.>")
+	t.NoError(err)
+	t.Empty(sti.Token())
+	scopes, err := a.ScopedSigningKeys().GetScopeByRole("app")
+	t.NoError(err)
+	t.Len(scopes, 1)
+	t.Equal([]string{"T1.>", "billing.T1.>"}, scopes[0].PubPermissions().Allow())
+	t.Equal([]string{"_INBOX.>", "{{name()}}.>"}, scopes[0].SubPermissions().Allow())
+	t.Equal([]authb.Mapping{{Subject: "T1.in", Weight: 100}}, a.SubjectMappings().Get("in.T1"))
+	ref := a.Template()
+	t.NotNil(ref)
+	t.Equal("tenant", ref.Name)
+	t.Equal(1, ref.Version)
+	t.Equal(map[string]string{"conns": "10", "mem": "1000"}, ref.Params)
+	t.True(authb.Verify(auth).OK())
+
+	_, err = o.Accounts().AddFromTemplate("T1", tmpl, map[string]string{"conns": "10", "mem": "1000"})
+	t.Error(err)
+	b, err := o.Accounts().AddFromTemplate("T2", tmpl, map[string]string{"tier": "gold", "conns": "20", "mem": "2000"})
+	t.NoError(err)
+	t.True(b.Tags().Contains("tier:gold"))
+	t.NoError(auth.Commit())
+
+	// the template version and parameters are stored with the account
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err = auth.Operators().Get("O")
+	t.NoError(err)
+
+	v2 := tenantTemplate()
+	v2.Version = 2
+	v2.Params["tier"] = "bronze"
+	v2.Limits.MaxSubscriptions = "100"
+	v2.Exports = nil
+	v2.Imports = v2.Imports[:1]
+	v2.Roles[0].SubAllow = []string{"_INBOX.>"}
+	updated, err := o.Accounts().ApplyTemplate(v2)
+	t.NoError(err)
+	t.Len(updated, 2)
+	a, err = o.Accounts().Get("T1")
+	t.NoError(err)
+	t.Equal(2, a.Template().Version)
+	t.True(a.Tags().Contains("tier:bronze"))
+	t.False(a.Tags().Contains("tier:silver"))
+	t.Equal(int64(100), a.Limits().MaxSubscriptions())
+	t.Equal(int64(10), a.Limits().MaxConnections())
+	t.Empty(a.Exports().Streams().List())
+	t.Len(a.Imports().Streams().List(), 0)
+	t.Len(a.Imports().Services().List(), 1)
+	scopes, err = a.ScopedSigningKeys().GetScopeByRole("app")
+	t.NoError(err)
+	t.Len(scopes, 1)
+	t.Equal([]string{"_INBOX.>"}, scopes[0].SubPermissions().Allow())
+	b, err = o.Accounts().Get("T2")
+	t.NoError(err)
+	t.True(b.Tags().Contains("tier:gold"))
+
+	// accounts already at the version are not updated
+	updated, err = o.Accounts().ApplyTemplate(v2)
+	t.NoError(err)
+	t.Empty(updated)
+	t.True(authb.Verify(auth).OK())
+	t.NoError(auth.Commit())
+}
+
+func (t *ProviderSuite) Test_AccountTemplateErrors() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+
+	_, err = o.Accounts().AddFromTemplate("A", nil, nil)
+	t.Error(err)
+	_, err = o.Accounts().AddFromTemplate("A", &authb.AccountTemplate{Name: "t"}, nil)
+	t.Error(err)
+
+	// errors applying the template don't leave the account behind
+	tmpl := &authb.AccountTemplate{Name: "t", Version: 1, Limits: authb.TemplateLimits{MaxConnections: "many"}}
+	_, err = o.Accounts().AddFromTemplate("A", tmpl, nil)
+	t.Error(err)
+	_, err = o.Accounts().Get("A")
+	t.ErrorIs(err, authb.ErrNotFound)
+	tmpl = &authb.AccountTemplate{Name: "t", Version: 1, Imports: []authb.TemplateImport{{Name: "x", Account: "X", Subject: "x"}}}
+	_, err = o.Accounts().AddFromTemplate("A", tmpl, nil)
+	t.Error(err)
+	t.Empty(o.Accounts().List())
+}
+
+func (t *ProviderSuite) Test_AccountTemplateExpansion() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+
+	// values are not expanded again, so they can't reference themselves
+	tmpl := &authb.AccountTemplate{
+		Name:    "t",
+		Version: 1,
+		Params:  map[string]string{"loop": "${loop}", "missing": "${undefined}"},
+		Tags:    []string{"loop:${loop}", "missing:${missing}"},
+	}
+	a, err := o.Accounts().AddFromTemplate("A", tmpl, map[string]string{"conns": "10"})
+	t.NoError(err)
+	tags, err := a.Tags().All()
+	t.NoError(err)
+	t.ElementsMatch([]string{"loop:${loop}", "missing:${undefined}"}, tags)
+	_, err = o.Accounts().AddFromTemplate("B", tmpl, map[string]string{"conns": "many"})
+	t.NoError(err)
+
+	// a template that can't be applied to an account doesn't modify any of them
+	tmpl.Version = 2
+	tmpl.Limits.MaxConnections = "${conns}"
+	tmpl.Tags = []string{"v2"}
+	_, err = o.Accounts().ApplyTemplate(tmpl)
+	t.Error(err)
+	for _, name := range []string{"A", "B"} {
+		a, err := o.Accounts().Get(name)
+		t.NoError(err)
+		t.Equal(1, a.Template().Version)
+		t.Equal(int64(-1), a.Limits().MaxConnections())
+		tags, err := a.Tags().All()
+		t.NoError(err)
+		t.NotContains(tags, "v2")
+	}
+}
+
+func (t *ProviderSuite) Test_AccountTemplateApplyRollback() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+
+	tmpl := &authb.AccountTemplate{Name: "t", Version: 1, Params: map[string]string{"src": "in"}}
+	_, err = o.Accounts().AddFromTemplate("A", tmpl, nil)
+	t.NoError(err)
+	_, err = o.Accounts().AddFromTemplate("B", tmpl, map[string]string{"src": "in..bad"})
+	t.NoError(err)
+	t.NoError(auth.Commit())
+
+	// the mapping fails on B after A was updated, so neither is modified
+	tmpl.Version = 2
+	tmpl.Tags = []string{"v2"}
+	tmpl.Roles = []authb.TemplateRole{{Role: "worker", PubAllow: []string{"work.>"}}}
+	tmpl.Mappings = map[string][]authb.Mapping{"${src}": {{Subject: "out", Weight: 100}}}
+	updated, err := o.Accounts().ApplyTemplate(tmpl)
+	t.Error(err)
+	t.Nil(updated)
+	for _, name := range []string{"A", "B"} {
+		a, err := o.Accounts().Get(name)
+		t.NoError(err)
+		t.Equal(1, a.Template().Version)
+		t.False(a.Tags().Contains("v2"))
+		t.Empty(a.ScopedSigningKeys().List())
+		t.Empty(a.SubjectMappings().List())
+	}
+	t.True(authb.Verify(auth).OK())
+	t.NoError(auth.Commit())
+}
@@ -0,0 +1,236 @@
+package tests
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/synadia-io/jwt-auth-builder.go/callout"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func authRequest(opts jwt.ConnectOptions) *jwt.AuthorizationRequestClaims {
+	req := jwt.NewAuthorizationRequestClaims("UA6KOMQ67XOE3FHE37W4OXADVXVYISBNLTBUT2LSY5VFKAIJ7CRDR2RZ")
+	req.ConnectOptions = opts
+	return req
+}
+
+func TestStaticAuthorizer(v *testing.T) {
+	t := assert.New(v)
+	hash, err := bcrypt.GenerateFromPassword([]byte("hashed"), bcrypt.MinCost)
+	require.NoError(v, err)
+
+	doc := `
+users:
+  alice:
+    password: secret
+    account: A
+    role: worker
+    tags: [team:red]
+    expiry: 1h
+  bob:
+    password: "` + string(hash) + `"
+    account: B
+  svc:
+    token: s3cr3t
+    role: service
+`
+	sa, err := callout.NewStaticAuthorizer(strings.NewReader(doc))
+	require.NoError(v, err)
+
+	auth, err := sa.Authorize(authRequest(jwt.ConnectOptions{Username: "alice", Password: "secret"}))
+	t.NoError(err)
+	t.Equal("A", auth.Account)
+	t.Equal("worker", auth.Role)
+	t.Equal("alice", auth.Name)
+	t.Equal([]string{"team:red"}, auth.Tags)
+	t.Equal(time.Hour, auth.Expiry)
+
+	auth, err = sa.Authorize(authRequest(jwt.ConnectOptions{Username: "bob", Password: "hashed"}))
+	t.NoError(err)
+	t.Equal("B", auth.Account)
+
+	auth, err = sa.Authorize(authRequest(jwt.ConnectOptions{Token: "s3cr3t"}))
+	t.NoError(err)
+	t.Equal("svc", auth.Name)
+
+	_, err = sa.Authorize(authRequest(jwt.ConnectOptions{Username: "alice", Password: "wrong"}))
+	t.ErrorIs(err, callout.ErrNotAuthorized)
+	_, err = sa.Authorize(authRequest(jwt.ConnectOptions{Username: "svc", Password: "s3cr3t"}))
+	t.ErrorIs(err, callout.ErrNotAuthorized)
+	_, err = sa.Authorize(authRequest(jwt.ConnectOptions{}))
+	t.ErrorIs(err, callout.ErrNotAuthorized)
+
+	_, err = callout.NewStaticAuthorizer(strings.NewReader("users:\n  x:\n    account: A\n"))
+	t.Error(err)
+
+	// tokens and common names must identify a single user
+	_, err = callout.NewStaticAuthorizer(strings.NewReader("users:\n  x:\n    token: t\n  y:\n    token: t\n"))
+	t.ErrorContains(err, `users "x" and "y" have the same token`)
+	_, err = callout.NewStaticAuthorizer(strings.NewReader("users:\n  x:\n    common_name: c\n  y:\n    common_name: c\n"))
+	t.ErrorContains(err, `users "x" and "y" have the same common_name`)
+
+	// hashed tokens can't be compared, the first user in name order wins
+	doc = `
+users:
+  b:
+    token: "` + string(hash) + `"
+  a:
+    token: "` + string(hash) + `"
+`
+	sa, err = callout.NewStaticAuthorizer(strings.NewReader(doc))
+	require.NoError(v, err)
+	for i := 0; i < 5; i++ {
+		auth, err = sa.Authorize(authRequest(jwt.ConnectOptions{Token: "hashed"}))
+		t.NoError(err)
+		t.Equal("a", auth.Name)
+	}
+}
+
+func TestHtpasswdAuthorizer(v *testing.T) {
+	t := assert.New(v)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(v, err)
+	file := "# users\nalice:" + string(hash) + "\n"
+
+	ha, err := callout.NewHtpasswdAuthorizer(strings.NewReader(file),
+		callout.FixedAuthorization(callout.Authorization{Account: "A", Role: "worker"}))
+	require.NoError(v, err)
+
+	auth, err := ha.Authorize(authRequest(jwt.ConnectOptions{Username: "alice", Password: "secret"}))
+	t.NoError(err)
+	t.Equal("alice", auth.Name)
+	t.Equal("A", auth.Account)
+	t.Equal("worker", auth.Role)
+
+	_, err = ha.Authorize(authRequest(jwt.ConnectOptions{Username: "alice", Password: "wrong"}))
+	t.ErrorIs(err, callout.ErrNotAuthorized)
+	_, err = ha.Authorize(authRequest(jwt.ConnectOptions{Username: "bob", Password: "secret"}))
+	t.ErrorIs(err, callout.ErrNotAuthorized)
+
+	// only bcrypt is supported
+	_, err = callout.NewHtpasswdAuthorizer(strings.NewReader("alice:{SHA}abc\n"), callout.FixedAuthorization(callout.Authorization{}))
+	t.Error(err)
+}
+
+func b64(d []byte) string {
+	return base64.RawURLEncoding.EncodeToString(d)
+}
+
+func signToken(v *testing.T, alg string, kid string, key crypto.Signer, claims map[string]any) string {
+	hd, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(v, err)
+	cd, err := json.Marshal(claims)
+	require.NoError(v, err)
+	input := b64(hd) + "." + b64(cd)
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		h := sha256.Sum256([]byte(input))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h[:])
+	case *ecdsa.PrivateKey:
+		h := sha256.Sum256([]byte(input))
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, h[:])
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(input))
+	}
+	require.NoError(v, err)
+	return input + "." + b64(sig)
+}
+
+func TestJWKSAuthorizer(v *testing.T) {
+	t := assert.New(v)
+	rk, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(v, err)
+	ek, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(v, err)
+	epub, ed, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(v, err)
+
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "n": b64(rk.N.Bytes()), "e": b64(big.NewInt(int64(rk.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ek.X.FillBytes(make([]byte, 32))), "y": b64(ek.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(epub)},
+	}})
+	require.NoError(v, err)
+
+	now := time.Now()
+	ja, err := callout.NewJWKSAuthorizer(strings.NewReader(string(jwks)), callout.JWKSConfig{
+		Issuer:       "https://idp.example.com",
+		Audience:     "nats",
+		Account:      "A",
+		RoleClaim:    "nats_role",
+		Role:         "reader",
+		TagsClaim:    "groups",
+		MaxExpiry:    30 * time.Minute,
+		Now:          func() time.Time { return now },
+		AccountClaim: "nats_account",
+	})
+	require.NoError(v, err)
+
+	claims := map[string]any{
+		"iss":       "https://idp.example.com",
+		"aud":       []string{"nats", "other"},
+		"sub":       "alice",
+		"exp":       now.Add(time.Hour).Unix(),
+		"nats_role": "worker",
+		"groups":    []string{"team:red"},
+	}
+	for alg, key := range map[string]crypto.Signer{"RS256": rk, "ES256": ek, "EdDSA": ed} {
+		kid := map[string]string{"RS256": "rsa", "ES256": "ec", "EdDSA": "ed"}[alg]
+		token := signToken(v, alg, kid, key, claims)
+		auth, err := ja.Authorize(authRequest(jwt.ConnectOptions{Token: token}))
+		t.NoError(err, alg)
+		if err != nil {
+			continue
+		}
+		t.Equal("alice", auth.Name)
+		t.Equal("A", auth.Account)
+		t.Equal("worker", auth.Role)
+		t.Equal([]string{"team:red"}, auth.Tags)
+		t.Equal(30*time.Minute, auth.Expiry)
+	}
+
+	// the algorithm must match the key
+	token := signToken(v, "ES256", "rsa", ek, claims)
+	_, err = ja.Authorize(authRequest(jwt.ConnectOptions{Token: token}))
+	t.ErrorIs(err, callout.ErrNotAuthorized)
+
+	// a tampered token
+	token = signToken(v, "EdDSA", "ed", ed, claims)
+	parts := strings.Split(token, ".")
+	tampered, _ := json.Marshal(map[string]any{"sub": "mallory", "exp": now.Add(time.Hour).Unix()})
+	_, err = ja.Authorize(authRequest(jwt.ConnectOptions{Token: parts[0] + "." + b64(tampered) + "." + parts[2]}))
+	t.ErrorIs(err, callout.ErrNotAuthorized)
+
+	for k, value := range map[string]any{"exp": now.Add(-time.Minute).Unix(), "iss": "other", "aud": "other"} {
+		bad := make(map[string]any)
+		for ck, cv := range claims {
+			bad[ck] = cv
+		}
+		bad[k] = value
+		_, err = ja.Authorize(authRequest(jwt.ConnectOptions{Token: signToken(v, "EdDSA", "ed", ed, bad)}))
+		t.ErrorIs(err, callout.ErrNotAuthorized, k)
+	}
+
+	// claims can be omitted
+	delete(claims, "nats_role")
+	auth, err := ja.Authorize(authRequest(jwt.ConnectOptions{Token: signToken(v, "EdDSA", "ed", ed, claims)}))
+	t.NoError(err)
+	t.Equal("reader", auth.Role)
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/nats-io/nkeys"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+	"github.com/synadia-io/jwt-auth-builder.go/providers/nsc"
+)
+
+func (t *ProviderSuite) Test_BackupRestore() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, a := t.bundleOperatorIn(auth)
+	pk, err := a.XKey().Create()
+	t.NoError(err)
+	t.NoError(auth.Commit())
+
+	recipient, err := nkeys.CreateCurveKeys()
+	t.NoError(err)
+	rpk, err := recipient.PublicKey()
+	t.NoError(err)
+	rseed, err := recipient.Seed()
+	t.NoError(err)
+
+	var buf bytes.Buffer
+	t.NoError(authb.Backup(t.Provider, &buf, &authb.BackupOptions{Recipient: rpk}))
+	data := buf.Bytes()
+	t.NotContains(string(data), string(a.(*authb.AccountData).Key.Seed))
+
+	_, err = authb.VerifyBackup(bytes.NewReader(data), nil)
+	t.Error(err)
+	m, err := authb.VerifyBackup(bytes.NewReader(data), &authb.RestoreOptions{Key: string(rseed)})
+	t.NoError(err)
+	t.Equal([]string{o.Subject()}, m.Operators)
+
+	// restoring into the same store conflicts with the operator
+	_, err = authb.Restore(bytes.NewReader(data), t.Provider, &authb.RestoreOptions{Key: string(rseed)})
+	t.Error(err)
+
+	// restore into a different store
+	ts := NewNscStore(t.T())
+	target := nsc.NewNscProvider(ts.StoresDir(), ts.KeysDir())
+	_, err = authb.Restore(bytes.NewReader(data), target, &authb.RestoreOptions{Key: string(rseed)})
+	t.NoError(err)
+
+	restored, err := authb.NewAuth(target)
+	t.NoError(err)
+	ra := t.GetAccount(restored, "O", "A")
+	t.Equal(a.JWT(), ra.JWT())
+	t.Len(ra.Users().List(), 2)
+	t.Equal(pk, ra.XKey().Public())
+	_, err = ra.XKey().KeyPair()
+	t.NoError(err)
+	t.True(authb.Verify(restored).OK(), authb.Verify(restored).Err())
+	t.NoError(ra.Limits().SetMaxConnections(10))
+	_, err = ra.Users().Add("V", "")
+	t.NoError(err)
+	t.NoError(restored.Commit())
+}
+
+func (t *ProviderSuite) Test_BackupIntegrity() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	t.bundleOperatorIn(auth)
+	t.NoError(auth.Commit())
+
+	var buf bytes.Buffer
+	t.NoError(authb.Backup(t.Provider, &buf, &authb.BackupOptions{ExcludeSeeds: true}))
+	m, err := authb.VerifyBackup(bytes.NewReader(buf.Bytes()), nil)
+	t.NoError(err)
+	t.Len(m.Operators, 1)
+	for path := range m.Checksums {
+		t.False(strings.Contains(path, "/keys/"), path)
+	}
+
+	// replace the content of a JWT
+	var tampered bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+	tw := tar.NewWriter(&tampered)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		t.NoError(err)
+		content, err := io.ReadAll(tr)
+		t.NoError(err)
+		if strings.HasSuffix(h.Name, "operator.jwt") {
+			content = bytes.ToUpper(content)
+		}
+		h.Size = int64(len(content))
+		t.NoError(tw.WriteHeader(h))
+		_, err = tw.Write(content)
+		t.NoError(err)
+	}
+	t.NoError(tw.Close())
+	_, err = authb.VerifyBackup(&tampered, nil)
+	t.Error(err)
+	t.Contains(err.Error(), "checksum")
+
+	_, err = authb.VerifyBackup(strings.NewReader("not a backup"), nil)
+	t.Error(err)
+}
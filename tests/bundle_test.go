@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+// bundleOperator creates an operator that is not committed, so that its bundle
+// can be imported into the same store
+func (t *ProviderSuite) bundleOperator() (authb.Operator, authb.Account) {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	return t.bundleOperatorIn(auth)
+}
+
+// bundleOperatorIn creates an operator with scoped and unscoped users in auth
+func (t *ProviderSuite) bundleOperatorIn(auth authb.Auth) (authb.Operator, authb.Account) {
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	_, err = o.SigningKeys().Add()
+	t.NoError(err)
+	sys, err := o.Accounts().Add("SYS")
+	t.NoError(err)
+	t.NoError(o.SetSystemAccount(sys))
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	scope, err := a.ScopedSigningKeys().AddScope("app")
+	t.NoError(err)
+	t.NoError(scope.PubPermissions().SetAllow("app.>"))
+	_, err = a.Users().Add("scoped", scope.Key())
+	t.NoError(err)
+	_, err = a.Users().Add("U", "")
+	t.NoError(err)
+	return o, a
+}
+
+func (t *ProviderSuite) Test_BundleRoundTrip() {
+	o, a := t.bundleOperator()
+	recipient, err := nkeys.CreateCurveKeys()
+	t.NoError(err)
+	rpk, err := recipient.PublicKey()
+	t.NoError(err)
+	rseed, err := recipient.Seed()
+	t.NoError(err)
+
+	bundle, err := authb.ExportBundle(o, &authb.BundleOptions{Seeds: authb.BundleAllKeys, Recipient: rpk})
+	t.NoError(err)
+	t.NotContains(string(bundle), a.Subject())
+
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	_, err = auth.Operators().ImportBundle(bundle, "")
+	t.Error(err)
+	other, err := nkeys.CreateCurveKeys()
+	t.NoError(err)
+	oseed, err := other.Seed()
+	t.NoError(err)
+	_, err = auth.Operators().ImportBundle(bundle, string(oseed))
+	t.Error(err)
+
+	io, err := auth.Operators().ImportBundle(bundle, string(rseed))
+	t.NoError(err)
+	t.Equal(o.Subject(), io.Subject())
+	_, err = auth.Operators().ImportBundle(bundle, string(rseed))
+	t.Error(err)
+	t.NoError(auth.Commit())
+
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	ia := t.GetAccount(auth, "O", "A")
+	t.Equal(a.JWT(), ia.JWT())
+	t.Len(ia.Users().List(), 2)
+	scoped, err := ia.Users().Get("scoped")
+	t.NoError(err)
+	t.True(scoped.IsScoped())
+	sys, err := io.SystemAccount()
+	t.NoError(err)
+	t.Equal("SYS", sys.Name())
+	t.True(authb.Verify(auth).OK(), authb.Verify(auth).Err())
+
+	// everything can be edited with all the seeds
+	t.NoError(ia.Limits().SetMaxConnections(10))
+	_, err = ia.Users().Add("V", "")
+	t.NoError(err)
+	t.NoError(auth.Commit())
+}
+
+func (t *ProviderSuite) Test_BundlePartialSeeds() {
+	o, a := t.bundleOperator()
+	// ephemeral users are not stored, so they are not bundled either
+	ephemeral, err := a.Users().ImportEphemeral(jwt.NewUserClaims(t.UserKey().Public), "")
+	t.NoError(err)
+	bundle, err := authb.ExportBundle(o, &authb.BundleOptions{Seeds: authb.BundleAccountSigningKeys})
+	t.NoError(err)
+	t.NotContains(string(bundle), ephemeral.Subject())
+
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	_, err = auth.Operators().ImportBundle(bundle, "")
+	t.NoError(err)
+	t.NoError(auth.Commit())
+
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	ia := t.GetAccount(auth, "O", "A")
+	t.Equal(a.JWT(), ia.JWT())
+	// only the account signing key is available to issue users
+	t.Error(ia.Limits().SetMaxConnections(10))
+	_, err = ia.Users().Add("V", "")
+	t.Error(err)
+	u, _, err := ia.Users().AddWithRole("W", "app", nil)
+	t.NoError(err)
+	t.True(u.IsScoped())
+	t.NoError(auth.Commit())
+
+	r := authb.Verify(auth)
+	for _, issue := range r.Issues {
+		t.Contains(issue.Problem, "seed is missing")
+	}
+}
+
+func (t *ProviderSuite) Test_BundleTampered() {
+	o, _ := t.bundleOperator()
+	bundle, err := authb.ExportBundle(o, &authb.BundleOptions{Seeds: authb.BundleUserKeys})
+	t.NoError(err)
+
+	var doc map[string]any
+	t.NoError(json.Unmarshal(bundle, &doc))
+	files := doc["files"].(map[string]any)
+	for path := range files {
+		if strings.HasPrefix(path, "keys/") {
+			files[path] = "SUAAVVTZYSKB4OGLSNXSLQVVZXTCAHBJGNGHSQKGHWRKQTFFBDZ4GGXG7A"
+		}
+	}
+	tampered, err := json.Marshal(doc)
+	t.NoError(err)
+
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	_, err = auth.Operators().ImportBundle(tampered, "")
+	t.Error(err)
+	t.Contains(err.Error(), "checksum")
+	_, err = auth.Operators().ImportBundle([]byte("{}"), "")
+	t.Error(err)
+	t.Empty(auth.Operators().List())
+}
@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+	"github.com/synadia-io/jwt-auth-builder.go/callout"
+)
+
+func (t *ProviderSuite) Test_Callout() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	sys, err := o.Accounts().Add("SYS")
+	t.NoError(err)
+	t.NoError(o.SetSystemAccount(sys))
+
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(scope.PubPermissions().SetAllow("work.{{name()}}"))
+
+	c, err := o.Accounts().Add("C")
+	t.NoError(err)
+	service, err := c.Users().Add("service", "")
+	t.NoError(err)
+	sentinel, err := c.Users().Add("sentinel", "")
+	t.NoError(err)
+	t.NoError(sentinel.SetBearerToken(true))
+	t.NoError(sentinel.PubPermissions().SetDeny(">"))
+	t.NoError(sentinel.SubPermissions().SetDeny(">"))
+
+	xkey, err := authb.KeyFor(nkeys.PrefixByteCurve)
+	t.NoError(err)
+	t.NoError(c.SetExternalAuthorizationUser([]interface{}{service}, []interface{}{a}, xkey.Public))
+	t.NoError(auth.Commit())
+
+	ns := t.startOperatorServer(o)
+	defer ns.Shutdown()
+
+	authorizer := callout.AuthorizerFunc(func(req *jwt.AuthorizationRequestClaims) (*callout.Authorization, error) {
+		opts := req.ConnectOptions
+		if opts.Username == "alice" && opts.Password == "secret" {
+			return &callout.Authorization{Account: "A", Role: "worker", Name: "alice"}, nil
+		}
+		if opts.Username == "bob" && opts.Password == "secret" {
+			return &callout.Authorization{Account: "SYS", Name: "bob"}, nil
+		}
+		return nil, errors.New("bad credentials")
+	})
+
+	snc, err := ns.MaybeConnect(t.userCreds(service))
+	t.NoError(err)
+	_, err = callout.New(snc, o, "C", authorizer, nil)
+	t.Error(err)
+	svc, err := callout.New(snc, o, "C", authorizer, &callout.Options{XKey: xkey.Pair})
+	t.NoError(err)
+	defer func() {
+		t.NoError(svc.Stop())
+	}()
+
+	nc, err := ns.MaybeConnect(t.userCreds(sentinel), nats.UserInfo("alice", "secret"))
+	t.NoError(err)
+	t.NoError(nc.Flush())
+	connz, err := ns.Server.Connz(&server.ConnzOptions{Account: a.Subject()})
+	t.NoError(err)
+	t.Equal(1, connz.NumConns)
+
+	_, err = ns.MaybeConnect(t.userCreds(sentinel), nats.UserInfo("alice", "wrong"))
+	t.Error(err)
+
+	// SYS is not an allowed account
+	_, err = ns.MaybeConnect(t.userCreds(sentinel), nats.UserInfo("bob", "secret"))
+	t.Error(err)
+}
+
+func (t *ProviderSuite) Test_CalloutInvalidRequest() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	c, err := o.Accounts().Add("C")
+	t.NoError(err)
+	service, err := c.Users().Add("service", "")
+	t.NoError(err)
+	xkey, err := authb.KeyFor(nkeys.PrefixByteCurve)
+	t.NoError(err)
+	t.NoError(c.SetExternalAuthorizationUser([]interface{}{service}, nil, xkey.Public))
+
+	// the server doesn't let clients send requests, so the service runs on a plain server
+	ns := t.startConfigServer(nil)
+	defer ns.Shutdown()
+	nc, err := ns.MaybeConnect()
+	t.NoError(err)
+	defer nc.Close()
+	authorizer := callout.AuthorizerFunc(func(req *jwt.AuthorizationRequestClaims) (*callout.Authorization, error) {
+		return &callout.Authorization{Name: "any"}, nil
+	})
+	svc, err := callout.New(nc, o, "C", authorizer, &callout.Options{XKey: xkey.Pair})
+	t.NoError(err)
+	defer func() {
+		t.NoError(svc.Stop())
+	}()
+
+	// requests that fail to decode are rejected if they identify the user and server
+	ukp, err := nkeys.CreateUser()
+	t.NoError(err)
+	upk, err := ukp.PublicKey()
+	t.NoError(err)
+	rc := jwt.NewAuthorizationRequestClaims(upk)
+	rc.UserNkey = upk
+	rc.Server = jwt.ServerID{ID: "S1", Name: "s1"}
+	token := t.encodeAuthorizationRequest(rc)
+	forged := strings.Split(token, ".")
+	forged[2] = strings.Split(t.encodeAuthorizationRequest(rc), ".")[2]
+	ckp, err := nkeys.CreateCurveKeys()
+	t.NoError(err)
+	cpk, err := ckp.PublicKey()
+	t.NoError(err)
+	sealed, err := ckp.Seal([]byte(strings.Join(forged, ".")), xkey.Public)
+	t.NoError(err)
+	msg := nats.NewMsg(callout.AuthCalloutSubject)
+	msg.Header.Set(callout.XKeyHeader, cpk)
+	msg.Data = sealed
+	m, err := nc.RequestMsg(msg, time.Second)
+	t.NoError(err)
+	data, err := ckp.Open(m.Data, xkey.Public)
+	t.NoError(err)
+	resp, err := jwt.DecodeAuthorizationResponseClaims(string(data))
+	t.NoError(err)
+	t.Equal(upk, resp.Subject)
+	t.Equal("S1", resp.Audience)
+	t.Empty(resp.Jwt)
+	t.Equal("invalid authorization request", resp.Error)
+}
+
+// encodeAuthorizationRequest signs the request with a new server key
+func (t *ProviderSuite) encodeAuthorizationRequest(rc *jwt.AuthorizationRequestClaims) string {
+	skp, err := nkeys.CreateServer()
+	t.NoError(err)
+	token, err := rc.Encode(skp)
+	t.NoError(err)
+	return token
+}
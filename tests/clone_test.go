@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"github.com/nats-io/jwt/v2"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+func (t *ProviderSuite) addBillingService(o authb.Operator) authb.Account {
+	svc, err := o.Accounts().Add("SVC")
+	t.NoError(err)
+	se, err := svc.Exports().Services().Add("billing", "billing.>")
+	t.NoError(err)
+	t.NoError(se.SetTokenRequired(true))
+	return svc
+}
+
+func (t *ProviderSuite) Test_CloneAccount() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	staging, err := auth.Operators().Add("staging")
+	t.NoError(err)
+	svc := t.addBillingService(staging)
+	app, err := staging.Accounts().Add("APP")
+	t.NoError(err)
+	si, err := app.Imports().Services().Add("billing", svc.Subject(), "billing.>")
+	t.NoError(err)
+	se, err := svc.Exports().Services().Get("billing.>")
+	t.NoError(err)
+	token, err := se.GenerateActivation(app.Subject(), svc.Subject())
+	t.NoError(err)
+	t.NoError(si.SetToken(token))
+	t.NoError(app.Limits().SetMaxConnections(10))
+	t.NoError(app.Tags().Add("env:staging"))
+	t.NoError(app.SubjectMappings().Set("in", authb.Mapping{Subject: "app.in", Weight: 100}))
+	scope, err := app.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(scope.PubPermissions().SetAllow("work.>"))
+	worker, err := app.Users().Add("worker", scope.Key())
+	t.NoError(err)
+	plain, err := app.Users().Add("plain", "")
+	t.NoError(err)
+	t.NoError(plain.PubPermissions().SetAllow("plain.>"))
+	t.NoError(plain.Tags().Add("team:a"))
+
+	production, err := auth.Operators().Add("production")
+	t.NoError(err)
+	prodSvc := t.addBillingService(production)
+
+	// activations can't be reissued without mapping the exporter
+	_, err = app.CloneTo(production, "APP", nil)
+	t.Error(err)
+	t.Empty(production.Accounts().List()[1:])
+
+	clone, err := app.CloneTo(production, "APP", &authb.CloneOptions{Accounts: map[string]string{"SVC": "SVC"}})
+	t.NoError(err)
+	t.NotEqual(app.Subject(), clone.Subject())
+	t.Equal(production.Subject(), clone.Issuer())
+	t.Equal(int64(10), clone.Limits().MaxConnections())
+	t.True(clone.Tags().Contains("env:staging"))
+	t.Equal([]authb.Mapping{{Subject: "app.in", Weight: 100}}, clone.SubjectMappings().Get("in"))
+	csi, err := clone.Imports().Services().Get("billing.>")
+	t.NoError(err)
+	t.Equal(prodSvc.Subject(), csi.Account())
+	ac, err := jwt.DecodeActivationClaims(csi.Token())
+	t.NoError(err)
+	t.Equal(clone.Subject(), ac.Subject)
+	t.Equal(prodSvc.Subject(), ac.Issuer)
+	scopes, err := clone.ScopedSigningKeys().GetScopeByRole("worker")
+	t.NoError(err)
+	t.Len(scopes, 1)
+	t.NotEqual(scope.Key(), scopes[0].Key())
+	t.Equal([]string{"work.>"}, scopes[0].PubPermissions().Allow())
+	t.Empty(clone.Users().List())
+
+	cw, err := worker.CloneTo(clone, "worker")
+	t.NoError(err)
+	t.True(cw.IsScoped())
+	t.Equal(scopes[0].Key(), cw.Issuer())
+	cp, err := plain.CloneTo(clone, "plain")
+	t.NoError(err)
+	t.NotEqual(plain.Subject(), cp.Subject())
+	t.Equal([]string{"plain.>"}, cp.PubPermissions().Allow())
+	t.True(cp.Tags().Contains("team:a"))
+	_, err = plain.CloneTo(clone, "plain")
+	t.Error(err)
+
+	// clones within the operator keep the exporter
+	copied, err := app.CloneTo(staging, "APP2", nil)
+	t.NoError(err)
+	csi, err = copied.Imports().Services().Get("billing.>")
+	t.NoError(err)
+	t.Equal(svc.Subject(), csi.Account())
+	ac, err = jwt.DecodeActivationClaims(csi.Token())
+	t.NoError(err)
+	t.Equal(copied.Subject(), ac.Subject)
+	_, err = app.CloneTo(staging, "APP2", nil)
+	t.Error(err)
+
+	t.True(authb.Verify(auth).OK(), authb.Verify(auth).Err())
+	t.NoError(auth.Commit())
+
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	clone = t.GetAccount(auth, "production", "APP")
+	t.Len(clone.Users().List(), 2)
+	t.True(authb.Verify(auth).OK(), authb.Verify(auth).Err())
+}
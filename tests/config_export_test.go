@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+func (t *ProviderSuite) startConfigServer(config []byte) *NatsServer {
+	fp := filepath.Join(t.T().TempDir(), "server.conf")
+	config = append([]byte("listen: 127.0.0.1:-1\n"), config...)
+	t.NoError(os.WriteFile(fp, config, 0o600))
+	opts, err := server.ProcessConfigFile(fp)
+	t.NoError(err)
+	opts.NoLog = true
+	opts.NoSigs = true
+	return NewNatsServer(t.T(), opts)
+}
+
+func nkeyOption(t *ProviderSuite, creds []byte) nats.Option {
+	kp, err := jwt.ParseDecoratedUserNKey(creds)
+	t.NoError(err)
+	pk, err := kp.PublicKey()
+	t.NoError(err)
+	return nats.Nkey(pk, kp.Sign)
+}
+
+func (t *ProviderSuite) Test_GenerateServerConfig() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	r, err := authb.MigrateServerConfig(o, serverConfig)
+	t.NoError(err)
+	creds := make(map[string][]byte)
+	for _, u := range r.Users {
+		creds[u.User.Name()] = u.Creds
+	}
+
+	// features that only exist in operator mode
+	a := t.GetAccount(auth, "O", "A")
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(scope.PubPermissions().SetAllow("work.>"))
+	worker, err := a.Users().Add("worker", scope.Key())
+	t.NoError(err)
+	revoked, err := a.Users().Add("revoked", "")
+	t.NoError(err)
+	t.NoError(a.Revocations().Add(revoked.Subject(), time.Now()))
+	au, err := a.Users().Get("a")
+	t.NoError(err)
+	t.NoError(au.ConnectionTimes().Set(authb.TimeRange{Start: "08:00:00", End: "17:00:00"}))
+	// the server only accepts wildcard functions for `*` tokens
+	t.NoError(a.SubjectMappings().Delete("old.>"))
+	t.NoError(a.SubjectMappings().Set("old.*", authb.Mapping{Subject: "events.{{wildcard(1)}}", Weight: 100}))
+
+	sc, err := authb.GenerateServerConfig(o)
+	t.NoError(err)
+	config := string(sc.Config)
+	t.Contains(config, `"system_account": "SYS"`)
+	t.Contains(config, fmt.Sprintf(`"nkey": %q`, worker.Subject()))
+	t.NotContains(config, revoked.Subject())
+	t.Contains(config, `"old.*": "events.{{wildcard(1)}}"`)
+	t.Contains(config, `"accounts": ["B"]`)
+	t.Contains(sc.Warnings, fmt.Sprintf(`account "A": scoped signing keys are not supported, users issued by %s get the permissions of the scope`, scope.Key()))
+	t.Contains(sc.Warnings, `account "A": user "revoked" is left out as it is revoked`)
+	t.Contains(sc.Warnings, `account "A": user "a": connection times not supported`)
+
+	// the generated configuration migrates back into an operator
+	o2, err := auth.Operators().Add("O2")
+	t.NoError(err)
+	r2, err := authb.MigrateServerConfig(o2, config)
+	t.NoError(err)
+	t.Len(r2.Accounts, 3)
+	t.Len(r2.Users, 6)
+	b2 := t.GetAccount(auth, "O2", "B")
+	t.True(b2.Limits().JetStream().IsJetStreamEnabled())
+	si, err := b2.Imports().Services().Get("q.>")
+	t.NoError(err)
+	t.Equal("svc.>", si.LocalSubject())
+	w2, err := t.GetAccount(auth, "O2", "A").Users().Get(worker.Subject())
+	t.NoError(err)
+	t.Equal([]string{"work.>"}, w2.PubPermissions().Allow())
+
+	// users connect with the seeds of their creds
+	ns := t.startConfigServer(sc.Config)
+	defer ns.Shutdown()
+	snc, err := ns.MaybeConnect(nkeyOption(t, creds["svc"]))
+	t.NoError(err)
+	defer snc.Close()
+	sub, err := snc.Subscribe("q.hello", func(m *nats.Msg) {
+		_ = m.Respond([]byte("hi"))
+	})
+	t.NoError(err)
+	defer func() {
+		_ = sub.Unsubscribe()
+	}()
+	t.NoError(snc.Flush())
+
+	bnc, err := ns.MaybeConnect(nkeyOption(t, creds["b"]))
+	t.NoError(err)
+	defer bnc.Close()
+	m, err := bnc.Request("svc.hello", nil, time.Second)
+	t.NoError(err)
+	t.Equal("hi", string(m.Data))
+
+	rc, err := revoked.Creds(time.Hour)
+	t.NoError(err)
+	_, err = ns.MaybeConnect(nkeyOption(t, rc))
+	t.Error(err)
+	t.True(strings.Contains(err.Error(), "Authorization Violation"))
+}
+
+func (t *ProviderSuite) Test_GenerateServerConfigUnknownImport() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	kp, err := nkeys.CreateAccount()
+	t.NoError(err)
+	other, err := kp.PublicKey()
+	t.NoError(err)
+	_, err = a.Imports().Streams().Add("x", other, "x.>")
+	t.NoError(err)
+
+	sc, err := authb.GenerateServerConfig(o)
+	t.NoError(err)
+	t.Contains(sc.Warnings, fmt.Sprintf(`account "A": import "x.>" is left out as account %s is not in the operator`, other))
+	t.NotContains(string(sc.Config), "imports")
+}
+
+func (t *ProviderSuite) Test_GenerateServerConfigPrivateExport() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	se, err := a.Exports().Services().Add("q", "q.>")
+	t.NoError(err)
+	t.NoError(se.SetTokenRequired(true))
+	_, err = o.Accounts().Add("B")
+	t.NoError(err)
+
+	// without importers the export would be public, so it is left out
+	sc, err := authb.GenerateServerConfig(o)
+	t.NoError(err)
+	t.Contains(sc.Warnings, `account "A": export "q.>" is left out as no account holds a valid activation`)
+	t.NotContains(string(sc.Config), "q.>")
+	ns := t.startConfigServer(sc.Config)
+	ns.Shutdown()
+}
@@ -0,0 +1,194 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+const serverConfig = `
+system_account: SYS
+no_auth_user: a
+accounts: {
+  SYS: {
+    users: [{user: sys, password: sys}]
+  }
+  A: {
+    users: [
+      {user: a, password: a, permissions: {
+        publish: {allow: ["q.>", "events.>"], deny: "q.secret"}
+        subscribe: "_INBOX.>"
+      }}
+      {user: svc, password: svc, permissions: {subscribe: "q.>", allow_responses: {max: 2, expires: "1m"}}}
+    ]
+    exports: [
+      {stream: "events.>"}
+      {service: "q.>", accounts: [B], latency: {sampling: "50%", subject: "lat.q"}, response: stream}
+    ]
+    jetstream: {max_mem: 1M, max_file: 1G, max_streams: 10}
+    mappings: {
+      "old.>": "events.{{wildcard(1)}}"
+      "split": [{destination: "split.a", weight: 40%}, {destination: "split.b", weight: 60}]
+    }
+    limits: {max_connections: 100}
+  }
+  B: {
+    default_permissions: {publish: "svc.>", subscribe: ["_INBOX.>", "a.events.>"]}
+    users: [
+      {user: b, password: b}
+      {nkey: UDXU4RCSJNZOIQHZNWXHXORDPRTGNJAHAHFRGZNEEJCPQTT2M7NLCNF4}
+    ]
+    imports: [
+      {service: {account: A, subject: "q.>"}, to: "svc.>"}
+      {stream: {account: A, subject: "events.>"}, prefix: a}
+    ]
+    jetstream: enabled
+  }
+}
+`
+
+func credsOption(t *ProviderSuite, creds []byte) nats.Option {
+	fp := filepath.Join(t.T().TempDir(), "user.creds")
+	t.NoError(os.WriteFile(fp, creds, 0o600))
+	return nats.UserCredentials(fp)
+}
+
+func (t *ProviderSuite) Test_MigrateServerConfig() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+
+	r, err := authb.MigrateServerConfig(o, serverConfig)
+	t.NoError(err)
+	t.Len(r.Accounts, 3)
+	t.Len(r.Users, 5)
+	t.Contains(r.Warnings, "no_auth_user is not supported in operator mode")
+	t.Contains(r.Warnings, `account "A": export "q.>": "response" is not supported`)
+	t.Contains(r.Warnings, `account "B": user "UDXU4RCSJNZOIQHZNWXHXORDPRTGNJAHAHFRGZNEEJCPQTT2M7NLCNF4": nkey UDXU4RCSJNZOIQHZNWXHXORDPRTGNJAHAHFRGZNEEJCPQTT2M7NLCNF4 is replaced by a new identity`)
+	t.Contains(r.Warnings, `account "A": user "a": password is not supported in operator mode, the user must use the creds`)
+	for _, u := range r.Users {
+		t.NotEmpty(u.Creds)
+		// the credentials that are not migrated are reported
+		if u.User.Name() == "UDXU4RCSJNZOIQHZNWXHXORDPRTGNJAHAHFRGZNEEJCPQTT2M7NLCNF4" {
+			t.Equal(u.User.Name(), u.Nkey)
+			t.False(u.Password)
+		} else {
+			t.Empty(u.Nkey)
+			t.True(u.Password)
+		}
+	}
+
+	sys, err := o.SystemAccount()
+	t.NoError(err)
+	t.Equal("SYS", sys.Name())
+
+	a := t.GetAccount(auth, "O", "A")
+	t.Equal(int64(100), a.Limits().MaxConnections())
+	js, err := a.Limits().JetStream().Get(0)
+	t.NoError(err)
+	mem, err := js.MaxMemoryStorage()
+	t.NoError(err)
+	t.Equal(int64(1000000), mem)
+	disk, err := js.MaxDiskStorage()
+	t.NoError(err)
+	t.Equal(int64(1000000000), disk)
+	streams, err := js.MaxStreams()
+	t.NoError(err)
+	t.Equal(int64(10), streams)
+	consumers, err := js.MaxConsumers()
+	t.NoError(err)
+	t.Equal(int64(-1), consumers)
+	t.Equal([]authb.Mapping{{Subject: "split.a", Weight: 40}, {Subject: "split.b", Weight: 60}}, a.SubjectMappings().Get("split"))
+	se, err := a.Exports().Services().Get("q.>")
+	t.NoError(err)
+	t.True(se.TokenRequired())
+	t.Equal(&authb.LatencyOpts{SamplingRate: 50, Subject: "lat.q"}, se.GetLatencyOptions())
+
+	au, err := a.Users().Get("a")
+	t.NoError(err)
+	t.Equal([]string{"q.>", "events.>"}, au.PubPermissions().Allow())
+	t.Equal([]string{"q.secret"}, au.PubPermissions().Deny())
+	svc, err := a.Users().Get("svc")
+	t.NoError(err)
+	t.Equal(2, svc.ResponsePermissions().MaxMessages())
+	t.Equal(time.Minute, svc.ResponsePermissions().Expires())
+
+	b := t.GetAccount(auth, "O", "B")
+	t.True(b.Limits().JetStream().IsJetStreamEnabled())
+	bu, err := b.Users().Get("b")
+	t.NoError(err)
+	t.Equal([]string{"svc.>"}, bu.PubPermissions().Allow())
+	si, err := b.Imports().Services().Get("q.>")
+	t.NoError(err)
+	t.Equal("svc.>", si.LocalSubject())
+	ac, err := jwt.DecodeActivationClaims(si.Token())
+	t.NoError(err)
+	t.Equal(b.Subject(), ac.Subject)
+	sti, err := b.Imports().Streams().Get("events.>")
+	t.NoError(err)
+	t.Equal("a.events.>", sti.LocalSubject())
+	t.True(authb.Verify(auth).OK())
+	t.NoError(auth.Commit())
+
+	// the migrated users connect to a server in operator mode
+	creds := make(map[string][]byte)
+	for _, u := range r.Users {
+		creds[u.User.Name()] = u.Creds
+	}
+	ns := t.startOperatorServer(o)
+	defer ns.Shutdown()
+	snc, err := ns.MaybeConnect(credsOption(t, creds["svc"]))
+	t.NoError(err)
+	defer snc.Close()
+	sub, err := snc.Subscribe("q.hello", func(m *nats.Msg) {
+		_ = m.Respond([]byte("hi"))
+	})
+	t.NoError(err)
+	defer func() {
+		_ = sub.Unsubscribe()
+	}()
+	t.NoError(snc.Flush())
+
+	bnc, err := ns.MaybeConnect(credsOption(t, creds["b"]))
+	t.NoError(err)
+	defer bnc.Close()
+	m, err := bnc.Request("svc.hello", nil, time.Second)
+	t.NoError(err)
+	t.Equal("hi", string(m.Data))
+}
+
+func (t *ProviderSuite) Test_MigrateServerConfigErrors() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+
+	_, err = authb.MigrateServerConfig(o, "port: 4222")
+	t.Error(err)
+	_, err = authb.MigrateServerConfig(o, "accounts: {")
+	t.Error(err)
+	_, err = o.Accounts().Add("A")
+	t.NoError(err)
+	_, err = authb.MigrateServerConfig(o, "accounts: {A: {}}")
+	t.Error(err)
+
+	// a failure leaves the operator as it was
+	_, err = authb.MigrateServerConfig(o, `accounts: {
+  B: {users: [{user: b, password: b}]}
+  C: {jetstream: bogus}
+}`)
+	t.Error(err)
+	t.Len(o.Accounts().List(), 1)
+	t.True(authb.Verify(auth).OK(), authb.Verify(auth).Err())
+	t.NoError(auth.Commit())
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err = auth.Operators().Get("O")
+	t.NoError(err)
+	t.Len(o.Accounts().List(), 1)
+}
@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+	"github.com/synadia-io/jwt-auth-builder.go/credservice"
+)
+
+// startOperatorServer starts a server trusting the operator with all its accounts preloaded
+func (t *ProviderSuite) startOperatorServer(o authb.Operator) *NatsServer {
+	conf, err := o.MemResolver()
+	t.NoError(err)
+	dir := t.T().TempDir()
+	fp := filepath.Join(dir, "server.conf")
+	conf = append([]byte("listen: 127.0.0.1:-1\n"), conf...)
+	t.NoError(os.WriteFile(fp, conf, 0o600))
+	opts, err := server.ProcessConfigFile(fp)
+	t.NoError(err)
+	opts.NoLog = true
+	opts.NoSigs = true
+	return NewNatsServer(t.T(), opts)
+}
+
+func (t *ProviderSuite) userCreds(u authb.User) nats.Option {
+	creds, err := u.Creds(time.Hour)
+	t.NoError(err)
+	fp := filepath.Join(t.T().TempDir(), u.Name()+".creds")
+	t.NoError(os.WriteFile(fp, creds, 0o600))
+	return nats.UserCredentials(fp)
+}
+
+func (t *ProviderSuite) Test_CredService() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	svc, err := o.Accounts().Add("SVC")
+	t.NoError(err)
+	_, err = svc.Exports().Services().Add("creds", "authb.creds.>")
+	t.NoError(err)
+	svcUser, err := svc.Users().Add("service", "")
+	t.NoError(err)
+
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	si, err := a.Imports().Services().Add("creds", svc.Subject(), "authb.creds.>")
+	t.NoError(err)
+	t.NoError(si.SetShareConnectionInfo(true))
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(scope.PubPermissions().SetAllow("work.{{name()}}"))
+	_, err = a.ScopedSigningKeys().AddScope("admin")
+	t.NoError(err)
+	team, err := a.ScopedSigningKeys().AddScope("team")
+	t.NoError(err)
+	t.NoError(team.PubPermissions().SetAllow("team.{{tag(team)}}.>"))
+	caller, err := a.Users().Add("caller", "")
+	t.NoError(err)
+
+	b, err := o.Accounts().Add("B")
+	t.NoError(err)
+	_, err = b.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(auth.Commit())
+
+	ns := t.startOperatorServer(o)
+	defer ns.Shutdown()
+
+	snc, err := ns.MaybeConnect(t.userCreds(svcUser))
+	t.NoError(err)
+	_, err = credservice.New(snc, o, credservice.Options{})
+	t.Error(err)
+	s, err := credservice.New(snc, o, credservice.Options{
+		Policy:   credservice.SameAccount("worker", "team"),
+		RoleTags: map[string][]string{"team": {"team:blue"}},
+	})
+	t.NoError(err)
+	defer func() {
+		t.NoError(s.Stop())
+	}()
+
+	nc, err := ns.MaybeConnect(t.userCreds(caller))
+	t.NoError(err)
+	r, err := nc.Request("authb.creds.A.worker", []byte(`{"name": "job", "expiry": "5m"}`), time.Second)
+	t.NoError(err)
+	t.Empty(r.Header.Get(micro.ErrorHeader))
+
+	token, err := jwt.ParseDecoratedJWT(r.Data)
+	t.NoError(err)
+	uc, err := jwt.DecodeUserClaims(token)
+	t.NoError(err)
+	t.Equal("job", uc.Name)
+	t.Equal(scope.Key(), uc.Issuer)
+	t.Equal(a.Subject(), uc.IssuerAccount)
+	t.True(uc.Expires <= time.Now().Add(5*time.Minute).Unix())
+
+	// the creds work, and the user was not added to the account
+	fp := filepath.Join(t.T().TempDir(), "job.creds")
+	t.NoError(os.WriteFile(fp, r.Data, 0o600))
+	jnc, err := ns.MaybeConnect(nats.UserCredentials(fp))
+	t.NoError(err)
+	t.NoError(jnc.Flush())
+	_, err = a.Users().Get("job")
+	t.ErrorIs(err, authb.ErrNotFound)
+
+	// the policy rejects other roles
+	r, err = nc.Request("authb.creds.A.admin", nil, time.Second)
+	t.NoError(err)
+	t.Equal("403", r.Header.Get(micro.ErrorCodeHeader))
+
+	// and other accounts
+	r, err = nc.Request("authb.creds.B.worker", nil, time.Second)
+	t.NoError(err)
+	t.Equal("403", r.Header.Get(micro.ErrorCodeHeader))
+
+	// tags are only issued if allowed for the role, so callers can't pick
+	// the subjects scope templates expand to
+	r, err = nc.Request("authb.creds.A.worker", []byte(`{"tags": ["team:blue"]}`), time.Second)
+	t.NoError(err)
+	t.Equal("403", r.Header.Get(micro.ErrorCodeHeader))
+	r, err = nc.Request("authb.creds.A.team", []byte(`{"tags": ["team:*"]}`), time.Second)
+	t.NoError(err)
+	t.Equal("403", r.Header.Get(micro.ErrorCodeHeader))
+	r, err = nc.Request("authb.creds.A.team", []byte(`{"tags": ["team:blue"]}`), time.Second)
+	t.NoError(err)
+	t.Empty(r.Header.Get(micro.ErrorHeader))
+	for subject, allowed := range map[string]bool{"team.blue.x": true, "team.red.x": false} {
+		d, err := authb.CheckCredsPermission(o, r.Data, authb.Publish, subject, nil)
+		t.NoError(err)
+		t.Equal(allowed, d.Allowed, subject)
+	}
+
+	// requests from the service account don't identify the caller
+	r, err = snc.Request("authb.creds.A.worker", nil, time.Second)
+	t.NoError(err)
+	t.Equal("401", r.Header.Get(micro.ErrorCodeHeader))
+}
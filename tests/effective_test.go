@@ -0,0 +1,91 @@
+package tests
+
+import (
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+func (t *ProviderSuite) Test_EffectivePermissionsNotScoped() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a := t.MaybeCreate(auth, "O", "A")
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+	t.NoError(u.PubPermissions().SetAllow("foo.{{name()}}"))
+
+	ep, err := u.EffectivePermissions()
+	t.NoError(err)
+	t.False(ep.Scoped)
+	// templates are only processed for scoped users
+	t.Equal([]string{"foo.{{name()}}"}, []string(ep.Limits.Pub.Allow))
+}
+
+func (t *ProviderSuite) Test_EffectivePermissionsScoped() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a := t.MaybeCreate(auth, "O", "A")
+	t.NoError(a.Tags().Add("region:east"))
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(scope.PubPermissions().SetAllow(
+		"{{account-name()}}.{{name()}}.>",
+		"inbox.{{subject()}}",
+		"team.{{tag(team)}}.{{account-tag(region)}}",
+		"missing.{{tag(missing)}}"))
+	t.NoError(scope.SubPermissions().SetAllow("team.{{tag(missing)}}"))
+
+	u, err := a.Users().Add("U", scope.Key())
+	t.NoError(err)
+	t.NoError(u.Tags().Add("team:red", "team:blue"))
+
+	ep, err := u.EffectivePermissions()
+	t.NoError(err)
+	t.True(ep.Scoped)
+	t.Equal(scope.Key(), ep.ScopeKey)
+	t.Equal("worker", ep.Role)
+	pub := ep.Limits.Pub.Allow
+	t.Contains(pub, "A.U.>")
+	t.Contains(pub, "inbox."+u.Subject())
+	t.Contains(pub, "team.red.east")
+	t.Contains(pub, "team.blue.east")
+	t.Len(pub, 4)
+	t.Empty(ep.Templates["missing.{{tag(missing)}}"])
+
+	// sub allow is empty after processing, so everything is denied
+	t.Empty(ep.Limits.Sub.Allow)
+	t.Contains(ep.Limits.Sub.Deny, ">")
+
+	// the scope is not modified
+	sl, err := a.ScopedSigningKeys().GetScope(scope.Key())
+	t.NoError(err)
+	t.Len(sl.PubPermissions().Allow(), 4)
+	t.Empty(sl.SubPermissions().Deny())
+
+	report := ep.String()
+	t.Contains(report, "worker")
+	t.Contains(report, "team.red.east")
+
+	d, err := authb.CheckPermission(u, authb.Publish, "team.red.east", nil)
+	t.NoError(err)
+	t.True(d.Allowed)
+	d, err = authb.CheckPermission(u, authb.Publish, "team.green.east", nil)
+	t.NoError(err)
+	t.False(d.Allowed)
+}
+
+func (t *ProviderSuite) Test_EffectivePermissionsBadDenyTemplate() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a := t.MaybeCreate(auth, "O", "A")
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(scope.PubPermissions().SetDeny("team.{{tag(team)}}"))
+	u, err := a.Users().Add("U", scope.Key())
+	t.NoError(err)
+
+	_, err = u.EffectivePermissions()
+	t.Error(err)
+
+	d, err := authb.CheckPermission(u, authb.Publish, "foo", nil)
+	t.NoError(err)
+	t.False(d.Allowed)
+}
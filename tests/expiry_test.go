@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+func (t *ProviderSuite) Test_ExpiryManager() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	soon := time.Now().Add(time.Minute).Unix()
+	later := time.Now().Add(48 * time.Hour).Unix()
+
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	t.NoError(a.SetExpiry(soon))
+	se, err := a.Exports().Services().Add("q", "q.>")
+	t.NoError(err)
+	t.NoError(se.SetTokenRequired(true))
+	sk, err := a.ScopedSigningKeys().Add()
+	t.NoError(err)
+	_, err = a.Users().Add("U", sk)
+	t.NoError(err)
+	t.NoError(a.Users().ReissueMany(time.Minute, "U"))
+	_, err = a.Users().Add("V", "")
+	t.NoError(err)
+
+	b, err := o.Accounts().Add("B")
+	t.NoError(err)
+	t.NoError(b.SetExpiry(later))
+	ac := jwt.NewActivationClaims(b.Subject())
+	ac.ImportSubject = "q.>"
+	ac.ImportType = jwt.Service
+	ac.Expires = soon
+	token, err := a.IssueClaim(ac, a.Subject())
+	t.NoError(err)
+	si, err := b.Imports().Services().Add("q", a.Subject(), "q.>")
+	t.NoError(err)
+	t.NoError(si.SetToken(token))
+	t.NoError(auth.Commit())
+
+	_, err = authb.NewExpiryManager(auth, authb.ExpiryOptions{})
+	t.Error(err)
+
+	m, err := authb.NewExpiryManager(auth, authb.ExpiryOptions{Window: time.Hour})
+	t.NoError(err)
+	expiring := m.Scan()
+	t.Len(expiring, 3)
+	kinds := make(map[authb.ExpiringKind]authb.Expiring)
+	for _, e := range expiring {
+		kinds[e.Kind] = e
+	}
+	t.Equal("A", kinds[authb.ExpiringAccount].Name)
+	t.Equal("U", kinds[authb.ExpiringUser].Name)
+	t.True(kinds[authb.ExpiringUser].SigningKey)
+	t.Equal("B", kinds[authb.ExpiringActivation].Account)
+	t.Equal("q", kinds[authb.ExpiringActivation].Name)
+
+	// the report doesn't change anything
+	expiring, err = m.Renew()
+	t.NoError(err)
+	t.Len(expiring, 3)
+	t.Equal(soon, a.Expiry())
+
+	var pushed, users []string
+	m, err = authb.NewExpiryManager(auth, authb.ExpiryOptions{
+		Window: time.Hour,
+		Policy: authb.RenewFor(24*time.Hour, authb.ExpiringAccount, authb.ExpiringUser, authb.ExpiringActivation),
+		Push: func(r *authb.Renewed) error {
+			t.Empty(r.Operators)
+			for _, a := range r.Accounts {
+				pushed = append(pushed, a.Name())
+			}
+			for _, u := range r.Users {
+				users = append(users, u.Name())
+			}
+			return nil
+		},
+	})
+	t.NoError(err)
+	expiring, err = m.Renew()
+	t.NoError(err)
+	t.Len(expiring, 3)
+	for _, e := range expiring {
+		t.True(e.Reissued, e.Kind)
+		t.NoError(e.Err)
+	}
+	t.ElementsMatch([]string{"A", "B"}, pushed)
+	t.Equal([]string{"U"}, users)
+	t.Empty(m.Scan())
+
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a = t.GetAccount(auth, "O", "A")
+	t.Greater(a.Expiry(), time.Now().Add(23*time.Hour).Unix())
+	b = t.GetAccount(auth, "O", "B")
+	si, err = b.Imports().Services().Get("q.>")
+	t.NoError(err)
+	ac, err = jwt.DecodeActivationClaims(si.Token())
+	t.NoError(err)
+	t.Greater(ac.Expires, soon)
+	m, err = authb.NewExpiryManager(auth, authb.ExpiryOptions{Window: time.Hour})
+	t.NoError(err)
+	t.Empty(m.Scan())
+}
+
+func (t *ProviderSuite) Test_ExpiryManagerRun() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	t.NoError(a.SetExpiry(time.Now().Add(time.Minute).Unix()))
+	t.NoError(auth.Commit())
+
+	var mu sync.Mutex
+	var reports [][]authb.Expiring
+	m, err := authb.NewExpiryManager(auth, authb.ExpiryOptions{
+		Window:   time.Hour,
+		Interval: 10 * time.Millisecond,
+		Policy:   authb.RenewFor(2 * time.Hour),
+		ReportFn: func(expiring []authb.Expiring) {
+			mu.Lock()
+			defer mu.Unlock()
+			reports = append(reports, expiring)
+		},
+	})
+	t.NoError(err)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	t.ErrorIs(m.Run(ctx), context.DeadlineExceeded)
+
+	mu.Lock()
+	defer mu.Unlock()
+	t.Greater(len(reports), 1)
+	t.Len(reports[0], 1)
+	t.True(reports[0][0].Reissued)
+	t.Empty(reports[1])
+}
@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"time"
+
+	"github.com/nats-io/nuid"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+	"github.com/synadia-io/jwt-auth-builder.go/providers/kv"
+)
+
+func (t *ProviderSuite) Test_KvHistory() {
+	if t.Kind != KvProvider {
+		t.T().Skip("history is only kept by the KV provider")
+	}
+	_, err := kv.NewKvProvider(kv.NatsOptions(t.NS.Url), kv.Bucket(nuid.Next()), kv.History(100))
+	t.Error(err)
+	p, err := kv.NewKvProvider(kv.NatsOptions(t.NS.Url), kv.Bucket(nuid.Next()), kv.History(10))
+	t.NoError(err)
+	defer p.Disconnect()
+
+	auth, err := authb.NewAuth(p)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	t.NoError(a.Limits().SetMaxConnections(10))
+	t.NoError(auth.Commit())
+
+	t.NoError(a.Limits().SetMaxConnections(20))
+	_, err = a.Users().Add("U", "")
+	t.NoError(err)
+	t.NoError(auth.Commit())
+
+	history, err := p.EntityHistory(a.Subject())
+	t.NoError(err)
+	t.Len(history, 2)
+	old, err := authb.NewAccountFromJWT(history[0].Token)
+	t.NoError(err)
+	t.Equal(int64(10), old.Limits().MaxConnections())
+
+	// views load the store as it was
+	for _, view := range []*kv.KvProvider{p.AtRevision(history[0].Revision), p.AsOf(history[0].Created)} {
+		past, err := authb.NewAuth(view)
+		t.NoError(err)
+		pa := t.GetAccount(past, "O", "A")
+		t.Equal(int64(10), pa.Limits().MaxConnections())
+		t.Empty(pa.Users().List())
+		t.NoError(pa.Limits().SetMaxConnections(5))
+		t.Error(past.Commit())
+	}
+
+	// restoring re-issues the earlier configuration
+	t.NoError(a.Restore(history[0].Token))
+	t.Equal(int64(10), a.Limits().MaxConnections())
+	t.Len(a.Users().List(), 1)
+	t.NoError(auth.Commit())
+	history, err = p.EntityHistory(a.Subject())
+	t.NoError(err)
+	t.Len(history, 3)
+	t.Equal(a.JWT(), history[2].Token)
+}
+
+func (t *ProviderSuite) Test_Restore() {
+	_, _, a := setupTestWithOperatorAndAccount(t)
+	previous := a.JWT()
+	sk, err := a.ScopedSigningKeys().Add()
+	t.NoError(err)
+	t.NoError(a.Limits().SetMaxConnections(10))
+	_, err = a.Exports().Streams().Add("s", "s.>")
+	t.NoError(err)
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+	t.NoError(a.Revocations().Add(u.Subject(), time.Now()))
+
+	t.NoError(a.Restore(previous))
+	t.Equal(int64(-1), a.Limits().MaxConnections())
+	t.Empty(a.Exports().Streams().List())
+	ok, _ := a.ScopedSigningKeys().Contains(sk)
+	t.True(ok)
+	revoked, err := a.Revocations().Contains(u.Subject())
+	t.NoError(err)
+	t.True(revoked)
+
+	other, err := a.Users().Add("other", "")
+	t.NoError(err)
+	t.Error(a.Restore(other.JWT()))
+	t.Error(u.Restore(other.JWT()))
+
+	userPrevious := u.JWT()
+	t.NoError(u.PubPermissions().SetAllow("q.>"))
+	t.NoError(u.Restore(userPrevious))
+	t.Empty(u.PubPermissions().Allow())
+}
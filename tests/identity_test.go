@@ -0,0 +1,226 @@
+package tests
+
+import (
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+func (t *ProviderSuite) Test_OperatorRotateIdentity() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	sk, err := o.SigningKeys().Add()
+	t.NoError(err)
+	sys, err := o.Accounts().Add("SYS")
+	t.NoError(err)
+	t.NoError(o.SetSystemAccount(sys))
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	b, err := o.Accounts().Add("B")
+	t.NoError(err)
+	t.NoError(b.SetIssuer(sk))
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+	t.NoError(auth.Commit())
+
+	previous := o.Subject()
+	r, err := o.RotateIdentity()
+	t.NoError(err)
+	t.Equal(previous, r.Previous)
+	t.Equal(o.Subject(), r.Current)
+	t.NotEqual(previous, o.Subject())
+	t.Equal(o.JWT(), r.Operator)
+	t.NotEmpty(r.Resolver)
+	t.NotEmpty(r.Steps)
+
+	// accounts issued by the identity key are re-issued, others remain valid
+	t.Len(r.Accounts, 2)
+	t.Contains(r.Accounts, a.Subject())
+	t.Contains(r.Accounts, sys.Subject())
+	t.Equal(o.Subject(), a.Issuer())
+	t.Equal(sk, b.Issuer())
+	for _, acct := range o.Accounts().List() {
+		ac, err := jwt.DecodeAccountClaims(acct.JWT())
+		t.NoError(err)
+		t.True(o.(*authb.OperatorData).Claim.DidSign(ac))
+	}
+	t.NoError(auth.Commit())
+	t.False(t.Store.KeyExists(previous))
+	t.True(t.Store.KeyExists(o.Subject()))
+
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	t.Len(auth.Operators().List(), 1)
+	o, err = auth.Operators().Get("O")
+	t.NoError(err)
+	t.Equal(r.Current, o.Subject())
+	t.Len(o.Accounts().List(), 3)
+
+	ns := t.startOperatorServer(o)
+	defer ns.Shutdown()
+	a = t.GetAccount(auth, "O", "A")
+	u, err = a.Users().Get(u.Name())
+	t.NoError(err)
+	nc, err := ns.MaybeConnect(t.userCreds(u))
+	t.NoError(err)
+	nc.Close()
+}
+
+func (t *ProviderSuite) Test_AccountRotateIdentity() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	sys, err := o.Accounts().Add("SYS")
+	t.NoError(err)
+	t.NoError(o.SetSystemAccount(sys))
+
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	se, err := a.Exports().Services().Add("q", "q.>")
+	t.NoError(err)
+	t.NoError(se.SetTokenRequired(true))
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(scope.PubPermissions().SetAllow("q.>"))
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+	su, err := a.Users().Add("SU", scope.Key())
+	t.NoError(err)
+
+	b, err := o.Accounts().Add("B")
+	t.NoError(err)
+	token, err := se.GenerateActivation(b.Subject(), a.Subject())
+	t.NoError(err)
+	si, err := b.Imports().Services().Add("q", a.Subject(), "q.>")
+	t.NoError(err)
+	t.NoError(si.SetToken(token))
+	bu, err := b.Users().Add("BU", "")
+	t.NoError(err)
+
+	c, err := o.Accounts().Add("C")
+	t.NoError(err)
+	t.NoError(c.SetExternalAuthorizationUser([]interface{}{"UA6KOMQ67XOE3FHE37W4OXADVXVYISBNLTBUT2LSY5VFKAIJ7CRDR2RZ"}, []interface{}{a}, ""))
+	t.NoError(auth.Commit())
+
+	previous := a.Subject()
+	r, err := a.RotateIdentity()
+	t.NoError(err)
+	t.Equal(previous, r.Previous)
+	t.Equal(a.Subject(), r.Current)
+	t.Equal([]string{previous}, r.Remove)
+	t.Len(r.Users, 2)
+	t.Len(r.Accounts, 3)
+	t.Empty(r.Operator)
+
+	t.Equal(a.Subject(), u.Issuer())
+	t.Equal(scope.Key(), su.Issuer())
+	t.Equal(a.Subject(), su.IssuerAccount())
+
+	// the import and its activation reference the new ID
+	si, err = b.Imports().Services().Get("q.>")
+	t.NoError(err)
+	t.Equal(a.Subject(), si.Account())
+	ac, err := jwt.DecodeActivationClaims(si.Token())
+	t.NoError(err)
+	t.Equal(b.Subject(), ac.Subject)
+	t.Equal(a.Subject(), ac.Issuer)
+
+	_, allowed, _ := c.ExternalAuthorization()
+	t.Equal([]string{a.Subject()}, allowed)
+	t.NoError(auth.Commit())
+	t.False(t.Store.KeyExists(previous))
+
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err = auth.Operators().Get("O")
+	t.NoError(err)
+	t.Len(o.Accounts().List(), 4)
+	a = t.GetAccount(auth, "O", "A")
+	t.Equal(r.Current, a.Subject())
+	t.Len(a.Users().List(), 2)
+
+	ns := t.startOperatorServer(o)
+	defer ns.Shutdown()
+	su, err = a.Users().Get("SU")
+	t.NoError(err)
+	snc, err := ns.MaybeConnect(t.userCreds(su))
+	t.NoError(err)
+	snc.Close()
+	u, err = a.Users().Get("U")
+	t.NoError(err)
+	nc, err := ns.MaybeConnect(t.userCreds(u))
+	t.NoError(err)
+	defer nc.Close()
+	sub, err := nc.Subscribe("q.hello", func(m *nats.Msg) {
+		_ = m.Respond([]byte("hi"))
+	})
+	t.NoError(err)
+	defer func() {
+		_ = sub.Unsubscribe()
+	}()
+	t.NoError(nc.Flush())
+
+	b = t.GetAccount(auth, "O", "B")
+	bu, err = b.Users().Get(bu.Name())
+	t.NoError(err)
+	bnc, err := ns.MaybeConnect(t.userCreds(bu))
+	t.NoError(err)
+	defer bnc.Close()
+	m, err := bnc.Request("q.hello", nil, time.Second)
+	t.NoError(err)
+	t.Equal("hi", string(m.Data))
+}
+
+func (t *ProviderSuite) Test_AccountRotateIdentityRollback() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	se, err := a.Exports().Services().Add("q", "q.>")
+	t.NoError(err)
+	t.NoError(se.SetTokenRequired(true))
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+
+	// the activation can't be re-issued as A doesn't have the key that signed it
+	b, err := o.Accounts().Add("B")
+	t.NoError(err)
+	ac := jwt.NewActivationClaims(b.Subject())
+	ac.ImportSubject = "q.>"
+	ac.ImportType = jwt.Service
+	ac.IssuerAccount = a.Subject()
+	kp, err := nkeys.CreateAccount()
+	t.NoError(err)
+	token, err := ac.Encode(kp)
+	t.NoError(err)
+	si, err := b.Imports().Services().Add("q", a.Subject(), "q.>")
+	t.NoError(err)
+	t.NoError(si.SetToken(token))
+	t.NoError(auth.Commit())
+
+	previous := a.Subject()
+	userToken := u.JWT()
+	_, err = a.RotateIdentity()
+	t.Error(err)
+	t.Equal(previous, a.Subject())
+	t.Equal(previous, u.Issuer())
+	t.Equal(userToken, u.JWT())
+	si, err = b.Imports().Services().Get("q.>")
+	t.NoError(err)
+	t.Equal(previous, si.Account())
+	t.Equal(token, si.Token())
+	t.NoError(auth.Commit())
+	t.True(t.Store.KeyExists(previous))
+
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	t.Equal(previous, t.GetAccount(auth, "O", "A").Subject())
+}
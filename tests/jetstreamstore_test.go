@@ -36,6 +36,10 @@ func (ts *KvStore) GetKey(k string) *authb.Key {
 	return v
 }
 
+func (ts *KvStore) DeleteKey(k string) {
+	require.NoError(ts.t, ts.provider.DeleteKey(k))
+}
+
 func (ts *KvStore) OperatorExists(name string) bool {
 	// FIXME: should have a way of listing operators by name
 	operators, err := ts.provider.LoadOperators()
@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/nats-io/nkeys"
+	"github.com/nats-io/nuid"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+	"github.com/synadia-io/jwt-auth-builder.go/providers/keystore"
+	"github.com/synadia-io/jwt-auth-builder.go/providers/kv"
+	"github.com/synadia-io/jwt-auth-builder.go/providers/nsc"
+)
+
+// providerWithKeyStore returns a provider of the suite kind storing the JWTs
+// in the stores directory or bucket named by location and the seeds in ks
+func (t *ProviderSuite) providerWithKeyStore(location string, ks authb.KeyStore) authb.AuthProvider {
+	if t.Kind == NscProvider {
+		return nsc.NewNscProviderWithKeyStore(location, ks)
+	}
+	p, err := kv.NewKvProvider(kv.NatsOptions(t.NS.Url), kv.Bucket(location), kv.KeyStore(ks))
+	t.Require().NoError(err)
+	t.T().Cleanup(p.Disconnect)
+	return p
+}
+
+func (t *ProviderSuite) Test_DirKeyStore() {
+	dir := t.T().TempDir()
+	curve, err := nkeys.CreateCurveKeys()
+	t.NoError(err)
+	seed, err := curve.Seed()
+	t.NoError(err)
+	ks, err := keystore.NewDirKeyStore(dir, string(seed))
+	t.NoError(err)
+
+	k := t.UserKey()
+	t.NoError(ks.PutKey(k))
+	t.NoError(ks.PutKey(&authb.Key{Public: t.AccountKey().Public}))
+	stored, err := ks.GetKey(k.Public)
+	t.NoError(err)
+	t.Equal(k.Seed, stored.Seed)
+	d, err := os.ReadFile(filepath.Join(dir, k.Public+".nk"))
+	t.NoError(err)
+	t.NotContains(string(d), string(k.Seed))
+	entries, err := os.ReadDir(dir)
+	t.NoError(err)
+	t.Len(entries, 1)
+
+	// the seeds can't be opened without the encryption key
+	plain, err := keystore.NewDirKeyStore(dir, "")
+	t.NoError(err)
+	_, err = plain.GetKey(k.Public)
+	t.Error(err)
+	_, err = ks.GetKey("../" + k.Public)
+	t.Error(err)
+
+	t.NoError(ks.DeleteKey(k.Public))
+	t.NoError(ks.DeleteKey(k.Public))
+	stored, err = ks.GetKey(k.Public)
+	t.NoError(err)
+	t.Nil(stored)
+}
+
+func (t *ProviderSuite) Test_ProviderKeyStore() {
+	dir := t.T().TempDir()
+	ks, err := keystore.NewDirKeyStore(dir, "")
+	t.NoError(err)
+	location := nuid.Next()
+	if t.Kind == NscProvider {
+		location = filepath.Join(t.T().TempDir(), location)
+	}
+	p := t.providerWithKeyStore(location, ks)
+
+	auth, err := authb.NewAuth(p)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	sk, err := a.ScopedSigningKeys().Add()
+	t.NoError(err)
+	xk, err := a.XKey().Create()
+	t.NoError(err)
+	u, err := a.Users().Add("U", sk)
+	t.NoError(err)
+	t.NoError(auth.Commit())
+
+	for _, pk := range []string{o.Subject(), a.Subject(), sk, xk, u.Subject()} {
+		k, err := ks.GetKey(pk)
+		t.NoError(err)
+		t.NotNil(k, pk)
+	}
+	// the seeds are not in the default store
+	t.False(t.Store.KeyExists(o.Subject()))
+
+	auth, err = authb.NewAuth(p)
+	t.NoError(err)
+	a = t.GetAccount(auth, "O", "A")
+	t.Equal(xk, a.XKey().Public())
+	t.NoError(a.Limits().SetMaxConnections(10))
+	_, err = a.Users().Add("V", "")
+	t.NoError(err)
+	t.NoError(auth.Commit())
+	t.True(authb.Verify(auth).OK(), authb.Verify(auth).Err())
+
+	// the claims can be used with another key store that has some of the seeds
+	ak, err := ks.GetKey(sk)
+	t.NoError(err)
+	mks := keystore.NewMemoryKeyStore(ak)
+	auth, err = authb.NewAuth(t.providerWithKeyStore(location, mks))
+	t.NoError(err)
+	a = t.GetAccount(auth, "O", "A")
+	t.Error(a.Limits().SetMaxConnections(20))
+	_, err = a.Users().Add("W", sk)
+	t.NoError(err)
+	t.False(authb.Verify(auth).OK())
+}
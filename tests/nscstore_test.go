@@ -49,6 +49,11 @@ func (ts *NscStore) GetKey(k string) *authb.Key {
 	return key
 }
 
+func (ts *NscStore) DeleteKey(k string) {
+	fp := filepath.Join(ts.KeysDir(), store.KeysDir, k[:1], k[1:3], fmt.Sprintf("%s%s", k, store.NKeyExtension))
+	require.NoError(ts.t, os.Remove(fp))
+}
+
 func (ts *NscStore) OperatorExists(name string) bool {
 	fp := filepath.Join(ts.StoresDir(), name, fmt.Sprintf("%s.jwt", name))
 	_, err := os.Stat(fp)
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+func (t *ProviderSuite) Test_OperatorFromJWT() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	sk, err := o.SigningKeys().Add()
+	t.NoError(err)
+	t.NoError(o.SetOperatorServiceURL("nats://localhost:4222"))
+
+	ro, err := authb.NewOperatorFromJWT(o.JWT())
+	t.NoError(err)
+	t.Equal("O", ro.Name())
+	t.Equal(o.Subject(), ro.Subject())
+	t.Equal([]string{sk}, ro.SigningKeys().List())
+	t.Equal([]string{"nats://localhost:4222"}, ro.OperatorServiceURLs())
+	t.Empty(ro.Accounts().List())
+
+	t.EqualError(ro.SetOperatorServiceURL("nats://localhost:4333"), "operator is read-only")
+	t.Equal([]string{"nats://localhost:4222"}, ro.OperatorServiceURLs())
+	_, err = ro.Accounts().Add("A")
+	t.EqualError(err, "operator is read-only")
+	_, err = ro.SigningKeys().Add()
+	t.EqualError(err, "operator is read-only")
+	_, err = ro.RotateIdentity()
+	t.EqualError(err, "operator is read-only")
+	t.Equal(o.JWT(), ro.JWT())
+}
+
+func (t *ProviderSuite) Test_AccountFromJWTRejectsEdits() {
+	_, _, a := setupTestWithOperatorAndAccount(t)
+	sk, err := a.ScopedSigningKeys().AddScope("admin")
+	t.NoError(err)
+	t.NoError(a.Limits().SetMaxConnections(10))
+
+	ra, err := authb.NewAccountFromJWT(a.JWT())
+	t.NoError(err)
+	t.Equal(int64(10), ra.Limits().MaxConnections())
+	scopes, err := ra.ScopedSigningKeys().GetScopeByRole("admin")
+	t.NoError(err)
+	t.Len(scopes, 1)
+	t.Equal(sk.Key(), scopes[0].Key())
+
+	t.EqualError(ra.Limits().SetMaxConnections(20), "account is read-only")
+	t.Equal(int64(10), ra.Limits().MaxConnections())
+	_, err = ra.Users().Add("U", "")
+	t.EqualError(err, "account is read-only")
+	_, err = ra.ScopedSigningKeys().Add()
+	t.EqualError(err, "account is read-only")
+	_, err = ra.ScopedSigningKeys().Delete(sk.Key())
+	t.EqualError(err, "account is read-only")
+	t.EqualError(ra.SetIssuer(""), "account is read-only")
+	_, err = ra.XKey().Create()
+	t.EqualError(err, "account is read-only")
+	t.Equal(a.JWT(), ra.JWT())
+}
+
+func (t *ProviderSuite) Test_UserFromJWT() {
+	_, o, a := setupTestWithOperatorAndAccount(t)
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(scope.PubPermissions().SetAllow("q.>"))
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+	t.NoError(u.PubPermissions().SetAllow("foo"))
+	su, err := a.Users().Add("SU", scope.Key())
+	t.NoError(err)
+
+	ru, err := authb.NewUserFromJWT(u.JWT(), nil)
+	t.NoError(err)
+	t.Equal("U", ru.Name())
+	t.Equal(u.Subject(), ru.Subject())
+	t.Equal(a.Subject(), ru.IssuerAccount())
+	t.Equal([]string{"foo"}, ru.PubPermissions().Allow())
+	t.False(ru.IsScoped())
+	t.EqualError(ru.PubPermissions().SetAllow("bar"), "user is read-only")
+	t.Equal([]string{"foo"}, ru.PubPermissions().Allow())
+	_, err = ru.Creds(0)
+	t.Error(err)
+
+	// the account resolves the scope
+	ra, err := authb.NewAccountFromJWT(a.JWT())
+	t.NoError(err)
+	rsu, err := authb.NewUserFromJWT(su.JWT(), ra)
+	t.NoError(err)
+	t.True(rsu.IsScoped())
+	ep, err := rsu.EffectivePermissions()
+	t.NoError(err)
+	t.Equal(jwt.StringList{"q.>"}, ep.Limits.Pub.Allow)
+
+	other, err := o.Accounts().Add("B")
+	t.NoError(err)
+	_, err = authb.NewUserFromJWT(su.JWT(), other)
+	t.Error(err)
+}
+
+func (t *ProviderSuite) Test_ParseCreds() {
+	_, _, a := setupTestWithOperatorAndAccount(t)
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+	t.NoError(u.Tags().Add("t1"))
+	creds, err := u.Creds(0)
+	t.NoError(err)
+
+	ru, err := authb.ParseCreds(creds, nil)
+	t.NoError(err)
+	t.Equal(u.Subject(), ru.Subject())
+	t.True(ru.Tags().Contains("t1"))
+	t.EqualError(ru.Tags().Add("t2"), "user is read-only")
+	t.False(ru.Tags().Contains("t2"))
+
+	d, err := ru.Creds(0)
+	t.NoError(err)
+	t.Equal(creds, d)
+	_, err = ru.Creds(time.Hour)
+	t.EqualError(err, "user is read-only")
+	t.Equal(u.JWT(), ru.JWT())
+
+	_, err = authb.ParseCreds([]byte("not creds"), nil)
+	t.Error(err)
+}
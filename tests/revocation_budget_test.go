@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+// expiredUser adds an ephemeral user whose JWT has expired
+func (t *ProviderSuite) expiredUser(a authb.Account) authb.User {
+	uc := jwt.NewUserClaims(t.UserKey().Public)
+	uc.Name = "expired"
+	uc.Expires = time.Now().Add(-time.Minute).Unix()
+	u, err := a.Users().ImportEphemeral(uc, "")
+	t.NoError(err)
+	return u
+}
+
+// revokeDirect revokes the user without updating the account
+func revokeDirect(a authb.Account, u authb.User) {
+	ad := a.(*authb.AccountData)
+	if ad.Claim.Revocations == nil {
+		ad.Claim.Revocations = jwt.RevocationList{}
+	}
+	ad.Claim.Revocations.Revoke(u.Subject(), time.Now())
+}
+
+func (t *ProviderSuite) Test_RevocationsPruneAndStats() {
+	_, _, a := setupTestWithOperatorAndAccount(t)
+	t.Equal(authb.RevocationStats{JWTSize: len(a.JWT())}, a.Revocations().Stats())
+
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+	old := time.Now().Add(-time.Hour)
+	t.NoError(a.Revocations().Add(u.Subject(), old))
+	expired := t.expiredUser(a)
+	revokeDirect(a, expired)
+
+	stats := a.Revocations().Stats()
+	t.Equal(2, stats.Entries)
+	t.Equal(1, stats.Expired)
+	t.Equal(old.Unix(), stats.Oldest.Unix())
+	t.Greater(stats.Bytes, 0)
+	t.Greater(stats.JWTSize, stats.Bytes)
+
+	pruned, err := a.Revocations().Prune()
+	t.NoError(err)
+	t.Len(pruned, 1)
+	t.Equal(expired.Subject(), pruned[0].PublicKey())
+	t.Equal(1, a.Revocations().Stats().Entries)
+
+	// adding doesn't prune, as the user may hold creds that outlive its stored JWT
+	revokeDirect(a, expired)
+	t.NoError(a.Revocations().Add(u.Subject(), time.Now()))
+	ok, err := a.Revocations().Contains(expired.Subject())
+	t.NoError(err)
+	t.True(ok)
+}
+
+func (t *ProviderSuite) Test_AccountSizeBudget() {
+	auth, err := authb.NewAuthWithOptions(t.Provider, &authb.Options{AccountSizeBudget: 1500})
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+
+	expired := t.expiredUser(a)
+	revokeDirect(a, expired)
+	var err2 error
+	for i := 0; i < 20 && err2 == nil; i++ {
+		err2 = a.Revocations().Add(t.UserKey().Public, time.Now())
+	}
+	t.ErrorIs(err2, authb.ErrSizeBudgetExceeded)
+	t.LessOrEqual(len(a.JWT()), 1500)
+
+	// a change that exceeds the budget leaves the revocations untouched
+	revokeDirect(a, expired)
+	rejected := t.UserKey().Public
+	t.ErrorIs(a.Revocations().Add(rejected, time.Now()), authb.ErrSizeBudgetExceeded)
+	ok, err := a.Revocations().Contains(expired.Subject())
+	t.NoError(err)
+	t.True(ok)
+	ok, err = a.Revocations().Contains(rejected)
+	t.NoError(err)
+	t.False(ok)
+
+	// the budget can warn instead of failing
+	var warned int
+	auth, err = authb.NewAuthWithOptions(t.Provider, &authb.Options{
+		AccountSizeBudget: 1500,
+		BudgetFn: func(account authb.Account, size int) error {
+			warned = size
+			return nil
+		},
+	})
+	t.NoError(err)
+	o, err = auth.Operators().Add("O")
+	t.NoError(err)
+	a, err = o.Accounts().Add("A")
+	t.NoError(err)
+	for i := 0; i < 20; i++ {
+		t.NoError(a.Revocations().Add(t.UserKey().Public, time.Now()))
+	}
+	t.Greater(warned, 1500)
+	t.Greater(len(a.JWT()), 1500)
+
+	auth, err = authb.NewAuthWithOptions(t.Provider, &authb.Options{
+		AccountSizeBudget: 100,
+		BudgetFn: func(account authb.Account, size int) error {
+			return errors.New("too big")
+		},
+	})
+	t.NoError(err)
+	o, err = auth.Operators().Add("O")
+	t.NoError(err)
+	_, err = o.Accounts().Add("A")
+	t.Error(err)
+}
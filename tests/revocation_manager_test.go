@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+func (t *ProviderSuite) Test_RevokeSigningKeyAndTag() {
+	auth, o, a := setupTestWithOperatorAndAccount(t)
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	u1, err := a.Users().Add("U1", scope.Key())
+	t.NoError(err)
+	u2, err := a.Users().Add("U2", scope.Key())
+	t.NoError(err)
+	u3, err := a.Users().Add("U3", "")
+	t.NoError(err)
+	t.NoError(u3.Tags().Add("team:red"))
+	u4, err := a.Users().Add("U4", "")
+	t.NoError(err)
+	t.NoError(u4.Tags().Add("team:blue"))
+
+	_, err = a.RevocationManager().RevokeSigningKey(o.Subject(), time.Time{})
+	t.ErrorIs(err, authb.ErrNotFound)
+
+	// a user issued by the key that is not stored
+	ukp, err := nkeys.CreateUser()
+	t.NoError(err)
+	upk, err := ukp.PublicKey()
+	t.NoError(err)
+	ephemeral, err := a.IssueClaim(jwt.NewUserClaims(upk), scope.Key())
+	t.NoError(err)
+
+	r, err := a.RevocationManager().RevokeSigningKey(scope.Key(), time.Time{})
+	t.NoError(err)
+	t.ElementsMatch([]string{u1.Subject(), u2.Subject()}, r.Revoked)
+	t.Equal(2, r.Affected)
+	t.False(r.StoredOnly)
+	for _, u := range []authb.User{u1, u2} {
+		ok, err := a.Revocations().Contains(u.Subject())
+		t.NoError(err)
+		t.True(ok)
+	}
+
+	// the key is replaced, so the users it issued are no longer valid
+	t.NotEqual(scope.Key(), r.Key)
+	_, err = a.ScopedSigningKeys().GetScope(scope.Key())
+	t.ErrorIs(err, authb.ErrNotFound)
+	replaced, err := a.ScopedSigningKeys().GetScope(r.Key)
+	t.NoError(err)
+	t.Equal("worker", replaced.Role())
+	uc, err := jwt.DecodeUserClaims(ephemeral)
+	t.NoError(err)
+	found, _ := a.ScopedSigningKeys().Contains(uc.Issuer)
+	t.False(found)
+	t.True(authb.Verify(auth).OK(), authb.Verify(auth).Err())
+
+	// already revoked users are not affected again
+	r, err = a.RevocationManager().RevokeSigningKey(r.Key, time.Time{})
+	t.NoError(err)
+	t.Equal(0, r.Affected)
+
+	r, err = a.RevocationManager().RevokeTag("team:red", time.Time{})
+	t.NoError(err)
+	t.Equal([]string{u3.Subject()}, r.Revoked)
+	t.Equal(1, r.Affected)
+	t.True(r.StoredOnly)
+	ok, err := a.Revocations().Contains(u4.Subject())
+	t.NoError(err)
+	t.False(ok)
+
+	// a wildcard revocation makes the entries redundant
+	t.NoError(a.Revocations().Add("*", time.Now().Add(time.Hour)))
+	r, err = a.RevocationManager().RevokeTag("team:blue", time.Time{})
+	t.NoError(err)
+	t.Equal(0, r.Affected)
+	t.Len(r.Compacted, 4)
+	t.Len(a.Revocations().List(), 1)
+	t.NoError(auth.Commit())
+}
+
+func (t *ProviderSuite) Test_RevokeImporter() {
+	auth, o, a := setupTestWithOperatorAndAccount(t)
+	se, err := a.Exports().Services().Add("q", "q.>")
+	t.NoError(err)
+	t.NoError(se.SetTokenRequired(true))
+	_, err = a.Exports().Streams().Add("public", "public.>")
+	t.NoError(err)
+
+	b, err := o.Accounts().Add("B")
+	t.NoError(err)
+	token, err := se.GenerateActivation(b.Subject(), a.Subject())
+	t.NoError(err)
+	si, err := b.Imports().Services().Add("q", a.Subject(), "q.>")
+	t.NoError(err)
+	t.NoError(si.SetToken(token))
+
+	r, err := a.RevocationManager().RevokeImporter("B", time.Time{})
+	t.NoError(err)
+	t.Equal([]string{b.Subject()}, r.Revoked)
+	t.Equal([]string{"q.>"}, r.Exports)
+	t.Equal(1, r.Affected)
+
+	se, err = a.Exports().Services().Get("q.>")
+	t.NoError(err)
+	ok, err := se.Revocations().Contains(b.Subject())
+	t.NoError(err)
+	t.True(ok)
+	t.NoError(auth.Commit())
+
+	c, err := o.Accounts().Add("C")
+	t.NoError(err)
+	_, err = c.RevocationManager().RevokeImporter(b.Subject(), time.Time{})
+	t.Error(err)
+	_, err = a.RevocationManager().RevokeImporter("X", time.Time{})
+	t.Error(err)
+}
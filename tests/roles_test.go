@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+func (t *ProviderSuite) Test_AddWithRole() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a := t.MaybeCreate(auth, "O", "A")
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(scope.PubPermissions().SetAllow("team.{{tag(team)}}.>"))
+
+	_, _, err = a.Users().AddWithRole("U", "admin", nil)
+	t.Error(err)
+
+	// the scope requires a team tag
+	_, _, err = a.Users().AddWithRole("U", "worker", nil)
+	t.Error(err)
+	t.Len(a.Users().List(), 0)
+
+	u, creds, err := a.Users().AddWithRole("U", "worker", &authb.RoleOptions{
+		Tags:   []string{"team:red"},
+		Expiry: time.Hour,
+	})
+	t.NoError(err)
+	t.True(u.IsScoped())
+	t.Equal(scope.Key(), u.Issuer())
+	t.True(u.Tags().Contains("team:red"))
+
+	token, err := jwt.ParseDecoratedJWT(creds)
+	t.NoError(err)
+	uc, err := jwt.DecodeUserClaims(token)
+	t.NoError(err)
+	t.Equal(u.Subject(), uc.Subject)
+	t.True(uc.Expires > time.Now().Unix())
+
+	t.NoError(auth.Commit())
+	t.NoError(auth.Reload())
+	a = t.GetAccount(auth, "O", "A")
+	u, err = a.Users().Get("U")
+	t.NoError(err)
+	t.True(u.IsScoped())
+}
+
+func (t *ProviderSuite) Test_AddWithRoleLeastUsed() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a := t.MaybeCreate(auth, "O", "A")
+	s1, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	s2, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+
+	opts := &authb.RoleOptions{Strategy: authb.LeastUsedScope}
+	counts := make(map[string]int)
+	for _, n := range []string{"a", "b", "c", "d"} {
+		u, _, err := a.Users().AddWithRole(n, "worker", opts)
+		t.NoError(err)
+		counts[u.Issuer()]++
+	}
+	t.Equal(2, counts[s1.Key()])
+	t.Equal(2, counts[s2.Key()])
+}
+
+func (t *ProviderSuite) Test_AddRoster() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a := t.MaybeCreate(auth, "O", "A")
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(scope.SubPermissions().SetAllow("team.{{tag(team)}}.>"))
+	_, err = a.ScopedSigningKeys().AddScope("admin")
+	t.NoError(err)
+
+	csv := "name,role,tags,expiry\n" +
+		"a,worker,team:red;site:x,24h\n" +
+		"b,admin,,\n"
+	entries, err := authb.ParseRoster(strings.NewReader(csv), authb.RosterCSV)
+	t.NoError(err)
+	t.Len(entries, 2)
+	t.Equal([]string{"team:red", "site:x"}, entries[0].Tags)
+	t.Equal(24*time.Hour, entries[0].Expiry)
+
+	users, err := a.Users().AddRoster(entries, authb.FirstScope)
+	t.NoError(err)
+	t.Len(users, 2)
+	for _, pu := range users {
+		t.NotEmpty(pu.Creds)
+	}
+
+	// a missing tag fails the whole roster
+	js := `[{"name": "c", "role": "admin"}, {"name": "d", "role": "worker"}]`
+	entries, err = authb.ParseRoster(strings.NewReader(js), authb.RosterJSON)
+	t.NoError(err)
+	_, err = a.Users().AddRoster(entries, authb.FirstScope)
+	t.Error(err)
+	t.Len(a.Users().List(), 2)
+	_, err = a.Users().Get("c")
+	t.ErrorIs(err, authb.ErrNotFound)
+
+	t.NoError(auth.Commit())
+	t.NoError(auth.Reload())
+	a = t.GetAccount(auth, "O", "A")
+	t.Len(a.Users().List(), 2)
+}
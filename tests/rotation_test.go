@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"time"
+
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+func (t *ProviderSuite) Test_AccountStagedRotation() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(scope.PubPermissions().SetAllow("work.>"))
+	old := scope.Key()
+	u, err := a.Users().Add("U", old)
+	t.NoError(err)
+	t.NoError(auth.Commit())
+
+	_, err = a.ScopedSigningKeys().StartRotation(a.Subject(), time.Hour)
+	t.ErrorIs(err, authb.ErrNotFound)
+
+	key, err := a.ScopedSigningKeys().StartRotation(old, time.Hour)
+	t.NoError(err)
+	_, err = a.ScopedSigningKeys().StartRotation(old, time.Hour)
+	t.Error(err)
+
+	// the new key has a copy of the scope
+	ns, err := a.ScopedSigningKeys().GetScope(key)
+	t.NoError(err)
+	t.Equal("worker", ns.Role())
+	t.Equal([]string{"work.>"}, ns.PubPermissions().Allow())
+	scopes, err := a.ScopedSigningKeys().GetScopeByRole("worker")
+	t.NoError(err)
+	t.Len(scopes, 1)
+	t.Equal(key, scopes[0].Key())
+
+	// new users are issued with the new key, existing users are unchanged
+	u2, err := a.Users().Add("U2", old)
+	t.NoError(err)
+	t.Equal(key, u2.Issuer())
+	t.Equal(old, u.Issuer())
+
+	status := a.ScopedSigningKeys().Retiring()
+	t.Len(status, 1)
+	t.Equal(old, status[0].Key)
+	t.Equal(key, status[0].Replacement)
+	t.Equal([]string{"U"}, status[0].Dependents)
+	t.NoError(auth.Commit())
+
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a = t.GetAccount(auth, "O", "A")
+	status = a.ScopedSigningKeys().Retiring()
+	t.Len(status, 1)
+	t.Equal([]string{"U"}, status[0].Dependents)
+
+	_, err = a.ScopedSigningKeys().CompleteRotation(old, false)
+	t.ErrorIs(err, authb.ErrRotationPending)
+	pk, err := a.ScopedSigningKeys().CompleteRotation(old, true)
+	t.NoError(err)
+	t.Equal(key, pk)
+	t.Empty(a.ScopedSigningKeys().Retiring())
+	found, _ := a.ScopedSigningKeys().Contains(old)
+	t.False(found)
+	u, err = a.Users().Get("U")
+	t.NoError(err)
+	t.Equal(key, u.Issuer())
+	t.NoError(auth.Commit())
+	t.False(t.Store.KeyExists(old))
+
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a = t.GetAccount(auth, "O", "A")
+	u, err = a.Users().Get("U")
+	t.NoError(err)
+	t.Equal(key, u.Issuer())
+}
+
+func (t *ProviderSuite) Test_OperatorStagedRotation() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	old, err := o.SigningKeys().Add()
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	t.NoError(a.SetIssuer(old))
+
+	key, err := o.SigningKeys().StartRotation(old, 0)
+	t.NoError(err)
+	b, err := o.Accounts().Add("B")
+	t.NoError(err)
+	t.NoError(b.SetIssuer(old))
+	t.Equal(key, b.Issuer())
+	t.Equal(old, a.Issuer())
+
+	status := o.SigningKeys().Retiring()
+	t.Len(status, 1)
+	t.Equal([]string{"A"}, status[0].Dependents)
+	t.NoError(auth.Commit())
+
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err = auth.Operators().Get("O")
+	t.NoError(err)
+	t.Len(o.SigningKeys().Retiring(), 1)
+
+	// the deadline passed
+	pk, err := o.SigningKeys().CompleteRotation(old, false)
+	t.NoError(err)
+	t.Equal(key, pk)
+	t.Equal([]string{key}, o.SigningKeys().List())
+	t.Empty(o.SigningKeys().Retiring())
+	a = t.GetAccount(auth, "O", "A")
+	t.Equal(key, a.Issuer())
+	t.NoError(auth.Commit())
+}
@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+func (t *ProviderSuite) Test_CheckPermissionPublish() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	b, err := o.Accounts().Add("B")
+	t.NoError(err)
+	_, err = b.Exports().Services().Add("q", "q.>")
+	t.NoError(err)
+	_, err = a.Imports().Services().Add("q", b.Subject(), "q.>")
+	t.NoError(err)
+
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+	t.NoError(u.PubPermissions().SetAllow("q.>", "foo"))
+	t.NoError(u.PubPermissions().SetDeny("q.secret"))
+
+	d, err := authb.CheckPermission(u, authb.Publish, "q.a", nil)
+	t.NoError(err)
+	t.True(d.Allowed, d.String())
+	t.Len(d.Routes, 1)
+	t.Len(d.Routes[0], 2)
+	t.Equal(b.Subject(), d.Routes[0][1].Account)
+	t.Equal("q.a", d.Routes[0][1].Subject)
+
+	d, err = authb.CheckPermission(u, authb.Publish, "q.secret", nil)
+	t.NoError(err)
+	t.False(d.Allowed)
+
+	d, err = authb.CheckPermission(u, authb.Publish, "bar", nil)
+	t.NoError(err)
+	t.False(d.Allowed)
+	t.False(d.ResponseOnly)
+
+	t.NoError(u.ResponsePermissions().SetMaxMessages(1))
+	d, err = authb.CheckPermission(u, authb.Publish, "bar", nil)
+	t.NoError(err)
+	t.False(d.Allowed)
+	t.True(d.ResponseOnly)
+
+	d, err = authb.CheckPermission(u, authb.Publish, "q.*", nil)
+	t.NoError(err)
+	t.False(d.Allowed)
+}
+
+func (t *ProviderSuite) Test_CheckPermissionMappings() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	t.NoError(a.SubjectMappings().Set("orders.*", authb.Mapping{Subject: "region.east.orders.{{wildcard(1)}}", Weight: 100}))
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+
+	d, err := authb.CheckPermission(u, authb.Publish, "orders.123", nil)
+	t.NoError(err)
+	t.True(d.Allowed)
+	t.Len(d.Routes, 1)
+	route := d.Routes[0]
+	t.Equal("region.east.orders.123", route[len(route)-1].Subject)
+
+	// the most specific of the matching mappings applies
+	t.NoError(a.SubjectMappings().Set("orders.>", authb.Mapping{Subject: "legacy.orders.>", Weight: 100}))
+	t.NoError(a.SubjectMappings().Set("*.*", authb.Mapping{Subject: "other.>", Weight: 100}))
+	for i := 0; i < 10; i++ {
+		d, err = authb.CheckPermission(u, authb.Publish, "orders.123", nil)
+		t.NoError(err)
+		route = d.Routes[0]
+		t.Equal("region.east.orders.123", route[len(route)-1].Subject)
+	}
+	d, err = authb.CheckPermission(u, authb.Publish, "orders.123.x", nil)
+	t.NoError(err)
+	route = d.Routes[0]
+	t.Equal("legacy.orders.123.x", route[len(route)-1].Subject)
+}
+
+func (t *ProviderSuite) Test_CheckPermissionSubscribe() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	b, err := o.Accounts().Add("B")
+	t.NoError(err)
+	_, err = b.Exports().Streams().Add("events", "events.>")
+	t.NoError(err)
+	_, err = a.Imports().Streams().Add("events", b.Subject(), "events.>")
+	t.NoError(err)
+
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+	t.NoError(u.SubPermissions().SetAllow("events.>", "work workers"))
+	t.NoError(u.SubPermissions().SetDeny("events.private"))
+
+	d, err := authb.CheckPermission(u, authb.Subscribe, "events.*", nil)
+	t.NoError(err)
+	t.True(d.Allowed)
+	t.Len(d.Routes, 2)
+	t.Equal(b.Subject(), d.Routes[1][0].Account)
+
+	d, err = authb.CheckPermission(u, authb.Subscribe, "events.private", nil)
+	t.NoError(err)
+	t.False(d.Allowed)
+
+	d, err = authb.CheckPermission(u, authb.Subscribe, "work", nil)
+	t.NoError(err)
+	t.False(d.Allowed)
+
+	d, err = authb.CheckPermission(u, authb.Subscribe, "work", &authb.ConnectionContext{Queue: "workers"})
+	t.NoError(err)
+	t.True(d.Allowed)
+}
+
+func (t *ProviderSuite) Test_CheckPermissionScopedUser() {
+	u := setupScopeUser(t)
+	d, err := authb.CheckPermission(u, authb.Subscribe, "q", nil)
+	t.NoError(err)
+	t.True(d.Allowed)
+	d, err = authb.CheckPermission(u, authb.Subscribe, "r", nil)
+	t.NoError(err)
+	t.False(d.Allowed)
+}
+
+func (t *ProviderSuite) Test_CheckPermissionConnection() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a := t.MaybeCreate(auth, "O", "A")
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+	t.NoError(u.ConnectionTypes().Set(jwt.ConnectionTypeWebsocket))
+	t.NoError(u.ConnectionSources().Add("192.168.1.0/24"))
+	t.NoError(u.ConnectionTimes().Set(authb.TimeRange{Start: "08:00:00", End: "17:00:00"}))
+	t.NoError(u.SetLocale("UTC"))
+
+	ctx := &authb.ConnectionContext{
+		ConnectionType: jwt.ConnectionTypeWebsocket,
+		Source:         "192.168.1.10",
+		Time:           time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	d, err := authb.CheckPermission(u, authb.Publish, "foo", ctx)
+	t.NoError(err)
+	t.True(d.Allowed, d.String())
+
+	bad := *ctx
+	bad.ConnectionType = jwt.ConnectionTypeStandard
+	d, err = authb.CheckPermission(u, authb.Publish, "foo", &bad)
+	t.NoError(err)
+	t.False(d.Allowed)
+
+	bad = *ctx
+	bad.Source = "10.0.0.1"
+	d, err = authb.CheckPermission(u, authb.Publish, "foo", &bad)
+	t.NoError(err)
+	t.False(d.Allowed)
+
+	bad = *ctx
+	bad.Time = time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)
+	d, err = authb.CheckPermission(u, authb.Publish, "foo", &bad)
+	t.NoError(err)
+	t.False(d.Allowed)
+
+	t.NoError(a.Revocations().Add(u.Subject(), time.Now().Add(time.Hour)))
+	d, err = authb.CheckPermission(u, authb.Publish, "foo", ctx)
+	t.NoError(err)
+	t.False(d.Allowed)
+}
+
+func (t *ProviderSuite) Test_CheckCredsPermission() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+	t.NoError(u.PubPermissions().SetAllow("foo"))
+	creds, err := u.Creds(time.Hour)
+	t.NoError(err)
+
+	d, err := authb.CheckCredsPermission(o, creds, authb.Publish, "foo", nil)
+	t.NoError(err)
+	t.True(d.Allowed)
+	d, err = authb.CheckCredsPermission(o, creds, authb.Publish, "bar", nil)
+	t.NoError(err)
+	t.False(d.Allowed)
+}
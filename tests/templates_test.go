@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"errors"
+	"time"
+
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+func (t *ProviderSuite) Test_ScopeRejectsMalformedTemplates() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a := t.MaybeCreate(auth, "O", "A")
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+
+	bad := []string{
+		"team.{{tag(team}}",
+		"team.{{tag(team)}}x",
+		"team.{{tag()}}",
+		"team.{{tag(my team)}}",
+		"team.{{foo()}}",
+		"team.{{name()",
+	}
+	for _, s := range bad {
+		err = scope.PubPermissions().SetAllow(s)
+		t.Error(err, s)
+		t.True(errors.Is(err, authb.ErrInvalidTemplate), s)
+		t.Error(scope.SubPermissions().SetDeny(s), s)
+	}
+	t.Empty(scope.PubPermissions().Allow())
+
+	t.NoError(scope.PubPermissions().SetAllow("{{name()}}.{{tag(team)}}", "{{ account-name() }}.>"))
+
+	// templates are not processed for users, so they are not validated
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+	t.NoError(u.PubPermissions().SetAllow("team.{{tag(team}}"))
+}
+
+func (t *ProviderSuite) Test_ScopeValidateTemplates() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a := t.MaybeCreate(auth, "O", "A")
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(scope.PubPermissions().SetAllow("{{account-tag(region)}}.>"))
+
+	err = scope.ValidateTemplates()
+	t.Error(err)
+	t.Contains(err.Error(), "region")
+
+	t.NoError(a.Tags().Add("region:east"))
+	t.NoError(scope.ValidateTemplates())
+}
+
+func (t *ProviderSuite) Test_ScopePreviewTemplates() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a := t.MaybeCreate(auth, "O", "A")
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(scope.PubPermissions().SetAllow("{{name()}}.{{tag(team)}}"))
+
+	// the user is not issued by the scope
+	u, err := a.Users().Add("U", "")
+	t.NoError(err)
+	t.NoError(u.Tags().Add("team:red"))
+
+	ep, err := scope.PreviewTemplates(u)
+	t.NoError(err)
+	t.True(ep.Scoped)
+	t.Equal(scope.Key(), ep.ScopeKey)
+	t.Equal([]string{"U.red"}, []string(ep.Limits.Pub.Allow))
+}
+
+func (t *ProviderSuite) Test_ScopedCredsValidateTemplates() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a := t.MaybeCreate(auth, "O", "A")
+	scope, err := a.ScopedSigningKeys().AddScope("worker")
+	t.NoError(err)
+	t.NoError(scope.PubPermissions().SetDeny("team.{{tag(team)}}"))
+
+	u, err := a.Users().Add("U", scope.Key())
+	t.NoError(err)
+	// the deny template cannot be expanded without a team tag
+	_, err = u.Creds(time.Hour)
+	t.Error(err)
+	t.True(errors.Is(err, authb.ErrInvalidTemplate))
+
+	t.NoError(u.Tags().Add("team:red"))
+	_, err = u.Creds(time.Hour)
+	t.NoError(err)
+}
@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+	"github.com/synadia-io/jwt-auth-builder.go/providers/kv"
+)
+
+func (t *ProviderSuite) tenantUser(name string, opts ...kv.KvProviderOption) *server.User {
+	perms, err := kv.Permissions(append(opts, kv.Tenant(name))...)
+	t.NoError(err)
+	return &server.User{
+		Username: name,
+		Password: name,
+		Permissions: &server.Permissions{
+			Publish:   &server.SubjectPermission{Allow: perms.Pub.Allow},
+			Subscribe: &server.SubjectPermission{Allow: perms.Sub.Allow},
+		},
+	}
+}
+
+func (t *ProviderSuite) Test_KvTenants() {
+	if t.Kind != KvProvider {
+		t.T().Skip("tenants are only supported by the KV provider")
+	}
+	_, err := kv.Permissions(kv.Tenant("acme"))
+	t.Error(err)
+	_, err = kv.Permissions(kv.Bucket("auth"), kv.KeysBucket("auth_keys"), kv.Tenant("a.b"))
+	t.Error(err)
+	_, err = kv.Permissions(kv.Bucket("auth"), kv.Tenant("acme"))
+	t.Error(err)
+
+	buckets := []kv.KvProviderOption{kv.Bucket("auth"), kv.KeysBucket("auth_keys")}
+	opts := defaultNatsOptions(t.T().TempDir())
+	opts.Users = []*server.User{
+		{Username: "admin", Password: "admin"},
+		t.tenantUser("acme", buckets...),
+		t.tenantUser("globex", buckets...),
+	}
+	ns := NewNatsServer(t.T(), opts)
+	defer ns.Shutdown()
+
+	provider := func(user string, tenant bool) *kv.KvProvider {
+		opts := append([]kv.KvProviderOption{kv.NatsOptions(ns.Url, nats.UserInfo(user, user))}, buckets...)
+		if tenant {
+			opts = append(opts, kv.Tenant(user))
+		}
+		p, err := kv.NewKvProvider(opts...)
+		t.Require().NoError(err)
+		return p
+	}
+	// the administrator creates the buckets
+	admin := provider("admin", false)
+	defer admin.Disconnect()
+
+	acme := provider("acme", true)
+	defer acme.Disconnect()
+	auth, err := authb.NewAuth(acme)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	_, err = a.Users().Add("U", "")
+	t.NoError(err)
+	t.NoError(auth.Commit())
+	auth, err = authb.NewAuth(acme)
+	t.NoError(err)
+	a = t.GetAccount(auth, "O", "A")
+	t.Len(a.Users().List(), 1)
+	t.NoError(a.Limits().SetMaxConnections(10))
+	t.NoError(auth.Commit())
+
+	// tenants only see their own operators
+	globex := provider("globex", true)
+	defer globex.Disconnect()
+	gauth, err := authb.NewAuth(globex)
+	t.NoError(err)
+	t.Empty(gauth.Operators().List())
+	_, err = gauth.Operators().Add("O")
+	t.NoError(err)
+	t.NoError(gauth.Commit())
+
+	// seeds are stored in the keys bucket under the tenant
+	ctx := context.Background()
+	_, err = admin.Keys.Get(ctx, "acme.keys."+o.Subject())
+	t.NoError(err)
+	_, err = admin.Kv.Get(ctx, "acme.keys."+o.Subject())
+	t.ErrorIs(err, jetstream.ErrKeyNotFound)
+	_, err = admin.Kv.Get(ctx, "acme.O."+o.Subject())
+	t.NoError(err)
+
+	// and other tenants can't read them
+	denied := map[string]jetstream.KeyValue{
+		"acme.keys." + o.Subject(): globex.Keys,
+		"acme.O." + o.Subject():    globex.Kv,
+	}
+	for key, k := range denied {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		_, err = k.Get(ctx, key)
+		cancel()
+		t.Error(err)
+		t.NotErrorIs(err, jetstream.ErrKeyNotFound)
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+func (t *ProviderSuite) Test_UsersBatch() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a := t.MaybeCreate(auth, "O", "A")
+
+	var specs []authb.UserSpec
+	for i := 0; i < 50; i++ {
+		specs = append(specs, authb.UserSpec{Name: fmt.Sprintf("u%d", i), Tags: []string{"batch:1"}})
+	}
+	specs = append(specs, authb.UserSpec{Name: "bad", Signer: "nokey"}, authb.UserSpec{})
+	users, err := a.Users().AddMany(specs)
+	t.Len(users, 50)
+	var be *authb.BatchError
+	t.True(errors.As(err, &be))
+	t.Len(be.Errors, 2)
+	t.Equal("bad", be.Errors[0].Name)
+
+	u, err := a.Users().Get("u10")
+	t.NoError(err)
+	t.True(u.Tags().Contains("batch:1"))
+	u, err = a.Users().Get(u.Subject())
+	t.NoError(err)
+	t.Equal("u10", u.Name())
+
+	err = a.Users().DeleteMany("u1", "u2", "missing")
+	t.True(errors.As(err, &be))
+	t.Len(be.Errors, 1)
+	t.True(errors.Is(err, authb.ErrNotFound))
+	t.Len(a.Users().List(), 48)
+	_, err = a.Users().Get("u1")
+	t.ErrorIs(err, authb.ErrNotFound)
+
+	t.NoError(a.Users().ReissueMany(time.Hour, "u3", "u4"))
+	u, err = a.Users().Get("u3")
+	t.NoError(err)
+	token, err := jwt.ParseDecoratedJWT(mustCreds(t, u))
+	t.NoError(err)
+	uc, err := jwt.DecodeUserClaims(token)
+	t.NoError(err)
+	t.True(uc.Expires > 0)
+
+	t.NoError(auth.Commit())
+	t.NoError(auth.Reload())
+	a = t.GetAccount(auth, "O", "A")
+	t.Len(a.Users().List(), 48)
+}
+
+func mustCreds(t *ProviderSuite, u authb.User) []byte {
+	creds, err := u.Creds(0)
+	t.NoError(err)
+	return creds
+}
+
+func (t *ProviderSuite) Test_UsersExportCreds() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a := t.MaybeCreate(auth, "O", "A")
+	_, err = a.Users().AddMany([]authb.UserSpec{{Name: "a"}, {Name: "b"}, {Name: "c"}})
+	t.NoError(err)
+
+	var names []string
+	t.NoError(a.Users().EachCreds(time.Hour, func(u authb.User, creds []byte) error {
+		names = append(names, u.Name())
+		t.NotEmpty(creds)
+		return nil
+	}))
+	t.Equal([]string{"a", "b", "c"}, names)
+
+	var buf bytes.Buffer
+	t.NoError(a.Users().ExportCreds(&buf, authb.CredsTar, time.Hour, "a", "b"))
+	tr := tar.NewReader(&buf)
+	var files []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		t.NoError(err)
+		d, err := io.ReadAll(tr)
+		t.NoError(err)
+		_, err = jwt.ParseDecoratedJWT(d)
+		t.NoError(err)
+		files = append(files, hdr.Name)
+	}
+	t.Equal([]string{"a.creds", "b.creds"}, files)
+
+	buf.Reset()
+	t.NoError(a.Users().ExportCreds(&buf, authb.CredsZip, 0))
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	t.NoError(err)
+	t.Len(zr.File, 3)
+
+	// ephemeral users have no seed, so no creds
+	_, err = a.Users().AddWithIdentity("e", "", t.UserKey().Public)
+	t.NoError(err)
+	buf.Reset()
+	err = a.Users().ExportCreds(&buf, authb.CredsZip, 0)
+	var be *authb.BatchError
+	t.True(errors.As(err, &be))
+	t.Equal("e", be.Errors[0].Name)
+}
@@ -0,0 +1,123 @@
+package tests
+
+import (
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+)
+
+func problems(r *authb.TrustReport) map[string][]string {
+	m := make(map[string][]string)
+	for _, i := range r.Issues {
+		m[i.Subject] = append(m[i.Subject], i.Problem)
+	}
+	return m
+}
+
+func (t *ProviderSuite) Test_VerifyValidStore() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	osk, err := o.SigningKeys().Add()
+	t.NoError(err)
+	sys, err := o.Accounts().Add("SYS")
+	t.NoError(err)
+	t.NoError(o.SetSystemAccount(sys))
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	t.NoError(a.SetIssuer(osk))
+	sk, err := a.ScopedSigningKeys().Add()
+	t.NoError(err)
+	_, err = a.Users().Add("U", sk)
+	t.NoError(err)
+	se, err := a.Exports().Services().Add("q", "q.>")
+	t.NoError(err)
+	t.NoError(se.SetTokenRequired(true))
+
+	b, err := o.Accounts().Add("B")
+	t.NoError(err)
+	token, err := se.GenerateActivation(b.Subject(), sk)
+	t.NoError(err)
+	si, err := b.Imports().Services().Add("q", a.Subject(), "q.>")
+	t.NoError(err)
+	t.NoError(si.SetToken(token))
+	t.True(authb.Verify(auth).OK())
+	t.NoError(auth.Commit())
+
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	r := authb.Verify(auth)
+	t.NoError(r.Err())
+}
+
+func (t *ProviderSuite) Test_VerifyMissingSeeds() {
+	auth, o, a := setupTestWithOperatorAndAccount(t)
+	sk, err := a.ScopedSigningKeys().Add()
+	t.NoError(err)
+	u, err := a.Users().Add("U", sk)
+	t.NoError(err)
+	t.NoError(auth.Commit())
+	t.Store.DeleteKey(sk)
+	t.Store.DeleteKey(u.Subject())
+
+	// the loaders keep the public keys
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a = t.GetAccount(auth, o.Name(), a.Name())
+	t.Equal([]string{sk}, a.ScopedSigningKeys().List())
+	u, err = a.Users().Get("U")
+	t.NoError(err)
+	_, err = u.Creds(0)
+	t.Error(err)
+	_, err = a.Users().Add("U2", sk)
+	t.Error(err)
+
+	r := authb.Verify(auth)
+	t.Len(r.Issues, 2)
+	p := problems(r)
+	t.Equal([]string{"signing key seed is missing"}, p[sk])
+	t.Equal([]string{"identity key seed is missing"}, p[u.Subject()])
+	t.Equal("A", r.Issues[0].Account)
+	t.Error(r.Err())
+}
+
+func (t *ProviderSuite) Test_VerifyBrokenLinks() {
+	auth, o, a := setupTestWithOperatorAndAccount(t)
+	osk, err := o.SigningKeys().Add()
+	t.NoError(err)
+	t.NoError(a.SetIssuer(osk))
+	sk, err := a.ScopedSigningKeys().Add()
+	t.NoError(err)
+	u, err := a.Users().Add("U", sk)
+	t.NoError(err)
+	expired := t.expiredUser(a)
+	se, err := a.Exports().Services().Add("q", "q.>")
+	t.NoError(err)
+	t.NoError(se.SetTokenRequired(true))
+	b, err := o.Accounts().Add("B")
+	t.NoError(err)
+	_, err = b.Imports().Services().Add("q", a.Subject(), "q.>")
+	t.NoError(err)
+
+	// leftovers of removing signing keys without re-issuing their entities
+	od := o.(*authb.OperatorData)
+	od.Claim.SigningKeys.Remove(osk)
+	t.NoError(o.SetOperatorServiceURL("nats://localhost:4222"))
+	ad := a.(*authb.AccountData)
+	delete(ad.Claim.SigningKeys, sk)
+	t.NoError(a.SetExpiry(0))
+
+	r := authb.Verify(auth)
+	p := problems(r)
+	t.Equal([]string{"signing key is not in the JWT"}, p[osk])
+	t.Equal([]string{"signing key is not in the JWT"}, p[sk])
+	t.Len(p[a.Subject()], 2)
+	t.Contains(p[a.Subject()][0], "which is not the operator or one of its signing keys")
+	t.Len(p[u.Subject()], 1)
+	t.Contains(p[u.Subject()][0], "which is not the account or one of its signing keys")
+	t.Len(p[expired.Subject()], 1)
+	t.Contains(p[expired.Subject()][0], "JWT expired at")
+	t.Equal([]string{`export "q.>" requires an activation token`}, p[a.Subject()][1:])
+	t.Equal("B", r.Issues[len(r.Issues)-1].Account)
+	t.Equal("q", r.Issues[len(r.Issues)-1].Import)
+	t.Len(r.Issues, 6)
+}
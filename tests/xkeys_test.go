@@ -0,0 +1,155 @@
+package tests
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	authb "github.com/synadia-io/jwt-auth-builder.go"
+	"github.com/synadia-io/jwt-auth-builder.go/callout"
+)
+
+func (t *ProviderSuite) Test_XKeyRotation() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+
+	t.Empty(a.XKey().Public())
+	_, err = a.XKey().KeyPair()
+	t.ErrorIs(err, authb.ErrNoXKey)
+
+	pk, err := a.XKey().Create()
+	t.NoError(err)
+	t.True(nkeys.IsValidPublicCurveKey(pk))
+	t.Equal(pk, a.XKey().Public())
+	_, err = a.XKey().Create()
+	t.Error(err)
+
+	server, err := nkeys.CreateCurveKeys()
+	t.NoError(err)
+	serverPk, err := server.PublicKey()
+	t.NoError(err)
+	sealed, err := server.Seal([]byte("hello"), pk)
+	t.NoError(err)
+
+	pk2, err := a.XKey().Rotate(time.Hour)
+	t.NoError(err)
+	t.NotEqual(pk, pk2)
+	t.Equal(pk2, a.XKey().Public())
+	retired := a.XKey().Retired()
+	t.Len(retired, 1)
+	t.Equal(pk, retired[0].Key)
+	t.Len(a.XKey().KeyPairs(), 2)
+
+	// payloads for the retired key can still be opened
+	d, err := a.XKey().Open(sealed, serverPk)
+	t.NoError(err)
+	t.Equal("hello", string(d))
+
+	// responses are sealed with the current key
+	sealed, err = a.XKey().Seal([]byte("world"), serverPk)
+	t.NoError(err)
+	d, err = server.Open(sealed, pk2)
+	t.NoError(err)
+	t.Equal("world", string(d))
+
+	t.NoError(auth.Commit())
+	t.True(t.Store.KeyExists(pk))
+	t.True(t.Store.KeyExists(pk2))
+
+	auth, err = authb.NewAuth(t.Provider)
+	t.NoError(err)
+	a = t.GetAccount(auth, "O", "A")
+	t.Equal(pk2, a.XKey().Public())
+	t.Equal(retired, a.XKey().Retired())
+	t.Len(a.XKey().KeyPairs(), 2)
+
+	// rotating without a grace period drops the previous key
+	pk3, err := a.XKey().Rotate(0)
+	t.NoError(err)
+	t.Len(a.XKey().Retired(), 1)
+	t.Len(a.XKey().KeyPairs(), 2)
+	t.NoError(auth.Commit())
+	t.False(t.Store.KeyExists(pk2))
+	t.Equal(pk3, a.XKey().Public())
+}
+
+func (t *ProviderSuite) Test_XKeyUnmanaged() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	a, err := o.Accounts().Add("A")
+	t.NoError(err)
+	u, err := a.Users().Add("service", "")
+	t.NoError(err)
+	xkey, err := nkeys.CreateCurveKeys()
+	t.NoError(err)
+	xpk, err := xkey.PublicKey()
+	t.NoError(err)
+	t.NoError(a.SetExternalAuthorizationUser([]interface{}{u}, nil, xpk))
+	t.Empty(a.XKey().Public())
+
+	// an encryption key that is not managed is only replaced explicitly
+	_, err = a.XKey().Create()
+	t.Error(err)
+	_, _, enc := a.ExternalAuthorization()
+	t.Equal(xpk, enc)
+
+	pk, err := a.XKey().Rotate(0)
+	t.NoError(err)
+	_, _, enc = a.ExternalAuthorization()
+	t.Equal(pk, enc)
+	t.Equal(pk, a.XKey().Public())
+}
+
+func (t *ProviderSuite) Test_CalloutManagedXKey() {
+	auth, err := authb.NewAuth(t.Provider)
+	t.NoError(err)
+	o, err := auth.Operators().Add("O")
+	t.NoError(err)
+	sys, err := o.Accounts().Add("SYS")
+	t.NoError(err)
+	t.NoError(o.SetSystemAccount(sys))
+
+	c, err := o.Accounts().Add("C")
+	t.NoError(err)
+	service, err := c.Users().Add("service", "")
+	t.NoError(err)
+	sentinel, err := c.Users().Add("sentinel", "")
+	t.NoError(err)
+	t.NoError(sentinel.SetBearerToken(true))
+	t.NoError(c.SetExternalAuthorizationUser([]interface{}{service}, nil, ""))
+	_, err = c.XKey().Create()
+	t.NoError(err)
+	_, _, xkey := c.ExternalAuthorization()
+	t.Equal(c.XKey().Public(), xkey)
+	t.NoError(auth.Commit())
+
+	ns := t.startOperatorServer(o)
+	defer ns.Shutdown()
+
+	authorizer := callout.AuthorizerFunc(func(req *jwt.AuthorizationRequestClaims) (*callout.Authorization, error) {
+		if req.ConnectOptions.Username == "alice" {
+			return &callout.Authorization{Name: "alice"}, nil
+		}
+		return nil, errors.New("bad credentials")
+	})
+	snc, err := ns.MaybeConnect(t.userCreds(service))
+	t.NoError(err)
+	svc, err := callout.New(snc, o, "C", authorizer, nil)
+	t.NoError(err)
+	defer func() {
+		t.NoError(svc.Stop())
+	}()
+
+	nc, err := ns.MaybeConnect(t.userCreds(sentinel), nats.UserInfo("alice", ""))
+	t.NoError(err)
+	t.NoError(nc.Flush())
+	nc.Close()
+}
@@ -3,9 +3,11 @@ package authb
 import (
 	"encoding/json"
 	"errors"
+	"io"
 	"time"
 
 	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
 )
 
 var ErrNotFound = errors.New("not found")
@@ -30,6 +32,20 @@ type AuthProvider interface {
 	Store(operators []*OperatorData) error
 }
 
+// KeyStore stores the seeds of the operators, accounts and users, so that
+// providers can keep the JWTs and the seeds in different places. Providers
+// store keys added to OperatorData.AddedKeys and remove OperatorData.DeletedKeys.
+type KeyStore interface {
+	// GetKey returns the key with the seed for the public key, or nil if
+	// the seed is not stored
+	GetKey(pk string) (*Key, error)
+	// PutKey stores the seed of the key. Keys without a seed are ignored.
+	PutKey(k *Key) error
+	// DeleteKey removes the seed for the public key, it is not an error if
+	// the seed is not stored
+	DeleteKey(pk string) error
+}
+
 // BaseData is shared across all entities
 type BaseData struct {
 	// Loaded matches the issue time of a loaded JWT (UTC in seconds). When
@@ -49,10 +65,53 @@ type BaseData struct {
 	// Token is the JWT for the entity, always kept up-to-date
 	// by the APIs
 	Token string `json:"token"`
+	// PreviousKey is the identity key the entity was stored under before its
+	// identity was rotated. AuthProviders remove the stale entries and clear it.
+	PreviousKey string `json:"-"`
+	// Metadata is library state for the entity that is not part of its JWT.
+	// AuthProviders store it alongside the entity, and it can be nil.
+	Metadata *EntityMetadata `json:"metadata,omitempty"`
 
 	readOnly bool
 }
 
+// EntityMetadata is state the library keeps for an entity that cannot be
+// represented in the entity's JWT
+type EntityMetadata struct {
+	// RetiredXKeys are previous xkeys that remain valid until they expire
+	RetiredXKeys []RetiredKey `json:"retired_xkeys,omitempty"`
+	// RetiringKeys are signing keys being replaced by a staged rotation
+	RetiringKeys []RetiringKey `json:"retiring_keys,omitempty"`
+	// Template is the account template the account was created from
+	Template *TemplateRef `json:"template,omitempty"`
+}
+
+// RetiringKey is a signing key that remains valid until its replacement
+// takes over at the end of a staged rotation
+type RetiringKey struct {
+	// Key is the public key being retired
+	Key string `json:"key"`
+	// Replacement is the public key of the new signing key
+	Replacement string `json:"replacement"`
+	// Deadline is the time (UTC in seconds) after which the rotation can complete
+	Deadline int64 `json:"deadline"`
+}
+
+// RotationStatus describes a staged signing key rotation that is in progress
+type RotationStatus struct {
+	RetiringKey
+	// Dependents are the names of the entities still issued by the retiring key
+	Dependents []string
+}
+
+// RetiredKey is a key that was rotated and remains valid until it expires
+type RetiredKey struct {
+	// Key is the public key
+	Key string `json:"key"`
+	// Expires is the time (UTC in seconds) when the key stops being valid
+	Expires int64 `json:"expires"`
+}
+
 type OperatorData struct {
 	BaseData
 	// OperatorSigningKeys is the list of all current signing keys for
@@ -73,6 +132,8 @@ type OperatorData struct {
 	DeletedKeys []string `json:"-"`
 
 	SigningService IssuingService `json:"-"`
+	// budget limits the size of the account JWTs, nil if unlimited
+	budget *sizeBudget
 }
 
 func (o *OperatorData) MarshalJSON() ([]byte, error) {
@@ -135,6 +196,12 @@ type AccountData struct {
 	UserDatas []*UserData `json:"users"`
 	// DeletedUsers is a list of users that will be deleted on the next commit
 	DeletedUsers []*UserData
+	// XKeys are the managed curve keys for the account, the current key and
+	// any retired keys that are still valid
+	XKeys []*Key `json:"-"`
+	// users indexes UserDatas by name and subject, built on demand and reset to
+	// nil whenever UserDatas change
+	users *userIndex
 }
 
 func (a *AccountData) MarshalJSON() ([]byte, error) {
@@ -162,6 +229,7 @@ func (a *AccountData) UnmarshalJSON(data []byte) error {
 	a.Modified = true
 	a.AccountSigningKeys = v.AccountsSigningKeys
 	a.UserDatas = v.Users
+	a.users = nil
 	for _, ud := range a.UserDatas {
 		ud.AccountData = a
 	}
@@ -218,6 +286,9 @@ type Operators interface {
 	Delete(name string) error
 	// Import an Operator from JWT bytes and keys
 	Import(jwt []byte, keys []string) (Operator, error)
+	// ImportBundle adds the Operator, accounts and users in a bundle created by
+	// ExportBundle. The key is the curve seed required to open sealed bundles.
+	ImportBundle(bundle []byte, key string) (Operator, error)
 }
 
 type Tags interface {
@@ -265,6 +336,9 @@ type Operator interface {
 	Tags() Tags
 	// IssueClaim issues the specified jwt.Claim using the specified operator key
 	IssueClaim(claim jwt.Claims, key string) (string, error)
+	// RotateIdentity replaces the operator's identity key, re-issuing the operator and
+	// the accounts it issued. It returns the migration plan and the artifacts to deploy.
+	RotateIdentity() (*IdentityRotation, error)
 }
 
 // Accounts is an interface for managing accounts
@@ -277,6 +351,16 @@ type Accounts interface {
 	Get(name string) (Account, error)
 	// List returns a list of Account
 	List() []Account
+	// AddFromTemplate creates a new Account with the specified name configured by
+	// the template. Parameters without a value use the template defaults.
+	AddFromTemplate(name string, tmpl *AccountTemplate, params map[string]string) (Account, error)
+	// ApplyTemplate re-applies the template to the accounts created from an older
+	// version of it, using the parameters they were created with. It returns the
+	// accounts that were updated. The template is expanded for all the accounts
+	// first, so that an undefined parameter or an invalid limit fails before any
+	// account is modified, and if applying it fails on any account all the accounts
+	// are restored.
+	ApplyTemplate(tmpl *AccountTemplate) ([]Account, error)
 }
 
 type TracingContext struct {
@@ -339,6 +423,16 @@ type Account interface {
 	// if the users value is nil, ExternalAuthorization is not enabled
 	ExternalAuthorization() ([]string, []string, string)
 
+	// XKey returns an interface for managing the curve key used to encrypt auth callout
+	// requests and responses
+	XKey() XKeys
+	// RevocationManager returns an interface for revoking groups of users and activations
+	RevocationManager() RevocationManager
+	// RotateIdentity replaces the account's identity key, re-issuing the account, its users,
+	// and the imports and activations that reference the previous ID. It returns the
+	// migration plan and the artifacts to deploy.
+	RotateIdentity() (*IdentityRotation, error)
+
 	// IssueAuthorizationResponse generates a signed JWT token for an AuthorizationResponseClaims using the specified key.
 	IssueAuthorizationResponse(claim *jwt.AuthorizationResponseClaims, key string) (string, error)
 	// IssueClaim issues the specified jwt.Claim using the specified account key
@@ -354,6 +448,15 @@ type Account interface {
 	// An empty string or "system" denotes traffic through the system account (the default).
 	// "owner" denotes traffic through this account.
 	ClusterTraffic() string
+	// Template returns the template the account was created from, or nil
+	Template() *TemplateRef
+	// CloneTo copies the configuration of the account to a new account with the
+	// specified name in the operator. Imports of accounts listed in the options are
+	// rewritten to the replacement accounts.
+	CloneTo(operator Operator, name string, opts *CloneOptions) (Account, error)
+	// Restore re-issues the account with the configuration of an earlier JWT of
+	// the account. The name, issuer, signing keys, xkey and revocations are kept.
+	Restore(token string) error
 }
 
 type SubjectMappings interface {
@@ -392,8 +495,33 @@ type Users interface {
 	AddWithIdentity(name string, signer string, id string) (User, error)
 	// ImportEphemeral imports an ephemeral user from a claim
 	ImportEphemeral(c *jwt.UserClaims, key string) (User, error)
+	// AddWithRole creates a new scoped user with the specified name, issued by
+	// a scoped signing key with the specified role, and returns its creds. Options
+	// select the scope when several share the role, and set the user's tags and expiry.
+	AddWithRole(name string, role string, opts *RoleOptions) (User, []byte, error)
+	// AddRoster provisions all the users in the roster by role. If any of the users
+	// cannot be provisioned, none of the users are added.
+	AddRoster(entries []RosterEntry, strategy ScopeStrategy) ([]ProvisionedUser, error)
 	// Delete the user by matching its name or subject
 	Delete(name string) error
+	// AddMany creates the users in one pass. Users that could not be created
+	// are reported in a *BatchError, all other users are added.
+	AddMany(specs []UserSpec) ([]User, error)
+	// DeleteMany deletes the users matching the names or subjects in one pass.
+	// Names that didn't match a user are reported in a *BatchError.
+	DeleteMany(names ...string) error
+	// ReissueMany re-signs the users matching the names or subjects, or all users
+	// if no names are specified. If expiry is greater than zero, the users will
+	// expire after the specified duration. Failures are reported in a *BatchError.
+	ReissueMany(expiry time.Duration, names ...string) error
+	// EachCreds generates the creds for the named users, or all users if no names
+	// are specified, and calls fn for each of them. Errors returned by fn stop the
+	// iteration, while users that cannot generate creds are reported in a *BatchError.
+	EachCreds(expiry time.Duration, fn func(u User, creds []byte) error, names ...string) error
+	// ExportCreds streams the creds for the named users, or all users if no names are
+	// specified, into an archive written to w. Users that cannot generate creds are
+	// reported in a *BatchError.
+	ExportCreds(w io.Writer, format CredsArchive, expiry time.Duration, names ...string) error
 	// Get returns the user by matching its name or subject
 	Get(name string) (User, error)
 	// List returns a list of User from the account
@@ -426,6 +554,17 @@ type User interface {
 	JWT() string
 	// Tags returns an object that you can use to manage tags for the account
 	Tags() Tags
+	// EffectivePermissions returns the permissions and limits the server will
+	// assign to the user. For scoped users, the scope's template is used, and
+	// permission templates such as {{name()}} or {{tag(name)}} are expanded.
+	// An error is returned if the server would reject the user's templates.
+	EffectivePermissions() (*EffectivePermissions, error)
+	// CloneTo copies the permissions, limits and tags of the user to a new user
+	// with the specified name in the account
+	CloneTo(account Account, name string) (User, error)
+	// Restore re-issues the user with the permissions, limits and tags of an
+	// earlier JWT of the user. The name and issuer are kept.
+	Restore(token string) error
 
 	UserLimits
 }
@@ -624,7 +763,8 @@ type RevocationEntry interface {
 
 type Revocations interface {
 	// Add revoke the specified nkey for credentials issued on the specified date or earlier
-	//  The special `*` key targets all entities
+	//  The special `*` key targets all entities. If the update fails, for example
+	//  because the account exceeds its size budget, the revocation is not applied.
 	Add(key string, at time.Time) error
 	// Delete deletes the specified nkey from the revocation list
 	Delete(key string) (bool, error)
@@ -636,6 +776,30 @@ type Revocations interface {
 	Set(revocations []RevocationEntry) error
 	// Contains returns true if the public key or "*" is in the revocation list
 	Contains(key string) (bool, error)
+	// Prune removes the revocations for users whose stored JWT has expired. It
+	// assumes the stored JWT is the longest-lived one issued to the user, creds
+	// that outlive it, such as those from User.Creds with a longer expiry, are
+	// valid again once their revocation is pruned.
+	Prune() ([]RevocationEntry, error)
+	// Stats returns the number of entries, the size and the oldest entry of the list
+	Stats() RevocationStats
+}
+
+// RevocationManager revokes groups of entities in one operation. The revocations
+// apply to credentials issued at or before the specified time, or now if the time
+// is zero. Redundant revocations are compacted.
+type RevocationManager interface {
+	// RevokeSigningKey replaces the specified signing key with a new key that has
+	// the same scope, invalidating all the users it issued, including users that
+	// are not stored. Stored users issued at or before the time are revoked, and
+	// the others are reissued by the new key.
+	RevokeSigningKey(key string, at time.Time) (*RevocationReport, error)
+	// RevokeTag revokes the stored users with the specified tag. Users that are
+	// not stored, such as ephemeral users, are not revoked, see RevocationReport.StoredOnly.
+	RevokeTag(tag string, at time.Time) (*RevocationReport, error)
+	// RevokeImporter revokes the activations issued to the specified account by
+	// name or public key on all the exports that require an activation
+	RevokeImporter(account string, at time.Time) (*RevocationReport, error)
 }
 
 type Revocable interface {
@@ -737,7 +901,18 @@ type Keys interface {
 	// reissue entities that were issued by the old key. Note that if the account is
 	// deployed, users issued by the old key will not be able to connect until handed
 	// new credentials. Rotate is a mechanism for invalidating a signing key and reissuing.
+	// For a rotation that keeps deployed entities working, use StartRotation.
 	Rotate(string) (string, error)
+	// StartRotation begins a staged rotation of the specified signing key. A new signing
+	// key is added and used instead of the old key to issue new entities, while entities
+	// issued by the old key remain valid until the rotation completes.
+	StartRotation(key string, overlap time.Duration) (string, error)
+	// CompleteRotation reissues the entities still issued by the retiring key with the new
+	// key and then removes the retiring key. It fails if the deadline was not reached,
+	// unless forced, and leaves the entities unchanged if one of them can't be reissued.
+	CompleteRotation(key string, force bool) (string, error)
+	// Retiring returns the staged rotations in progress and what still depends on them
+	Retiring() []RotationStatus
 	// List returns a list of signing keys
 	List() []string
 }
@@ -767,6 +942,41 @@ type ScopeLimits interface {
 	Description() string
 	// SetDescription sets an user-assigned description associated with the scope.
 	SetDescription(description string) error
+	// ValidateTemplates checks the permission templates in the scope, returning
+	// errors for malformed templates and references to account tags that the
+	// account doesn't have.
+	ValidateTemplates() error
+	// PreviewTemplates expands the scope's permission templates for the specified
+	// user as if the user had been issued by the scope.
+	PreviewTemplates(u User) (*EffectivePermissions, error)
+}
+
+// XKeys is an interface for managing the account's curve key (xkey). The public
+// key of the current xkey is set as the auth callout encryption key.
+type XKeys interface {
+	// Public returns the public key of the current managed xkey, or an empty string
+	// if the encryption key of the account is not managed
+	Public() string
+	// Create creates a new managed xkey, and sets it as the encryption key for the account.
+	// It fails if the account already has a managed xkey, or an encryption key
+	// that is not managed.
+	Create() (string, error)
+	// Rotate creates a new xkey and sets it as the encryption key for the account,
+	// replacing an encryption key that is not managed. The previous managed key
+	// can still open payloads for the grace period, after which it is deleted by
+	// a later rotation.
+	Rotate(grace time.Duration) (string, error)
+	// KeyPair returns the current xkey
+	KeyPair() (nkeys.KeyPair, error)
+	// KeyPairs returns the current xkey followed by retired keys that are still valid
+	KeyPairs() []nkeys.KeyPair
+	// Retired returns the keys that were rotated
+	Retired() []RetiredKey
+	// Seal encrypts the data for the recipient's public curve key using the current xkey
+	Seal(data []byte, recipient string) ([]byte, error)
+	// Open decrypts data sealed by the sender for the current xkey or a retired key
+	// that is still valid
+	Open(data []byte, sender string) ([]byte, error)
 }
 
 // ConnectionTypes is an interface for managing connection types that the connection
@@ -911,6 +1121,17 @@ type ScopedKeys interface {
 	// Rotate the specified key with a new one. The old key is deleted and the new key
 	// is used to reissue any entities that were issued by the old key.
 	Rotate(string) (string, error)
+	// StartRotation begins a staged rotation of the specified signing key. A new key with
+	// a copy of the scope is added and used instead of the old key to issue new users,
+	// while users issued by the old key remain valid until the rotation completes.
+	StartRotation(key string, overlap time.Duration) (string, error)
+	// CompleteRotation reissues the users still issued by the retiring key with the new
+	// key and then removes the retiring key. It fails if the deadline was not reached,
+	// unless forced, and leaves the users unchanged if one of them can't be reissued.
+	CompleteRotation(key string, force bool) (string, error)
+	// Retiring returns the staged rotations in progress and the users still issued
+	// by the retiring keys
+	Retiring() []RotationStatus
 	// AddScope creates a new scope with the specified role, and associates it with
 	// a new signing key.
 	AddScope(role string) (ScopeLimits, error)
@@ -919,7 +1140,11 @@ type ScopedKeys interface {
 	GetScope(string) (ScopeLimits, error)
 	// GetScopeByRole returns the first scope that matches the specified role.
 	// Note that the search must be an exact match of the scope role, and
+	// scopes of keys retiring in a staged rotation are not returned.
 	GetScopeByRole(string) ([]ScopeLimits, error)
+	// SelectScope returns the scope the strategy selects to issue users with the
+	// specified role, skipping keys retiring in a staged rotation
+	SelectScope(role string, strategy ScopeStrategy) (ScopeLimits, error)
 	// List returns a list of signing keys
 	List() []string
 	// ListRoles returns the names of roles associated with the account
@@ -1,7 +1,7 @@
 package authb
 
 import (
-	"fmt"
+	"errors"
 	"time"
 
 	"github.com/nats-io/jwt/v2"
@@ -16,6 +16,9 @@ func (u *UserData) Name() string {
 }
 
 func (u *UserData) IsScoped() bool {
+	if u.AccountData == nil {
+		return false
+	}
 	_, ok := u.AccountData.Claim.SigningKeys.GetScope(u.Claim.Issuer)
 	return ok
 }
@@ -35,8 +38,10 @@ func (u *UserData) issue(key *Key) error {
 }
 
 func (u *UserData) update() error {
-	if u.BaseData.readOnly {
-		return fmt.Errorf("account is read-only")
+	if err := u.checkReadOnly("user"); err != nil {
+		// discard the edit
+		u.Claim, _ = jwt.DecodeUserClaims(u.Token)
+		return err
 	}
 
 	issuer := u.Claim.Issuer
@@ -188,6 +193,13 @@ func (u *UserData) UserPermissionLimits() jwt.UserPermissionLimits {
 }
 
 func (u *UserData) Creds(expiry time.Duration) ([]byte, error) {
+	// scoped users get their permissions from the scope, so make sure
+	// the scope's templates expand to something the server will accept
+	if scope := u.userScope(); scope != nil {
+		if _, err := u.EffectivePermissions(); err != nil {
+			return nil, err
+		}
+	}
 	// remember the current configuration
 	token := u.Token
 	if expiry > 0 {
@@ -202,6 +214,9 @@ func (u *UserData) Creds(expiry time.Duration) ([]byte, error) {
 			return nil, err
 		}
 	}
+	if u.Key == nil || u.Key.Seed == nil {
+		return nil, errors.New("user seed is not available")
+	}
 	return jwt.FormatUserConfig(u.Token, u.Key.Seed)
 }
 
@@ -10,6 +10,9 @@ type UsersImpl struct {
 }
 
 func (a *UsersImpl) Add(name string, key string) (User, error) {
+	if err := a.accountData.checkReadOnly("account"); err != nil {
+		return nil, err
+	}
 	uk, err := a.accountData.Operator.SigningService.NewKey(nkeys.PrefixByteUser)
 	if err != nil {
 		return nil, err
@@ -18,9 +21,13 @@ func (a *UsersImpl) Add(name string, key string) (User, error) {
 }
 
 func (a *UsersImpl) ImportEphemeral(c *jwt.UserClaims, key string) (User, error) {
+	if err := a.accountData.checkReadOnly("account"); err != nil {
+		return nil, err
+	}
 	if key == "" {
 		key = a.accountData.Key.Public
 	}
+	key = a.accountData.replacement(key)
 	k, signingKey, err := a.accountData.getKey(key)
 	if err != nil {
 		return nil, err
@@ -51,6 +58,7 @@ func (a *UsersImpl) ImportEphemeral(c *jwt.UserClaims, key string) (User, error)
 		return nil, err
 	}
 	a.accountData.UserDatas = append(a.accountData.UserDatas, d)
+	a.accountData.users = nil
 	return d, nil
 }
 
@@ -58,6 +66,7 @@ func (a *UsersImpl) add(name string, key string, uk *Key) (User, error) {
 	if key == "" {
 		key = a.accountData.Key.Public
 	}
+	key = a.accountData.replacement(key)
 	k, signingKey, err := a.accountData.getKey(key)
 	if err != nil {
 		return nil, err
@@ -85,6 +94,7 @@ func (a *UsersImpl) add(name string, key string, uk *Key) (User, error) {
 		return nil, err
 	}
 	a.accountData.UserDatas = append(a.accountData.UserDatas, d)
+	a.accountData.users = nil
 	if !d.Ephemeral {
 		a.accountData.Operator.AddedKeys = append(a.accountData.Operator.AddedKeys, uk)
 	}
@@ -100,10 +110,8 @@ func (a *UsersImpl) AddWithIdentity(name string, key string, id string) (User, e
 }
 
 func (a *UsersImpl) Get(name string) (User, error) {
-	for _, u := range a.accountData.UserDatas {
-		if u.EntityName == name || u.Claim.Subject == name {
-			return u, nil
-		}
+	if u := a.accountData.userIndex().get(name); u != nil {
+		return u, nil
 	}
 	return nil, ErrNotFound
 }
@@ -117,12 +125,50 @@ func (a *UsersImpl) List() []User {
 }
 
 func (a *UsersImpl) Delete(name string) error {
-	for idx, u := range a.accountData.UserDatas {
-		if u.EntityName == name || u.Claim.Subject == name {
+	if err := a.accountData.checkReadOnly("account"); err != nil {
+		return err
+	}
+	a.deleteUsers(map[string]bool{name: true})
+	return nil
+}
+
+// deleteUsers removes all the users with a name or subject in the set in one pass
+func (a *UsersImpl) deleteUsers(names map[string]bool) []*UserData {
+	// users added since the last commit have not been stored, so they are simply forgotten
+	added := make(map[*Key]bool, len(a.accountData.Operator.AddedKeys))
+	for _, k := range a.accountData.Operator.AddedKeys {
+		added[k] = true
+	}
+	var deleted []*UserData
+	kept := a.accountData.UserDatas[:0]
+	for _, u := range a.accountData.UserDatas {
+		if names[u.EntityName] || names[u.Claim.Subject] {
+			deleted = append(deleted, u)
+			if added[u.Key] {
+				continue
+			}
 			a.accountData.DeletedUsers = append(a.accountData.DeletedUsers, u)
-			a.accountData.UserDatas = append(a.accountData.UserDatas[:idx], a.accountData.UserDatas[idx+1:]...)
 			a.accountData.Operator.DeletedKeys = append(a.accountData.Operator.DeletedKeys, u.Key.Public)
+			continue
 		}
+		kept = append(kept, u)
 	}
-	return nil
+	a.accountData.UserDatas = kept
+	if len(added) > 0 && len(deleted) > 0 {
+		forget := make(map[*Key]bool, len(deleted))
+		for _, u := range deleted {
+			forget[u.Key] = true
+		}
+		keys := a.accountData.Operator.AddedKeys[:0]
+		for _, k := range a.accountData.Operator.AddedKeys {
+			if !forget[k] {
+				keys = append(keys, k)
+			}
+		}
+		a.accountData.Operator.AddedKeys = keys
+	}
+	if len(deleted) > 0 {
+		a.accountData.users = nil
+	}
+	return deleted
 }
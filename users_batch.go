@@ -0,0 +1,278 @@
+package authb
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// userIndex is a lookup table of the account users by name and subject
+type userIndex struct {
+	byName    map[string]*UserData
+	bySubject map[string]*UserData
+}
+
+func (ui *userIndex) get(name string) *UserData {
+	if u, ok := ui.bySubject[name]; ok {
+		return u
+	}
+	return ui.byName[name]
+}
+
+// userIndex returns the index of the users, building it if needed. Code that
+// changes UserDatas must invalidate the index by setting a.users to nil.
+func (a *AccountData) userIndex() *userIndex {
+	if a.users != nil {
+		return a.users
+	}
+	ui := &userIndex{
+		byName:    make(map[string]*UserData, len(a.UserDatas)),
+		bySubject: make(map[string]*UserData, len(a.UserDatas)),
+	}
+	for _, u := range a.UserDatas {
+		// the first user with a name wins, same as a linear search
+		if _, ok := ui.byName[u.EntityName]; !ok {
+			ui.byName[u.EntityName] = u
+		}
+		ui.bySubject[u.Claim.Subject] = u
+	}
+	a.users = ui
+	return ui
+}
+
+// BatchItemError is the error for one entry in a batch operation
+type BatchItemError struct {
+	// Name of the user, or the subject if the user has no name
+	Name string
+	Err  error
+}
+
+func (e BatchItemError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+func (e BatchItemError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError aggregates the errors of a batch operation. Entries that
+// are not listed were processed successfully.
+type BatchError struct {
+	Errors []BatchItemError
+}
+
+func (e *BatchError) Error() string {
+	buf := make([]string, len(e.Errors))
+	for i, v := range e.Errors {
+		buf[i] = v.Error()
+	}
+	return fmt.Sprintf("%d batch operation(s) failed: %s", len(e.Errors), strings.Join(buf, "; "))
+}
+
+func (e *BatchError) Unwrap() []error {
+	buf := make([]error, len(e.Errors))
+	for i, v := range e.Errors {
+		buf[i] = v
+	}
+	return buf
+}
+
+func (e *BatchError) add(name string, err error) {
+	e.Errors = append(e.Errors, BatchItemError{Name: name, Err: err})
+}
+
+// err returns nil if no errors were added
+func (e *BatchError) err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// UserSpec describes a user created by a batch
+type UserSpec struct {
+	Name string
+	// Signer is the public key of the signing key issuing the user, if
+	// empty the account's key is used
+	Signer string
+	Tags   []string
+	// Expiry of the user, if zero the user doesn't expire
+	Expiry time.Duration
+}
+
+func (a *UsersImpl) AddMany(specs []UserSpec) ([]User, error) {
+	var added []User
+	report := &BatchError{}
+	for _, spec := range specs {
+		if err := NotEmpty(spec.Name); err != nil {
+			report.add(spec.Name, err)
+			continue
+		}
+		u, err := a.Add(spec.Name, spec.Signer)
+		if err != nil {
+			report.add(spec.Name, err)
+			continue
+		}
+		ud := u.(*UserData)
+		if len(spec.Tags) > 0 || spec.Expiry > 0 {
+			ud.Claim.Tags.Add(spec.Tags...)
+			if spec.Expiry > 0 {
+				ud.Claim.Expires = time.Now().Add(spec.Expiry).Unix()
+			}
+			if err := ud.update(); err != nil {
+				a.rollback(ud)
+				report.add(spec.Name, err)
+				continue
+			}
+		}
+		added = append(added, ud)
+	}
+	return added, report.err()
+}
+
+func (a *UsersImpl) DeleteMany(names ...string) error {
+	if err := a.accountData.checkReadOnly("account"); err != nil {
+		return err
+	}
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[n] = true
+	}
+	report := &BatchError{}
+	index := a.accountData.userIndex()
+	for _, n := range names {
+		if index.get(n) == nil {
+			report.add(n, ErrNotFound)
+		}
+	}
+	a.deleteUsers(set)
+	return report.err()
+}
+
+func (a *UsersImpl) ReissueMany(expiry time.Duration, names ...string) error {
+	users, report := a.selectUsers(names)
+	for _, u := range users {
+		if expiry > 0 {
+			u.Claim.Expires = time.Now().Add(expiry).Unix()
+		}
+		if err := u.update(); err != nil {
+			report.add(u.batchName(), err)
+		}
+	}
+	return report.err()
+}
+
+// selectUsers returns the named users, or all the users if no names are specified
+func (a *UsersImpl) selectUsers(names []string) ([]*UserData, *BatchError) {
+	report := &BatchError{}
+	if len(names) == 0 {
+		return append([]*UserData(nil), a.accountData.UserDatas...), report
+	}
+	index := a.accountData.userIndex()
+	users := make([]*UserData, 0, len(names))
+	for _, n := range names {
+		u := index.get(n)
+		if u == nil {
+			report.add(n, ErrNotFound)
+			continue
+		}
+		users = append(users, u)
+	}
+	return users, report
+}
+
+func (u *UserData) batchName() string {
+	if u.EntityName != "" {
+		return u.EntityName
+	}
+	return u.Claim.Subject
+}
+
+func (a *UsersImpl) EachCreds(expiry time.Duration, fn func(u User, creds []byte) error, names ...string) error {
+	users, report := a.selectUsers(names)
+	for _, u := range users {
+		if u.Key == nil || u.Key.Seed == nil {
+			report.add(u.batchName(), errors.New("user has no seed"))
+			continue
+		}
+		creds, err := u.Creds(expiry)
+		if err != nil {
+			report.add(u.batchName(), err)
+			continue
+		}
+		if err := fn(u, creds); err != nil {
+			return err
+		}
+	}
+	return report.err()
+}
+
+// CredsArchive is the format of a creds archive
+type CredsArchive string
+
+const (
+	CredsTar CredsArchive = "tar"
+	CredsZip CredsArchive = "zip"
+)
+
+// credsFileName returns a file name for the user's creds that is unique in the archive
+func credsFileName(u User, used map[string]bool) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', 0:
+			return '_'
+		}
+		return r
+	}, u.Name())
+	if name == "" || used[name] {
+		name = fmt.Sprintf("%s-%s", name, u.Subject())
+	}
+	used[name] = true
+	return name + ".creds"
+}
+
+func (a *UsersImpl) ExportCreds(w io.Writer, format CredsArchive, expiry time.Duration, names ...string) error {
+	used := make(map[string]bool)
+	switch format {
+	case CredsTar:
+		tw := tar.NewWriter(w)
+		now := time.Now()
+		err := a.EachCreds(expiry, func(u User, creds []byte) error {
+			hdr := &tar.Header{
+				Name:    credsFileName(u, used),
+				Mode:    0o600,
+				Size:    int64(len(creds)),
+				ModTime: now,
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err := tw.Write(creds)
+			return err
+		}, names...)
+		if cerr := tw.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		return err
+	case CredsZip:
+		zw := zip.NewWriter(w)
+		err := a.EachCreds(expiry, func(u User, creds []byte) error {
+			fw, err := zw.Create(credsFileName(u, used))
+			if err != nil {
+				return err
+			}
+			_, err = fw.Write(creds)
+			return err
+		}, names...)
+		if cerr := zw.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		return err
+	default:
+		return fmt.Errorf("unsupported creds archive format %q", format)
+	}
+}
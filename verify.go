@@ -0,0 +1,281 @@
+package authb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+)
+
+// TrustIssue is a broken link in the chain of trust of a store
+type TrustIssue struct {
+	// Operator is the name of the operator
+	Operator string
+	// Account is the name of the account, empty for operator issues
+	Account string
+	// User is the name of the user, empty for operator and account issues
+	User string
+	// Import is the name of the import for activation issues
+	Import string
+	// Subject is the public key of the entity or key with the issue
+	Subject string
+	// Problem describes the issue
+	Problem string
+}
+
+func (i TrustIssue) String() string {
+	path := []string{fmt.Sprintf("operator %q", i.Operator)}
+	if i.Account != "" {
+		path = append(path, fmt.Sprintf("account %q", i.Account))
+	}
+	if i.User != "" {
+		path = append(path, fmt.Sprintf("user %q", i.User))
+	}
+	if i.Import != "" {
+		path = append(path, fmt.Sprintf("import %q", i.Import))
+	}
+	return fmt.Sprintf("%s: %s (%s)", strings.Join(path, " > "), i.Problem, i.Subject)
+}
+
+// TrustReport is the result of Verify
+type TrustReport struct {
+	Issues []TrustIssue
+}
+
+// OK returns true if no issues were found
+func (r *TrustReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// Err returns an error listing the issues, or nil if no issues were found
+func (r *TrustReport) Err() error {
+	if r.OK() {
+		return nil
+	}
+	errs := make([]error, len(r.Issues))
+	for i, issue := range r.Issues {
+		errs[i] = errors.New(issue.String())
+	}
+	return errors.Join(errs...)
+}
+
+// verifier walks the entities of an operator recording the issues found
+type verifier struct {
+	report   *TrustReport
+	now      time.Time
+	operator *OperatorData
+	account  *AccountData
+	user     *UserData
+	imp      string
+}
+
+// Verify walks the operators, accounts, users and activations of the Auth and
+// reports every broken link in their chain of trust: invalid signatures,
+// issuers that are not valid signers, missing seeds, and JWTs that are not
+// yet valid or that have expired.
+func Verify(auth Auth) *TrustReport {
+	v := &verifier{report: &TrustReport{}, now: time.Now()}
+	for _, op := range auth.Operators().List() {
+		if o, ok := op.(*OperatorData); ok {
+			v.verifyOperator(o)
+		}
+	}
+	return v.report
+}
+
+func (v *verifier) add(subject string, format string, args ...any) {
+	issue := TrustIssue{
+		Operator: v.operator.EntityName,
+		Import:   v.imp,
+		Subject:  subject,
+		Problem:  fmt.Sprintf(format, args...),
+	}
+	if v.account != nil {
+		issue.Account = v.account.EntityName
+	}
+	if v.user != nil {
+		issue.User = v.user.EntityName
+	}
+	v.report.Issues = append(v.report.Issues, issue)
+}
+
+// checkTimes reports JWTs issued in the future or that have expired
+func (v *verifier) checkTimes(subject string, c *jwt.ClaimsData) {
+	if c.IssuedAt > v.now.Unix() {
+		v.add(subject, "JWT is issued in the future at %s", time.Unix(c.IssuedAt, 0).UTC().Format(time.RFC3339))
+	}
+	if c.Expires > 0 && c.Expires <= v.now.Unix() {
+		v.add(subject, "JWT expired at %s", time.Unix(c.Expires, 0).UTC().Format(time.RFC3339))
+	}
+}
+
+// checkKey reports identity keys that don't match the subject or that are
+// missing their seed, as the entity can't be edited without it
+func (v *verifier) checkKey(k *Key, subject string) {
+	switch {
+	case k == nil:
+		v.add(subject, "identity key is missing")
+	case k.Public != subject:
+		v.add(subject, "identity key %s doesn't match the subject", k.Public)
+	case k.Seed == nil:
+		v.add(subject, "identity key seed is missing")
+	}
+}
+
+// checkSigningKeys reports signing keys in the claim whose seeds are missing,
+// and keys that are no longer in the claim
+func (v *verifier) checkSigningKeys(claimed []string, keys []*Key) {
+	loaded := make(map[string]*Key, len(keys))
+	for _, k := range keys {
+		if k != nil {
+			loaded[k.Public] = k
+		}
+	}
+	listed := make(map[string]bool, len(claimed))
+	for _, pk := range claimed {
+		listed[pk] = true
+		if k := loaded[pk]; k == nil || k.Seed == nil {
+			v.add(pk, "signing key seed is missing")
+		}
+	}
+	for _, k := range keys {
+		if k != nil && !listed[k.Public] {
+			v.add(k.Public, "signing key is not in the JWT")
+		}
+	}
+}
+
+// checkRetiring reports staged rotations whose keys are not in the claim
+func (v *verifier) checkRetiring(md *EntityMetadata, contains func(pk string) bool) {
+	if md == nil {
+		return
+	}
+	for _, rk := range md.RetiringKeys {
+		if !contains(rk.Key) {
+			v.add(rk.Key, "retiring signing key is not in the JWT")
+		}
+		if !contains(rk.Replacement) {
+			v.add(rk.Replacement, "replacement for signing key %s is not in the JWT", rk.Key)
+		}
+	}
+}
+
+func (v *verifier) verifyOperator(o *OperatorData) {
+	v.operator, v.account, v.user, v.imp = o, nil, nil, ""
+	oc, err := jwt.DecodeOperatorClaims(o.Token)
+	if err != nil {
+		v.add(o.Subject(), "JWT is invalid: %v", err)
+		return
+	}
+	if oc.Issuer != oc.Subject && !oc.SigningKeys.Contains(oc.Issuer) {
+		v.add(oc.Subject, "JWT is issued by %s which is not the operator or one of its signing keys", oc.Issuer)
+	}
+	v.checkTimes(oc.Subject, &oc.ClaimsData)
+	v.checkKey(o.Key, oc.Subject)
+	v.checkSigningKeys(oc.SigningKeys, o.OperatorSigningKeys)
+	v.checkRetiring(o.Metadata, oc.SigningKeys.Contains)
+	if oc.SystemAccount != "" && o.accountData(oc.SystemAccount) == nil {
+		v.add(oc.SystemAccount, "system account was not found")
+	}
+	for _, a := range o.AccountDatas {
+		v.verifyAccount(oc, a)
+	}
+}
+
+func (v *verifier) verifyAccount(oc *jwt.OperatorClaims, a *AccountData) {
+	v.account, v.user, v.imp = a, nil, ""
+	ac, err := jwt.DecodeAccountClaims(a.Token)
+	if err != nil {
+		v.add(a.Subject(), "JWT is invalid: %v", err)
+		return
+	}
+	if ac.Issuer != oc.Subject && !oc.SigningKeys.Contains(ac.Issuer) {
+		v.add(ac.Subject, "JWT is issued by %s which is not the operator or one of its signing keys", ac.Issuer)
+	}
+	v.checkTimes(ac.Subject, &ac.ClaimsData)
+	v.checkKey(a.Key, ac.Subject)
+	v.checkSigningKeys(ac.SigningKeys.Keys(), a.AccountSigningKeys)
+	v.checkRetiring(a.Metadata, func(pk string) bool {
+		_, ok := ac.SigningKeys[pk]
+		return ok
+	})
+	for _, u := range a.UserDatas {
+		v.verifyUser(ac, u)
+	}
+	v.user = nil
+	for _, imp := range ac.Imports {
+		v.verifyImport(ac, imp)
+	}
+	v.imp = ""
+}
+
+func (v *verifier) verifyUser(ac *jwt.AccountClaims, u *UserData) {
+	v.user = u
+	uc, err := jwt.DecodeUserClaims(u.Token)
+	if err != nil {
+		v.add(u.Subject(), "JWT is invalid: %v", err)
+		return
+	}
+	if uc.Issuer == ac.Subject {
+		if uc.IssuerAccount != "" && uc.IssuerAccount != ac.Subject {
+			v.add(uc.Subject, "issuer account %s doesn't match the account", uc.IssuerAccount)
+		}
+	} else {
+		if _, ok := ac.SigningKeys[uc.Issuer]; !ok {
+			v.add(uc.Subject, "JWT is issued by %s which is not the account or one of its signing keys", uc.Issuer)
+		}
+		if uc.IssuerAccount != ac.Subject {
+			v.add(uc.Subject, "issuer account %q doesn't match the account", uc.IssuerAccount)
+		}
+	}
+	v.checkTimes(uc.Subject, &uc.ClaimsData)
+	if !u.Ephemeral {
+		v.checkKey(u.Key, uc.Subject)
+	}
+}
+
+func (v *verifier) verifyImport(ac *jwt.AccountClaims, imp *jwt.Import) {
+	v.imp = imp.Name
+	exporter := v.operator.accountData(imp.Account)
+	if exporter == nil {
+		v.add(imp.Account, "exporting account was not found")
+		return
+	}
+	var export *jwt.Export
+	for _, e := range exporter.Claim.Exports {
+		if e.Type == imp.Type && imp.Subject.IsContainedIn(e.Subject) {
+			export = e
+			break
+		}
+	}
+	if export == nil {
+		v.add(imp.Account, "exporting account has no %s export for %q", imp.Type, imp.Subject)
+		return
+	}
+	if imp.Token == "" {
+		if export.TokenReq {
+			v.add(imp.Account, "export %q requires an activation token", export.Subject)
+		}
+		return
+	}
+	act, err := jwt.DecodeActivationClaims(imp.Token)
+	if err != nil {
+		v.add(imp.Account, "activation is invalid: %v", err)
+		return
+	}
+	if act.Subject != ac.Subject && act.Subject != jwt.All {
+		v.add(act.Subject, "activation is for another account")
+	}
+	switch {
+	case act.Issuer == exporter.Subject():
+	case act.IssuerAccount != exporter.Subject():
+		v.add(act.Issuer, "activation is not issued by the exporting account")
+	default:
+		if _, ok := exporter.Claim.SigningKeys[act.Issuer]; !ok {
+			v.add(act.Issuer, "activation is issued by a key that is not a signing key of the exporting account")
+		}
+	}
+	v.checkTimes(act.Subject, &act.ClaimsData)
+}
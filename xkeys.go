@@ -0,0 +1,160 @@
+package authb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nkeys"
+)
+
+// ErrNoXKey is returned when the account doesn't have a managed xkey
+var ErrNoXKey = errors.New("account doesn't have a managed xkey")
+
+type accountXKeys struct {
+	data *AccountData
+}
+
+func (a *AccountData) XKey() XKeys {
+	return &accountXKeys{data: a}
+}
+
+func (x *accountXKeys) find(pk string) *Key {
+	if pk == "" {
+		return nil
+	}
+	for _, k := range x.data.XKeys {
+		if k.Public == pk {
+			return k
+		}
+	}
+	return nil
+}
+
+func (x *accountXKeys) Public() string {
+	pk := x.data.Claim.Authorization.XKey
+	if x.find(pk) == nil {
+		return ""
+	}
+	return pk
+}
+
+func (x *accountXKeys) KeyPair() (nkeys.KeyPair, error) {
+	k := x.find(x.data.Claim.Authorization.XKey)
+	if k == nil {
+		return nil, ErrNoXKey
+	}
+	return k.Pair, nil
+}
+
+func (x *accountXKeys) Retired() []RetiredKey {
+	if x.data.Metadata == nil {
+		return nil
+	}
+	v := make([]RetiredKey, len(x.data.Metadata.RetiredXKeys))
+	copy(v, x.data.Metadata.RetiredXKeys)
+	return v
+}
+
+func (x *accountXKeys) Create() (string, error) {
+	if x.Public() != "" {
+		return "", errors.New("account already has a managed xkey")
+	}
+	if x.data.Claim.Authorization.XKey != "" {
+		return "", errors.New("account already has an encryption key that is not managed, use Rotate to replace it")
+	}
+	return x.Rotate(0)
+}
+
+func (x *accountXKeys) Rotate(grace time.Duration) (string, error) {
+	if err := x.data.checkReadOnly("account"); err != nil {
+		return "", err
+	}
+	k, err := x.data.Operator.SigningService.NewKey(nkeys.PrefixByteCurve)
+	if err != nil {
+		return "", err
+	}
+	md := x.data.metadata()
+	now := time.Now()
+	var deleted []string
+	var retired []RetiredKey
+	for _, rk := range md.RetiredXKeys {
+		if rk.Expires > now.Unix() {
+			retired = append(retired, rk)
+		} else {
+			deleted = append(deleted, rk.Key)
+		}
+	}
+	previous := x.Public()
+	if previous != "" {
+		if grace > 0 {
+			retired = append(retired, RetiredKey{Key: previous, Expires: now.Add(grace).Unix()})
+		} else {
+			deleted = append(deleted, previous)
+		}
+	}
+
+	x.data.Claim.Authorization.XKey = k.Public
+	if err := x.data.update(); err != nil {
+		x.data.Claim.Authorization.XKey = previous
+		return "", err
+	}
+	md.RetiredXKeys = retired
+	var keys []*Key
+	for _, v := range x.data.XKeys {
+		drop := false
+		for _, d := range deleted {
+			if v.Public == d {
+				drop = true
+				break
+			}
+		}
+		if !drop {
+			keys = append(keys, v)
+		}
+	}
+	x.data.XKeys = append(keys, k)
+	x.data.Operator.AddedKeys = append(x.data.Operator.AddedKeys, k)
+	x.data.Operator.DeletedKeys = append(x.data.Operator.DeletedKeys, deleted...)
+	return k.Public, nil
+}
+
+func (x *accountXKeys) KeyPairs() []nkeys.KeyPair {
+	var buf []nkeys.KeyPair
+	if k := x.find(x.data.Claim.Authorization.XKey); k != nil {
+		buf = append(buf, k.Pair)
+	}
+	now := time.Now().Unix()
+	for _, rk := range x.Retired() {
+		if rk.Expires <= now {
+			continue
+		}
+		if k := x.find(rk.Key); k != nil {
+			buf = append(buf, k.Pair)
+		}
+	}
+	return buf
+}
+
+func (x *accountXKeys) Seal(data []byte, recipient string) ([]byte, error) {
+	kp, err := x.KeyPair()
+	if err != nil {
+		return nil, err
+	}
+	return kp.Seal(data, recipient)
+}
+
+func (x *accountXKeys) Open(data []byte, sender string) ([]byte, error) {
+	keys := x.KeyPairs()
+	if len(keys) == 0 {
+		return nil, ErrNoXKey
+	}
+	var err error
+	for _, kp := range keys {
+		var d []byte
+		if d, err = kp.Open(data, sender); err == nil {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("unable to open payload with any of the account's xkeys: %w", err)
+}